@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const inviteUsage = `Usage: shop invite create [flags]
+
+Creates an invite code for REGISTRATION_MODE=invite and prints it.
+
+Flags:
+`
+
+func runInvite(cfg config.Config, args []string) {
+	if len(args) == 0 || args[0] != "create" {
+		fmt.Fprint(os.Stderr, inviteUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("invite create", flag.ExitOnError)
+	uses := fs.Int("uses", 1, "how many accounts the code can create")
+	ttl := fs.Duration("ttl", 7*24*time.Hour, "how long the code is valid, 0 for no expiry")
+	code := fs.String("code", "", "use this code instead of a random one")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), inviteUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	if *uses <= 0 {
+		log.Fatal("-uses must be positive")
+	}
+	if *code == "" {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatal(err)
+		}
+		*code = hex.EncodeToString(b)
+	}
+
+	now := time.Now()
+	invite := models.InviteCode{Code: *code, MaxUses: *uses, CreatedAt: now}
+	if *ttl > 0 {
+		expiresAt := now.Add(*ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	if err := storage.NewPostgresStorage(db).CreateInviteCode(context.Background(), invite); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(invite.Code)
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"log"
+	"os"
+	"time"
 
 	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/reconcile"
 	"github.com/mi4r/avito-shop/internal/server"
 	"github.com/mi4r/avito-shop/internal/storage/storage"
 
@@ -12,22 +17,148 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(config.NewConfig(), os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "invite":
+			runInvite(config.NewConfig(), os.Args[2:])
+			return
+		case "unlock":
+			runUnlock(config.NewConfig(), os.Args[2:])
+			return
+		case "password":
+			runPassword(config.NewConfig(), os.Args[2:])
+			return
+		case "role":
+			runRole(config.NewConfig(), os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(config.NewConfig(), os.Args[2:])
+			return
+		}
+	}
+
+	storageType := flag.String("storage", "postgres", "storage backend: postgres or memory")
+	skipMigrations := flag.Bool("skip-migrations", false, "do not apply pending migrations on startup")
+	flag.Parse()
+
 	cfg := config.NewConfig()
-	db, err := sql.Open("postgres", cfg.GetDSN())
+
+	var store storage.Storage
+	switch *storageType {
+	case "postgres":
+		db := openDB(cfg)
+		defer db.Close()
+
+		store = storage.NewPostgresStorage(db)
+		if *skipMigrations {
+			log.Println("Skipping migrations")
+		} else if err := store.Migrate(cfg.GetDSN()); err != nil {
+			log.Fatal(err)
+		}
+	case "memory":
+		log.Println("Using in-memory storage, data will be lost on exit")
+		store = storage.NewMemoryStorage()
+	default:
+		log.Fatalf("unknown storage backend %q", *storageType)
+	}
+
+	go purgeExpiredIdempotencyKeys(store, time.Hour)
+	go purgeExpiredSessions(store, time.Hour, cfg.JWTTTL)
+	go purgeLoginThrottles(store, time.Hour, server.LoginPolicy(cfg).ResetAfter)
+
+	reconciler := reconcile.New(store)
+	go reconcileBalances(reconciler, cfg.ReconcileInterval, cfg.ReconcileCorrect)
+
+	srv, err := server.NewServer(store, cfg, reconciler)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
+	log.Println("Server starting on :8080")
+	log.Fatal(srv.ListenAndServe())
+}
+
+// openDB connects to the PostgreSQL database configured in cfg.
+func openDB(cfg config.Config) *sql.DB {
+	db, err := sql.Open("postgres", cfg.GetDSN())
+	if err != nil {
+		log.Fatal(err)
+	}
 	if err := db.Ping(); err != nil {
 		log.Fatal(err)
 	}
+	return db
+}
 
-	store := storage.NewPostgresStorage(db)
-	store.Migrate(cfg.GetDSN())
+func purgeExpiredIdempotencyKeys(store storage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := store.DeleteExpiredIdempotencyRecords(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("failed to purge idempotency keys: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Purged %d expired idempotency keys", deleted)
+		}
+	}
+}
 
-	srv := server.NewServer(store)
+// purgeExpiredSessions keeps expired sessions for one more access token
+// lifetime, so that access tokens issued right before expiry still resolve.
+func purgeExpiredSessions(store storage.Storage, interval, accessTTL time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	log.Println("Server starting on :8080")
-	log.Fatal(srv.ListenAndServe())
+	for range ticker.C {
+		deleted, err := store.DeleteExpiredSessions(context.Background(), time.Now().Add(-accessTTL))
+		if err != nil {
+			log.Printf("failed to purge sessions: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Purged %d expired sessions", deleted)
+		}
+	}
+}
+
+// purgeLoginThrottles drops failure counters that would be reset anyway.
+func purgeLoginThrottles(store storage.Storage, interval, resetAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := store.DeleteExpiredLoginThrottles(context.Background(), time.Now().Add(-resetAfter))
+		if err != nil {
+			log.Printf("failed to purge login throttles: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Purged %d login throttles", deleted)
+		}
+	}
+}
+
+// reconcileBalances checks balances against the ledger on startup and then
+// every interval. The latest report is served at /api/admin/reconciliation.
+func reconcileBalances(reconciler *reconcile.Reconciler, interval time.Duration, correct bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := reconciler.Run(context.Background(), correct, "reconcile")
+		if err != nil {
+			log.Printf("failed to reconcile balances: %v", err)
+		} else if len(report.Mismatches) > 0 {
+			log.Printf("Found %d balances that do not match the ledger", len(report.Mismatches))
+		}
+		<-ticker.C
+	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const migrateUsage = `Usage: shop migrate <command> [argument]
+
+Commands:
+  status        print the applied and the latest schema version
+  up [N]        apply N pending migrations, all of them by default
+  down [N]      roll back N migrations, 1 by default
+  goto V        migrate up or down to version V
+  force V       set version V without running migrations and clear the dirty flag
+`
+
+// runMigrate returns errors instead of exiting, so that the migrator is
+// closed, releasing its lock and connection, before main exits.
+func runMigrate(cfg config.Config, args []string) (err error) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), migrateUsage) }
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command, arg := fs.Arg(0), fs.Arg(1)
+
+	// Arguments are checked before connecting.
+	var n int
+	switch command {
+	case "status":
+	case "up":
+		n, err = parseIntArg(arg, 0)
+	case "down":
+		n, err = parseIntArg(arg, 1)
+	case "goto", "force":
+		if arg == "" {
+			return fmt.Errorf("migrate %s requires a version", command)
+		}
+		n, err = parseIntArg(arg, 0)
+		if err == nil && command == "goto" && n < 0 {
+			err = fmt.Errorf("invalid version %d", n)
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+
+	migr, err := storage.NewMigrator(cfg.GetDSN())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, migr.Close())
+	}()
+
+	switch command {
+	case "up":
+		err = migr.Up(n)
+	case "down":
+		err = migr.Down(n)
+	case "goto":
+		err = migr.Goto(uint(n))
+	case "force":
+		err = migr.Force(n)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migr.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("version: %d\nlatest: %d\ndirty: %t\n", status.Version, status.Latest, status.Dirty)
+	return nil
+}
+
+func parseIntArg(arg string, def int) (int, error) {
+	if arg == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < -1 {
+		return 0, fmt.Errorf("invalid argument %q", arg)
+	}
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const passwordUsage = `Usage: shop password reset [flags] <username>
+
+Issues a one-time password reset token and prints it. The user sets a new
+password with POST /api/password/reset; an earlier unused token stops working.
+
+Flags:
+`
+
+func runPassword(cfg config.Config, args []string) {
+	if len(args) == 0 || args[0] != "reset" {
+		fmt.Fprint(os.Stderr, passwordUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("password reset", flag.ExitOnError)
+	ttl := fs.Duration("ttl", password.DefaultResetTokenTTL, "how long the token is valid")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), passwordUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *ttl <= 0 {
+		log.Fatal("-ttl must be positive")
+	}
+	username := fs.Arg(0)
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	ctx := context.Background()
+	store := storage.NewPostgresStorage(db)
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	token, record, err := password.NewResetToken(user.ID, now, *ttl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := store.CreatePasswordResetToken(ctx, record); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.CreateAuditEvent(ctx, models.AuditEvent{
+		Type:      models.AuditPasswordResetIssued,
+		Actor:     cliActor(),
+		Subject:   username,
+		CreatedAt: now,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(token)
+}
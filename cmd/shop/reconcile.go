@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/reconcile"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const reconcileUsage = `Usage: shop reconcile [flags]
+
+Compares every user's balance with the ledger and lists the mismatches.
+With -correct, posts an adjustment for each one so that the ledger matches
+the balance the user has been shown. Exits with status 1 if mismatches
+remain.
+
+Flags:
+`
+
+func runReconcile(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	correct := fs.Bool("correct", false, "post adjustments for the mismatches found")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), reconcileUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	reconciler := reconcile.New(storage.NewPostgresStorage(db))
+	report, err := reconciler.Run(context.Background(), *correct, cliActor())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	remaining := 0
+	for _, m := range report.Mismatches {
+		status := "mismatch"
+		if m.Corrected {
+			status = "corrected"
+		} else {
+			remaining++
+		}
+		fmt.Printf("%s\t%s\tcoins %d\tledger %d\tdifference %+d\n", status, m.Username, m.Coins, m.Ledger, m.Difference())
+	}
+	if len(report.Mismatches) == 0 {
+		fmt.Println("All balances match the ledger")
+	}
+	if remaining > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const roleUsage = `Usage: shop role grant|revoke|list <username> [role]
+
+Manages the roles of a user. Roles are admin and auditor; every user has
+the user role. Revoking a role also ends the user's sessions.
+`
+
+func runRole(cfg config.Config, args []string) {
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, roleUsage)
+		os.Exit(2)
+	}
+	command, username := args[0], args[1]
+	var role string
+	switch {
+	case command == "list" && len(args) == 2:
+	case (command == "grant" || command == "revoke") && len(args) == 3:
+		role = args[2]
+	default:
+		fmt.Fprint(os.Stderr, roleUsage)
+		os.Exit(2)
+	}
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	ctx := context.Background()
+	store := storage.NewPostgresStorage(db)
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	switch command {
+	case "grant":
+		if err := store.GrantRole(ctx, user.ID, role, now); err != nil {
+			log.Fatal(err)
+		}
+		auditRoleChange(ctx, store, models.AuditRoleGranted, username, role, now)
+	case "revoke":
+		if err := store.RevokeRole(ctx, user.ID, role); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := store.RevokeUserSessions(ctx, user.ID, now); err != nil {
+			log.Fatal(err)
+		}
+		auditRoleChange(ctx, store, models.AuditRoleRevoked, username, role, now)
+	}
+
+	roles, err := store.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %v\n", username, roles)
+}
+
+func auditRoleChange(ctx context.Context, store storage.Storage, eventType, username, role string, now time.Time) {
+	if err := store.CreateAuditEvent(ctx, models.AuditEvent{
+		Type:      eventType,
+		Actor:     cliActor(),
+		Subject:   username,
+		Details:   map[string]string{"role": role},
+		CreatedAt: now,
+	}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mi4r/avito-shop/internal/config"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/server"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const unlockUsage = `Usage: shop unlock <username>
+
+Clears failed login attempts and any lockout of the account.
+`
+
+func runUnlock(cfg config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, unlockUsage)
+		os.Exit(2)
+	}
+	username := args[0]
+
+	db := openDB(cfg)
+	defer db.Close()
+
+	ctx := context.Background()
+	store := storage.NewPostgresStorage(db)
+	if _, err := store.GetUserByUsername(ctx, username); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := lockout.NewGuard(store, server.LoginPolicy(cfg)).Unlock(ctx, username, cliActor()); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Unlocked %s\n", username)
+}
+
+// cliActor names the operator in audit events written by subcommands.
+func cliActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "cli:" + user
+	}
+	return "cli"
+}
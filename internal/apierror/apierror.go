@@ -0,0 +1,184 @@
+// Package apierror is the catalog of API error responses.
+//
+// Every error is returned as JSON:
+//
+//	{"code": "insufficient_coins", "message": "insufficient coins", "details": ..., "request_id": "..."}
+//
+// Codes are stable and clients should branch on them; messages are meant
+// for humans and may change. The catalog is documented in README.md.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/registration"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+type Error struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithMessage returns a copy of e with another message.
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+// WithDetails returns a copy of e with details attached.
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+var (
+	InvalidRequest   = &Error{Status: http.StatusBadRequest, Code: "invalid_request", Message: "invalid request"}
+	ValidationFailed = &Error{Status: http.StatusBadRequest, Code: "validation_failed", Message: "validation failed"}
+
+	Unauthorized       = &Error{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "Authorization header required"}
+	InvalidToken       = &Error{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "Invalid token"}
+	InvalidCredentials = &Error{Status: http.StatusUnauthorized, Code: "invalid_credentials", Message: "invalid username or password"}
+	TokenRevoked       = &Error{Status: http.StatusUnauthorized, Code: "token_revoked", Message: "Token has been revoked"}
+
+	InvalidRefreshToken = &Error{Status: http.StatusUnauthorized, Code: "invalid_refresh_token", Message: "invalid or expired refresh token"}
+	RefreshTokenReused  = &Error{Status: http.StatusUnauthorized, Code: "refresh_token_reused", Message: "refresh token was already used, session revoked"}
+
+	InvalidChallengeToken = &Error{Status: http.StatusUnauthorized, Code: "invalid_challenge_token", Message: "invalid or expired challenge token"}
+	InvalidTwoFactorCode  = &Error{Status: http.StatusUnauthorized, Code: "invalid_two_factor_code", Message: "invalid or already used two-factor code"}
+
+	UserNotFound = &Error{Status: http.StatusBadRequest, Code: "user_not_found", Message: "user not found"}
+	UserExists   = &Error{Status: http.StatusConflict, Code: "user_exists", Message: "username already exists"}
+	EmailExists  = &Error{Status: http.StatusConflict, Code: "email_exists", Message: "email already registered"}
+	ItemNotFound = &Error{Status: http.StatusBadRequest, Code: "item_not_found", Message: "item not found"}
+	ItemArchived = &Error{Status: http.StatusBadRequest, Code: "item_archived", Message: "item is no longer sold"}
+	ItemExists   = &Error{Status: http.StatusConflict, Code: "item_exists", Message: "item with this name already exists"}
+	OutOfStock   = &Error{Status: http.StatusConflict, Code: "out_of_stock", Message: "item is out of stock"}
+
+	OrderNotFound          = &Error{Status: http.StatusBadRequest, Code: "order_not_found", Message: "order not found"}
+	InvalidOrderTransition = &Error{Status: http.StatusConflict, Code: "invalid_order_transition", Message: "order cannot move to this status"}
+	InsufficientCoins      = &Error{Status: http.StatusBadRequest, Code: "insufficient_coins", Message: "insufficient coins"}
+	BalanceMismatch        = &Error{Status: http.StatusConflict, Code: "balance_mismatch", Message: "balance does not match the ledger and awaits reconciliation"}
+
+	Forbidden = &Error{Status: http.StatusForbidden, Code: "forbidden", Message: "not allowed for your role"}
+
+	WrongPassword     = &Error{Status: http.StatusForbidden, Code: "wrong_password", Message: "current password is incorrect"}
+	InvalidResetToken = &Error{Status: http.StatusBadRequest, Code: "invalid_reset_token", Message: "password reset token is invalid, expired or used"}
+
+	RegistrationNotAllowed = &Error{Status: http.StatusForbidden, Code: "registration_not_allowed", Message: "registration is not allowed"}
+	InviteCodeInvalid      = &Error{Status: http.StatusForbidden, Code: "invite_code_invalid", Message: "invite code is invalid, expired or used up"}
+
+	TooManyAttempts = &Error{Status: http.StatusTooManyRequests, Code: "too_many_attempts", Message: "too many failed login attempts, try again later"}
+	AccountLocked   = &Error{Status: http.StatusTooManyRequests, Code: "account_locked", Message: "account is temporarily locked after too many failed login attempts"}
+
+	TwoFactorEnabled    = &Error{Status: http.StatusConflict, Code: "two_factor_enabled", Message: "two-factor authentication is already enabled"}
+	TwoFactorNotEnabled = &Error{Status: http.StatusConflict, Code: "two_factor_not_enabled", Message: "two-factor authentication is not enabled"}
+
+	RoleNotGranted = &Error{Status: http.StatusConflict, Code: "role_not_granted", Message: "user does not have this role"}
+
+	IdempotencyKeyReused     = &Error{Status: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Message: "Idempotency key was used with a different request"}
+	IdempotencyKeyInProgress = &Error{Status: http.StatusConflict, Code: "idempotency_key_in_progress", Message: "Request with this idempotency key is in progress"}
+
+	RequestTooLarge = &Error{Status: http.StatusRequestEntityTooLarge, Code: "request_too_large", Message: "request body is too large"}
+
+	Internal = &Error{Status: http.StatusInternalServerError, Code: "internal_error", Message: "internal error"}
+)
+
+// Lookup maps storage sentinels and validation errors to the catalog.
+// It returns nil for errors the catalog does not know about.
+func Lookup(err error) *Error {
+	var apiErr *Error
+	var fields validation.Errors
+	var throttled *lockout.ThrottledError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &fields):
+		return ValidationFailed.WithDetails(fields)
+	case errors.As(err, &throttled):
+		if throttled.Locked {
+			return AccountLocked
+		}
+		return TooManyAttempts
+	case errors.Is(err, storage.ErrUserNotFound):
+		return UserNotFound
+	case errors.Is(err, storage.ErrUserExists):
+		return UserExists
+	case errors.Is(err, storage.ErrEmailExists):
+		return EmailExists
+	case errors.Is(err, storage.ErrInviteCodeInvalid):
+		return InviteCodeInvalid
+	case errors.Is(err, storage.ErrResetTokenInvalid):
+		return InvalidResetToken
+	case errors.Is(err, storage.ErrTOTPEnabled):
+		return TwoFactorEnabled
+	case errors.Is(err, storage.ErrTOTPNotFound):
+		return TwoFactorNotEnabled
+	case errors.Is(err, storage.ErrTOTPCodeReused),
+		errors.Is(err, storage.ErrRecoveryCodeInvalid):
+		return InvalidTwoFactorCode
+	case errors.Is(err, storage.ErrRoleNotGranted):
+		return RoleNotGranted
+	case errors.Is(err, registration.ErrNotAllowed):
+		return RegistrationNotAllowed
+	case errors.Is(err, storage.ErrItemNotFound):
+		return ItemNotFound
+	case errors.Is(err, storage.ErrItemArchived):
+		return ItemArchived
+	case errors.Is(err, storage.ErrItemExists):
+		return ItemExists
+	case errors.Is(err, storage.ErrOutOfStock):
+		return OutOfStock
+	case errors.Is(err, storage.ErrOrderNotFound):
+		return OrderNotFound
+	case errors.Is(err, storage.ErrOrderStatusTransition):
+		return InvalidOrderTransition
+	case errors.Is(err, storage.ErrInsufficientCoins):
+		return InsufficientCoins
+	case errors.Is(err, storage.ErrBalanceMismatch):
+		return BalanceMismatch
+	case errors.Is(err, storage.ErrRefreshTokenReused):
+		return RefreshTokenReused
+	case errors.Is(err, storage.ErrRefreshTokenNotFound),
+		errors.Is(err, storage.ErrRefreshTokenExpired),
+		errors.Is(err, storage.ErrSessionRevoked):
+		return InvalidRefreshToken
+	}
+	return nil
+}
+
+// FromError is Lookup falling back to fallback, or to Internal if fallback is nil.
+func FromError(err error, fallback *Error) *Error {
+	if e := Lookup(err); e != nil {
+		return e
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return Internal
+}
+
+// Write sends e as the response, tagged with the request ID if there is one.
+func Write(w http.ResponseWriter, r *http.Request, e *Error) {
+	resp := *e
+	resp.RequestID = chimw.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status)
+	json.NewEncoder(w).Encode(resp)
+}
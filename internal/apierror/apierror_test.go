@@ -0,0 +1,102 @@
+package apierror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/registration"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected *apierror.Error
+	}{
+		{"user not found", storage.ErrUserNotFound, apierror.UserNotFound},
+		{"wrapped insufficient coins", fmt.Errorf("send: %w", storage.ErrInsufficientCoins), apierror.InsufficientCoins},
+		{"item not found", storage.ErrItemNotFound, apierror.ItemNotFound},
+		{"user exists", storage.ErrUserExists, apierror.UserExists},
+		{"email exists", storage.ErrEmailExists, apierror.EmailExists},
+		{"invite code invalid", storage.ErrInviteCodeInvalid, apierror.InviteCodeInvalid},
+		{"reset token invalid", storage.ErrResetTokenInvalid, apierror.InvalidResetToken},
+		{"2FA already enabled", storage.ErrTOTPEnabled, apierror.TwoFactorEnabled},
+		{"2FA not enrolled", storage.ErrTOTPNotFound, apierror.TwoFactorNotEnabled},
+		{"TOTP code reused", storage.ErrTOTPCodeReused, apierror.InvalidTwoFactorCode},
+		{"item archived", storage.ErrItemArchived, apierror.ItemArchived},
+		{"item exists", storage.ErrItemExists, apierror.ItemExists},
+		{"out of stock", storage.ErrOutOfStock, apierror.OutOfStock},
+		{"order not found", storage.ErrOrderNotFound, apierror.OrderNotFound},
+		{"order transition", storage.ErrOrderStatusTransition, apierror.InvalidOrderTransition},
+		{"balance mismatch", fmt.Errorf("%w: user:1 caches 5000 coins, the ledger has 1000", storage.ErrBalanceMismatch), apierror.BalanceMismatch},
+		{"role not granted", storage.ErrRoleNotGranted, apierror.RoleNotGranted},
+		{"registration not allowed", registration.ErrNotAllowed, apierror.RegistrationNotAllowed},
+		{"refresh token reused", storage.ErrRefreshTokenReused, apierror.RefreshTokenReused},
+		{"refresh token expired", storage.ErrRefreshTokenExpired, apierror.InvalidRefreshToken},
+		{"session revoked", storage.ErrSessionRevoked, apierror.InvalidRefreshToken},
+		{"login throttled", &lockout.ThrottledError{RetryAfter: time.Second}, apierror.TooManyAttempts},
+		{"account locked", fmt.Errorf("check: %w", &lockout.ThrottledError{RetryAfter: time.Minute, Locked: true}), apierror.AccountLocked},
+		{"catalog error", fmt.Errorf("wrapped: %w", apierror.InvalidToken), apierror.InvalidToken},
+		{"unknown", errors.New("boom"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, apierror.Lookup(tt.err))
+		})
+	}
+
+	t.Run("validation errors become details", func(t *testing.T) {
+		err := validation.Transfer("alice", "alice", 0)
+		e := apierror.Lookup(fmt.Errorf("wrapped: %w", err))
+		require.NotNil(t, e)
+		assert.Equal(t, "validation_failed", e.Code)
+		assert.Equal(t, http.StatusBadRequest, e.Status)
+		assert.Len(t, e.Details, 2)
+	})
+}
+
+func TestFromError(t *testing.T) {
+	assert.Equal(t, apierror.Internal, apierror.FromError(errors.New("boom"), nil))
+
+	fallback := apierror.Internal.WithMessage("purchase failed")
+	assert.Equal(t, fallback, apierror.FromError(errors.New("boom"), fallback))
+	assert.Equal(t, apierror.ItemNotFound, apierror.FromError(storage.ErrItemNotFound, fallback))
+	assert.Equal(t, "internal error", apierror.Internal.Message, "WithMessage must not modify the catalog")
+}
+
+func TestWrite(t *testing.T) {
+	var rr *httptest.ResponseRecorder
+	handler := chimw.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rr = httptest.NewRecorder()
+		apierror.Write(rr, r, apierror.InsufficientCoins)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(chimw.RequestIDHeader, "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{
+		"code":       "insufficient_coins",
+		"message":    "insufficient coins",
+		"request_id": "req-42",
+	}, body)
+}
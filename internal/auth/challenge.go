@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ChallengeTTL is how long a two-factor challenge token can be exchanged.
+const ChallengeTTL = 5 * time.Minute
+
+// challengeAudience keeps challenge tokens from being accepted as access
+// tokens, here or by other services that verify tokens with the JWKS.
+func (m *TokenManager) challengeAudience() string {
+	return m.cfg.Audience + "/2fa"
+}
+
+// IssueChallenge signs a token proving that username has passed the
+// password check and still has to present a second factor.
+func (m *TokenManager) IssueChallenge(username string) (string, time.Time, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := m.now()
+	expiresAt := now.Add(ChallengeTTL)
+	token, err := m.sign(&Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   username,
+			Issuer:    m.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{m.challengeAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseChallenge verifies a challenge token and returns its username.
+func (m *TokenManager) ParseChallenge(token string) (string, error) {
+	claims, err := m.parse(token, m.challengeAudience())
+	if err != nil {
+		return "", err
+	}
+	return claims.Username, nil
+}
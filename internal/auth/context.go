@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+type claimsKey struct{}
+
+type principalKey struct{}
+
+// Principal is the authenticated user of a request.
+type Principal struct {
+	Username  string
+	SessionID string
+	Roles     []string
+}
+
+// HasRole reports whether the principal has any of roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal returns the user the claims were issued to.
+func (c *Claims) Principal() *Principal {
+	return &Principal{Username: c.Username, SessionID: c.SessionID, Roles: c.Roles}
+}
+
+// WithClaims returns a copy of ctx carrying the claims of the authenticated
+// request and the principal they describe.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, claimsKey{}, claims)
+	return context.WithValue(ctx, principalKey{}, claims.Principal())
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims, or nil.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
+
+// PrincipalFromContext returns the principal stored by WithClaims, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
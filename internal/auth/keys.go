@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinRSAKeyBits is the smallest RSA modulus accepted for RS256.
+const MinRSAKeyBits = 2048
+
+var ErrUnsupportedKey = errors.New("unsupported key type, expected RSA or Ed25519")
+
+// Key is an asymmetric JWT key. Its ID is the RFC 7638 thumbprint of the
+// public key and is sent as the kid header of tokens signed with it.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private is nil for keys that only verify tokens.
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key.
+func NewSigningKey(private crypto.Signer) (Key, error) {
+	key, err := NewVerificationKey(private.Public())
+	if err != nil {
+		return Key{}, err
+	}
+	key.Private = private
+	return key, nil
+}
+
+// NewVerificationKey wraps an RSA or Ed25519 public key.
+func NewVerificationKey(public crypto.PublicKey) (Key, error) {
+	var key Key
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < MinRSAKeyBits {
+			return Key{}, fmt.Errorf("RSA key must be at least %d bits", MinRSAKeyBits)
+		}
+		key = Key{Method: jwt.SigningMethodRS256, Public: pub}
+	case ed25519.PublicKey:
+		key = Key{Method: jwt.SigningMethodEdDSA, Public: pub}
+	default:
+		return Key{}, ErrUnsupportedKey
+	}
+	key.ID = thumbprint(key.jwk())
+	return key, nil
+}
+
+// ParsePEMKey reads a PKCS#8 or PKCS#1 private key, or a PKIX public key.
+func ParsePEMKey(data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return Key{}, ErrUnsupportedKey
+		}
+		return NewSigningKey(signer)
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewSigningKey(private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		return NewVerificationKey(public)
+	}
+	return Key{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// LoadKeyFile reads a key with ParsePEMKey.
+func LoadKeyFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParsePEMKey(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public part of the key as a JWK.
+func (k Key) JWK() JWK {
+	jwk := k.jwk()
+	jwk.Use = "sig"
+	jwk.Alg = k.Method.Alg()
+	jwk.Kid = k.ID
+	return jwk
+}
+
+// jwk returns only the members required by RFC 7638.
+func (k Key) jwk() JWK {
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint computes the RFC 7638 thumbprint: the SHA-256 of the required
+// members in lexicographic order without whitespace.
+func thumbprint(jwk JWK) string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,209 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/auth"
+)
+
+func newRSAKey(t *testing.T) auth.Key {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := auth.NewSigningKey(private)
+	require.NoError(t, err)
+	return key
+}
+
+func newEd25519Key(t *testing.T) auth.Key {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := auth.NewSigningKey(private)
+	require.NoError(t, err)
+	return key
+}
+
+func managerWithKeys(signing *auth.Key, verification ...auth.Key) *auth.TokenManager {
+	return auth.NewTokenManager(auth.TokenConfig{
+		SigningKey:       signing,
+		VerificationKeys: verification,
+		TTL:              time.Hour,
+		Issuer:           "issuer",
+		Audience:         "audience",
+	})
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	tests := []struct {
+		name string
+		key  func(t *testing.T) auth.Key
+		alg  string
+	}{
+		{"RS256", newRSAKey, "RS256"},
+		{"EdDSA", newEd25519Key, "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key(t)
+			tokens := managerWithKeys(&key)
+
+			token, _, err := tokens.Issue("alice", "session-1", nil)
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.alg, parsed.Header["alg"])
+			assert.Equal(t, key.ID, parsed.Header["kid"])
+
+			claims, err := tokens.Parse(token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Username)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newRSAKey(t)
+	newKey := newEd25519Key(t)
+
+	oldToken, _, err := managerWithKeys(&oldKey).Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	// While rotating, the old key is kept for verification only.
+	rotated := managerWithKeys(&newKey, auth.Key{ID: oldKey.ID, Method: oldKey.Method, Public: oldKey.Public})
+	_, err = rotated.Parse(oldToken)
+	assert.NoError(t, err)
+
+	newToken, _, err := rotated.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	_, err = rotated.Parse(newToken)
+	assert.NoError(t, err)
+
+	// Once the old key is dropped its tokens are rejected.
+	_, err = managerWithKeys(&newKey).Parse(oldToken)
+	assert.Error(t, err)
+
+	unknown := newEd25519Key(t)
+	foreignToken, _, err := managerWithKeys(&unknown).Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	_, err = rotated.Parse(foreignToken)
+	assert.ErrorIs(t, err, auth.ErrUnknownKey)
+}
+
+func TestParseRejectsKeyMismatch(t *testing.T) {
+	key := newRSAKey(t)
+	tokens := managerWithKeys(&key)
+
+	t.Run("HS256 without a secret", func(t *testing.T) {
+		token, _, err := newTokenManager(time.Hour).Issue("alice", "session-1", nil)
+		require.NoError(t, err)
+		_, err = tokens.Parse(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing kid", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"username": "alice"}).
+			SignedString(key.Private)
+		require.NoError(t, err)
+		_, err = tokens.Parse(token)
+		assert.ErrorIs(t, err, auth.ErrUnknownKey)
+	})
+
+	t.Run("algorithm does not match the key", func(t *testing.T) {
+		other := newEd25519Key(t)
+		t2 := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"username": "alice"})
+		t2.Header["kid"] = key.ID
+		token, err := t2.SignedString(other.Private)
+		require.NoError(t, err)
+		_, err = managerWithKeys(&key, other).Parse(token)
+		assert.ErrorIs(t, err, auth.ErrUnknownKey)
+	})
+}
+
+func TestParsePEMKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8 := func(k crypto.Signer) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	pkix := func(k crypto.PublicKey) []byte {
+		der, err := x509.MarshalPKIXPublicKey(k)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+
+	t.Run("RSA private key", func(t *testing.T) {
+		for _, data := range [][]byte{
+			pkcs8(rsaKey),
+			pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		} {
+			key, err := auth.ParsePEMKey(data)
+			require.NoError(t, err)
+			assert.Equal(t, jwt.SigningMethodRS256, key.Method)
+			assert.NotNil(t, key.Private)
+		}
+	})
+
+	t.Run("public key has the same ID as its private key", func(t *testing.T) {
+		private, err := auth.ParsePEMKey(pkcs8(edPrivate))
+		require.NoError(t, err)
+		public, err := auth.ParsePEMKey(pkix(edPublic))
+		require.NoError(t, err)
+
+		assert.Equal(t, private.ID, public.ID)
+		assert.Nil(t, public.Private)
+		assert.Equal(t, jwt.SigningMethodEdDSA, public.Method)
+	})
+
+	t.Run("rejects small RSA keys", func(t *testing.T) {
+		small, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+		_, err = auth.ParsePEMKey(pkcs8(small))
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects garbage", func(t *testing.T) {
+		_, err := auth.ParsePEMKey([]byte("not a key"))
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+
+	set := managerWithKeys(&rsaKey, edKey, rsaKey).JWKS()
+	require.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+	assert.Equal(t, "sig", set.Keys[0].Use)
+	assert.Equal(t, rsaKey.ID, set.Keys[0].Kid)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+	assert.NotEmpty(t, set.Keys[0].N)
+
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+	assert.Equal(t, "EdDSA", set.Keys[1].Alg)
+	assert.Equal(t, edKey.ID, set.Keys[1].Kid)
+
+	assert.Empty(t, newTokenManager(time.Hour).JWKS().Keys, "HMAC keys are never published")
+}
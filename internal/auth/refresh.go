@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// NewSession starts a session for the user and returns it together with
+// its first refresh token and the record to store for that token.
+func (m *TokenManager) NewSession(userID int) (models.Session, string, models.RefreshToken, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return models.Session{}, "", models.RefreshToken{}, err
+	}
+
+	token, record, err := m.NewRefreshToken()
+	if err != nil {
+		return models.Session{}, "", models.RefreshToken{}, err
+	}
+	record.SessionID = id
+
+	session := models.Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+	}
+	return session, token, record, nil
+}
+
+// NewRefreshToken generates a refresh token and the record to store for it.
+// The session of the record is left for the caller to fill in.
+func (m *TokenManager) NewRefreshToken() (string, models.RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", models.RefreshToken{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := m.now()
+	record := models.RefreshToken{
+		TokenHash: HashRefreshToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.cfg.RefreshTTL),
+	}
+	return token, record, nil
+}
+
+// HashRefreshToken returns the form in which refresh tokens are stored.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,199 @@
+// Package auth issues and verifies the JWT access tokens of the API and the
+// opaque refresh tokens that renew them.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMissingUsername is returned for otherwise valid tokens without a username.
+	ErrMissingUsername = errors.New("token has no username")
+	// ErrMissingSession is returned for otherwise valid tokens without a session ID.
+	ErrMissingSession = errors.New("token has no session")
+	// ErrUnknownKey is returned for tokens signed with a key that is not configured.
+	ErrUnknownKey = errors.New("token signed with unknown key")
+)
+
+// Claims are the claims of an access token.
+type Claims struct {
+	Username string `json:"username"`
+	// SessionID ties the token to the session it was issued for, so that
+	// revoking the session revokes the token.
+	SessionID string `json:"sid"`
+	// Roles are the user's roles when the token was issued. Role changes
+	// reach the token on the next login or refresh.
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type TokenConfig struct {
+	// Key is the HMAC key used to sign and verify HS256 tokens. HS256 tokens
+	// are rejected when it is empty.
+	Key []byte
+	// SigningKey, if set, signs tokens with RS256 or EdDSA instead of HS256.
+	SigningKey *Key
+	// VerificationKeys are accepted in addition to SigningKey, e.g. the
+	// previous key while tokens signed with it are still in use.
+	VerificationKeys []Key
+
+	TTL      time.Duration
+	Issuer   string
+	Audience string
+	// RefreshTTL is how long a refresh token stays valid.
+	RefreshTTL time.Duration
+}
+
+// TokenManager issues access tokens and verifies them, enforcing the
+// algorithm, key ID, expiry, issuer and audience.
+type TokenManager struct {
+	cfg     TokenConfig
+	now     func() time.Time
+	keys    map[string]Key
+	methods []string
+}
+
+func NewTokenManager(cfg TokenConfig) *TokenManager {
+	m := &TokenManager{cfg: cfg, now: time.Now, keys: make(map[string]Key), methods: []string{}}
+
+	if len(cfg.Key) > 0 {
+		m.methods = append(m.methods, jwt.SigningMethodHS256.Alg())
+	}
+	keys := cfg.VerificationKeys
+	if cfg.SigningKey != nil {
+		keys = append([]Key{*cfg.SigningKey}, keys...)
+	}
+	for _, key := range keys {
+		if _, ok := m.keys[key.ID]; ok {
+			continue
+		}
+		m.keys[key.ID] = key
+		if !slices.Contains(m.methods, key.Method.Alg()) {
+			m.methods = append(m.methods, key.Method.Alg())
+		}
+	}
+	return m
+}
+
+// JWKS returns the public keys that verify tokens, for other services.
+func (m *TokenManager) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	seen := make(map[string]bool)
+	if m.cfg.SigningKey != nil {
+		set.Keys = append(set.Keys, m.cfg.SigningKey.JWK())
+		seen[m.cfg.SigningKey.ID] = true
+	}
+	for _, key := range m.cfg.VerificationKeys {
+		if !seen[key.ID] {
+			set.Keys = append(set.Keys, key.JWK())
+			seen[key.ID] = true
+		}
+	}
+	return set
+}
+
+// Issue signs a new access token for username within the given session.
+func (m *TokenManager) Issue(username, sessionID string, roles []string) (string, *Claims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := m.now()
+	claims := &Claims{
+		Username:  username,
+		SessionID: sessionID,
+		Roles:     roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   username,
+			Issuer:    m.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{m.cfg.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.cfg.TTL)),
+		},
+	}
+
+	token, err := m.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// Parse verifies token and returns its claims.
+func (m *TokenManager) Parse(token string) (*Claims, error) {
+	claims, err := m.parse(token, m.cfg.Audience)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, ErrMissingSession
+	}
+	return claims, nil
+}
+
+func (m *TokenManager) sign(claims *Claims) (string, error) {
+	if key := m.cfg.SigningKey; key != nil {
+		t := jwt.NewWithClaims(key.Method, claims)
+		t.Header["kid"] = key.ID
+		return t.SignedString(key.Private)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.cfg.Key)
+}
+
+// parse verifies a token for the given audience.
+func (m *TokenManager) parse(token, audience string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, m.verificationKey,
+		jwt.WithValidMethods(m.methods),
+		jwt.WithIssuer(m.cfg.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(m.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Username == "" {
+		return nil, ErrMissingUsername
+	}
+	return claims, nil
+}
+
+// verificationKey picks the key for a token: the HMAC key for HS256 and the
+// key named by the kid header otherwise.
+func (m *TokenManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if len(m.cfg.Key) == 0 {
+			return nil, ErrUnknownKey
+		}
+		return m.cfg.Key, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.Method != token.Method {
+		return nil, ErrUnknownKey
+	}
+	return key.Public, nil
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/auth"
+)
+
+func newTokenManager(ttl time.Duration) *auth.TokenManager {
+	return auth.NewTokenManager(auth.TokenConfig{
+		Key:        []byte("auth-test-secret-0123456789abcdef"),
+		TTL:        ttl,
+		Issuer:     "issuer",
+		Audience:   "audience",
+		RefreshTTL: 24 * time.Hour,
+	})
+}
+
+func TestIssueAndParse(t *testing.T) {
+	tokens := newTokenManager(15 * time.Minute)
+
+	token, issued, err := tokens.Issue("alice", "session-1", []string{"user", "admin"})
+	require.NoError(t, err)
+
+	claims, err := tokens.Parse(token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Username)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, "issuer", claims.Issuer)
+	assert.Equal(t, jwt.ClaimStrings{"audience"}, claims.Audience)
+	assert.Equal(t, "session-1", claims.SessionID)
+	assert.Equal(t, []string{"user", "admin"}, claims.Roles)
+	assert.Equal(t, issued.ID, claims.ID)
+	assert.Len(t, claims.ID, 32)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), claims.ExpiresAt.Time, 2*time.Second)
+
+	_, other, err := tokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, issued.ID, other.ID, "every token gets its own jti")
+}
+
+func TestParseRejectsForeignTokens(t *testing.T) {
+	token, _, err := newTokenManager(time.Hour).Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	otherAudience := auth.NewTokenManager(auth.TokenConfig{
+		Key:      []byte("auth-test-secret-0123456789abcdef"),
+		TTL:      time.Hour,
+		Issuer:   "issuer",
+		Audience: "another-service",
+	})
+	_, err = otherAudience.Parse(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	expired, _, err := newTokenManager(-time.Minute).Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	_, err = newTokenManager(time.Hour).Parse(expired)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestParseRejectsTokensWithoutSession(t *testing.T) {
+	tokens := newTokenManager(time.Hour)
+	token, _, err := tokens.Issue("alice", "", nil)
+	require.NoError(t, err)
+
+	_, err = tokens.Parse(token)
+	assert.ErrorIs(t, err, auth.ErrMissingSession)
+}
+
+func TestChallenge(t *testing.T) {
+	tokens := newTokenManager(time.Hour)
+
+	challenge, expiresAt, err := tokens.IssueChallenge("alice")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(auth.ChallengeTTL), expiresAt, 2*time.Second)
+
+	username, err := tokens.ParseChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+
+	_, err = tokens.Parse(challenge)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience, "a challenge is not an access token")
+
+	access, _, err := tokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	_, err = tokens.ParseChallenge(access)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience, "an access token is not a challenge")
+}
+
+func TestNewSession(t *testing.T) {
+	tokens := newTokenManager(time.Hour)
+
+	session, token, record, err := tokens.NewSession(42)
+	require.NoError(t, err)
+	assert.Equal(t, 42, session.UserID)
+	assert.Len(t, session.ID, 32)
+	assert.Equal(t, session.ID, record.SessionID)
+	assert.Equal(t, auth.HashRefreshToken(token), record.TokenHash)
+	assert.NotContains(t, record.TokenHash, token, "only the hash is stored")
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), record.ExpiresAt, 2*time.Second)
+	assert.True(t, session.ExpiresAt.Equal(record.ExpiresAt))
+
+	next, nextRecord, err := tokens.NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, next)
+	assert.Empty(t, nextRecord.SessionID)
+}
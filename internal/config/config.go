@@ -1,8 +1,34 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultJWTTTL        = time.Hour
+	DefaultJWTRefreshTTL = 30 * 24 * time.Hour
+	DefaultJWTIssuer     = "avito-shop"
+	DefaultJWTAudience   = "avito-shop"
+
+	// MinJWTSecretLength is the shortest accepted HS256 key, in bytes.
+	MinJWTSecretLength = 32
+
+	DefaultRegistrationMode = "open"
+
+	DefaultLoginMaxFailures     = 10
+	DefaultLoginLockoutDuration = 15 * time.Minute
+
+	DefaultPasswordHashScheme = "argon2id"
+
+	DefaultTwoFactorIssuer = "Avito Shop"
+
+	DefaultReconcileInterval = time.Hour
 )
 
 type Config struct {
@@ -12,6 +38,52 @@ type Config struct {
 	DBName     string
 	DBPort     string
 	// DBPath     string
+
+	// JWTSecret is the HS256 signing key. If empty, it is read from JWTSecretFile.
+	JWTSecret     string
+	JWTSecretFile string
+	JWTTTL        time.Duration
+	JWTIssuer     string
+	JWTAudience   string
+	// JWTRefreshTTL is the lifetime of refresh tokens; each refresh extends the session.
+	JWTRefreshTTL time.Duration
+
+	// JWTSigningKeyFile is a PEM RSA or Ed25519 private key. When set, tokens
+	// are signed with it (RS256 or EdDSA) and the secret becomes optional.
+	JWTSigningKeyFile string
+	// JWTVerificationKeyFiles are PEM keys that are still accepted, e.g. the
+	// previous signing key during rotation.
+	JWTVerificationKeyFiles []string
+
+	// RegistrationMode is open, invite or allowlist.
+	RegistrationMode string
+	// RegistrationAllowlist holds usernames and "@domain" entries for the allowlist mode.
+	RegistrationAllowlist []string
+
+	// LoginMaxFailures failed logins in a row lock the account for LoginLockoutDuration.
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For or X-Real-IP.
+	// Enable it only behind a proxy that sets these headers.
+	TrustProxyHeaders bool
+
+	// PasswordHashScheme is argon2id or bcrypt. Hashes in the other scheme,
+	// or with other parameters, are upgraded when their users log in.
+	PasswordHashScheme string
+	// Zero values leave the parameters at their defaults.
+	PasswordBcryptCost     int
+	PasswordArgon2Time     int
+	PasswordArgon2MemoryKB int
+	PasswordArgon2Threads  int
+
+	// TwoFactorIssuer names the service in authenticator apps.
+	TwoFactorIssuer string
+
+	// ReconcileInterval is how often balances are checked against the ledger.
+	ReconcileInterval time.Duration
+	// ReconcileCorrect makes the scheduled check post adjustments for the
+	// mismatches it finds instead of only reporting them.
+	ReconcileCorrect bool
 }
 
 func NewConfig() Config {
@@ -21,6 +93,34 @@ func NewConfig() Config {
 		DBHost:     os.Getenv("DATABASE_HOST"),
 		DBName:     os.Getenv("DATABASE_NAME"),
 		DBPort:     os.Getenv("DATABASE_PORT"),
+
+		JWTSecret:     os.Getenv("JWT_SECRET"),
+		JWTSecretFile: os.Getenv("JWT_SECRET_FILE"),
+		JWTTTL:        getDuration("JWT_TTL", DefaultJWTTTL),
+		JWTIssuer:     getString("JWT_ISSUER", DefaultJWTIssuer),
+		JWTAudience:   getString("JWT_AUDIENCE", DefaultJWTAudience),
+		JWTRefreshTTL: getDuration("JWT_REFRESH_TTL", DefaultJWTRefreshTTL),
+
+		JWTSigningKeyFile:       os.Getenv("JWT_SIGNING_KEY_FILE"),
+		JWTVerificationKeyFiles: getList("JWT_VERIFICATION_KEY_FILES"),
+
+		RegistrationMode:      getString("REGISTRATION_MODE", DefaultRegistrationMode),
+		RegistrationAllowlist: getList("REGISTRATION_ALLOWLIST"),
+
+		LoginMaxFailures:     getInt("LOGIN_MAX_FAILURES", DefaultLoginMaxFailures),
+		LoginLockoutDuration: getDuration("LOGIN_LOCKOUT_DURATION", DefaultLoginLockoutDuration),
+		TrustProxyHeaders:    getBool("TRUST_PROXY_HEADERS"),
+
+		PasswordHashScheme:     getString("PASSWORD_HASH_SCHEME", DefaultPasswordHashScheme),
+		PasswordBcryptCost:     getInt("PASSWORD_BCRYPT_COST", 0),
+		PasswordArgon2Time:     getInt("PASSWORD_ARGON2_TIME", 0),
+		PasswordArgon2MemoryKB: getInt("PASSWORD_ARGON2_MEMORY_KB", 0),
+		PasswordArgon2Threads:  getInt("PASSWORD_ARGON2_THREADS", 0),
+
+		TwoFactorIssuer: getString("TWO_FACTOR_ISSUER", DefaultTwoFactorIssuer),
+
+		ReconcileInterval: getDuration("RECONCILE_INTERVAL", DefaultReconcileInterval),
+		ReconcileCorrect:  getBool("RECONCILE_CORRECT"),
 	}
 }
 
@@ -33,3 +133,86 @@ func (c *Config) GetIntegDSN() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		c.DBUser, c.DBPassword, c.DBHost, 5433, c.DBName)
 }
+
+// HasJWTSecret reports whether an HMAC secret is configured.
+func (c *Config) HasJWTSecret() bool {
+	return c.JWTSecret != "" || c.JWTSecretFile != ""
+}
+
+// GetJWTKey returns JWTSecret, or the contents of JWTSecretFile if the
+// secret is not set directly.
+func (c *Config) GetJWTKey() ([]byte, error) {
+	secret := c.JWTSecret
+	if secret == "" && c.JWTSecretFile != "" {
+		data, err := os.ReadFile(c.JWTSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT secret file: %w", err)
+		}
+		secret = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET or JWT_SECRET_FILE must be set")
+	}
+	if len(secret) < MinJWTSecretLength {
+		return nil, fmt.Errorf("JWT secret must be at least %d bytes long", MinJWTSecretLength)
+	}
+	return []byte(secret), nil
+}
+
+func getString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getList splits a comma-separated variable, skipping empty entries.
+func getList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getBool(key string) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using false", key, v)
+		return false
+	}
+	return b
+}
+
+func getDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid %s %q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
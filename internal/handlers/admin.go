@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// AuditEventsHandler lists audit events, newest first, optionally filtered
+// by type and subject.
+func AuditEventsHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := models.AuditFilter{
+			Type:    q.Get("type"),
+			Subject: q.Get("subject"),
+			Limit:   defaultAuditLimit,
+		}
+		if s := q.Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit < 1 || limit > maxAuditLimit {
+				var v validation.Validator
+				v.Add("limit", validation.CodeInvalidFormat,
+					fmt.Sprintf("must be a number from 1 to %d", maxAuditLimit))
+				respondWithError(w, r, apierror.FromError(v.Err(), nil))
+				return
+			}
+			filter.Limit = limit
+		}
+
+		events, err := store.ListAuditEvents(r.Context(), filter)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to list audit events"))
+			return
+		}
+		if events == nil {
+			events = []models.AuditEvent{}
+		}
+		respondWithJSON(w, http.StatusOK, events)
+	}
+}
+
+// GrantRoleHandler grants the role in the URL. The user gets it in their
+// tokens on the next login or refresh.
+func GrantRoleHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := adminTarget(w, r, store)
+		if !ok {
+			return
+		}
+		role := chi.URLParam(r, "role")
+
+		now := time.Now()
+		if err := store.GrantRole(r.Context(), user.ID, role, now); err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to grant role")))
+			return
+		}
+		auditRoleChange(r, store, models.AuditRoleGranted, user.Username, role, now)
+		respondWithRoles(w, r, store, user)
+	}
+}
+
+// RevokeRoleHandler revokes the role in the URL together with the user's
+// sessions, so that tokens carrying the role stop working at once. The role,
+// the sessions and the audit event change together or not at all.
+func RevokeRoleHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := adminTarget(w, r, store)
+		if !ok {
+			return
+		}
+		role := chi.URLParam(r, "role")
+
+		now := time.Now()
+		err := store.InTx(r.Context(), nil, func(tx storage.Storage) error {
+			if err := tx.RevokeRole(r.Context(), user.ID, role); err != nil {
+				return err
+			}
+			if _, err := tx.RevokeUserSessions(r.Context(), user.ID, now); err != nil {
+				return err
+			}
+			return tx.CreateAuditEvent(r.Context(), roleChangeEvent(r, models.AuditRoleRevoked, user.Username, role, now))
+		})
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to revoke role")))
+			return
+		}
+		respondWithRoles(w, r, store, user)
+	}
+}
+
+// UnlockUserHandler clears failed logins and any lockout of the user.
+func UnlockUserHandler(store storage.Storage, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := adminTarget(w, r, store)
+		if !ok {
+			return
+		}
+
+		actor := auth.PrincipalFromContext(r.Context()).Username
+		if err := guard.Unlock(r.Context(), user.Username, actor); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to unlock"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// IssuePasswordResetHandler issues a one-time password reset token for the
+// user, to be handed over out of band. An earlier unused token stops working.
+func IssuePasswordResetHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := adminTarget(w, r, store)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		token, record, err := password.NewResetToken(user.ID, now, password.DefaultResetTokenTTL)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+		if err := store.CreatePasswordResetToken(r.Context(), record); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditPasswordResetIssued,
+			Actor:     auth.PrincipalFromContext(r.Context()).Username,
+			Subject:   user.Username,
+			IP:        clientIP(r),
+			CreatedAt: now,
+		})
+
+		respondWithJSON(w, http.StatusOK, models.PasswordResetIssued{Token: token, ExpiresAt: record.ExpiresAt})
+	}
+}
+
+// adminTarget loads the user named in the URL. If it returns false, the
+// error response has been written.
+func adminTarget(w http.ResponseWriter, r *http.Request, store storage.Storage) (*models.User, bool) {
+	user, err := store.GetUserByUsername(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("database error")))
+		return nil, false
+	}
+	return user, true
+}
+
+func auditRoleChange(r *http.Request, store storage.Storage, eventType, username, role string, now time.Time) {
+	audit(r.Context(), store, roleChangeEvent(r, eventType, username, role, now))
+}
+
+func roleChangeEvent(r *http.Request, eventType, username, role string, now time.Time) models.AuditEvent {
+	return models.AuditEvent{
+		Type:      eventType,
+		Actor:     auth.PrincipalFromContext(r.Context()).Username,
+		Subject:   username,
+		IP:        clientIP(r),
+		Details:   map[string]string{"role": role},
+		CreatedAt: now,
+	}
+}
+
+func respondWithRoles(w http.ResponseWriter, r *http.Request, store storage.Storage, user *models.User) {
+	roles, err := store.GetUserRoles(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, models.UserRoles{Username: user.Username, Roles: roles})
+}
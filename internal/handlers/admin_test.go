@@ -0,0 +1,228 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// adminRequest builds a request by the admin "root" with the given body
+// and URL parameters.
+func adminRequest(method, target, body string, params map[string]string) *http.Request {
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "root", Roles: []string{models.RoleUser, models.RoleAdmin}})
+	return req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+}
+
+// runUnits makes the units of work of store run on store itself.
+func runUnits(store *mocks.Storage) {
+	store.On("InTx", mock.Anything, (*sql.TxOptions)(nil), mock.Anything).
+		Return(func(_ context.Context, _ *sql.TxOptions, fn func(storage.Storage) error) error {
+			return fn(store)
+		})
+}
+
+func TestGrantRoleHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "grants the role",
+			role: models.RoleAuditor,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("GrantRole", mock.Anything, 7, models.RoleAuditor, mock.Anything).Return(nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditRoleGranted && e.Actor == "root" && e.Subject == "alice" &&
+						e.Details["role"] == models.RoleAuditor
+				})).Return(nil)
+				m.On("GetUserRoles", mock.Anything, 7).Return([]string{models.RoleUser, models.RoleAuditor}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "unknown role",
+			role: "root",
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				var v validation.Validator
+				v.Add("role", validation.CodeInvalidFormat, "must be admin or auditor")
+				m.On("GrantRole", mock.Anything, 7, "root", mock.Anything).Return(v.Err())
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "unknown user",
+			role: models.RoleAdmin,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return((*models.User)(nil), storage.ErrUserNotFound)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "user_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("PUT", "/api/admin/users/alice/roles/"+tt.role, "", map[string]string{"username": "alice", "role": tt.role})
+			rr := httptest.NewRecorder()
+			handlers.GrantRoleHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.UserRoles
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, models.UserRoles{Username: "alice", Roles: []string{models.RoleUser, models.RoleAuditor}}, response)
+		})
+	}
+}
+
+func TestRevokeRoleHandler(t *testing.T) {
+	t.Run("revokes the role and the sessions", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		runUnits(mockStorage)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+		mockStorage.On("RevokeRole", mock.Anything, 7, models.RoleAdmin).Return(nil)
+		mockStorage.On("RevokeUserSessions", mock.Anything, 7, mock.Anything).Return(int64(2), nil)
+		mockStorage.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+			return e.Type == models.AuditRoleRevoked && e.Subject == "alice"
+		})).Return(nil)
+		mockStorage.On("GetUserRoles", mock.Anything, 7).Return([]string{models.RoleUser}, nil)
+
+		req := adminRequest("DELETE", "/api/admin/users/alice/roles/admin", "", map[string]string{"username": "alice", "role": models.RoleAdmin})
+		rr := httptest.NewRecorder()
+		handlers.RevokeRoleHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("role not granted", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		runUnits(mockStorage)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+		mockStorage.On("RevokeRole", mock.Anything, 7, models.RoleAdmin).Return(storage.ErrRoleNotGranted)
+
+		req := adminRequest("DELETE", "/api/admin/users/alice/roles/admin", "", map[string]string{"username": "alice", "role": models.RoleAdmin})
+		rr := httptest.NewRecorder()
+		handlers.RevokeRoleHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		mockStorage.AssertNotCalled(t, "RevokeUserSessions", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("failed audit rolls the revocation back", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		runUnits(mockStorage)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+		mockStorage.On("RevokeRole", mock.Anything, 7, models.RoleAdmin).Return(nil)
+		mockStorage.On("RevokeUserSessions", mock.Anything, 7, mock.Anything).Return(int64(2), nil)
+		mockStorage.On("CreateAuditEvent", mock.Anything, mock.Anything).Return(errors.New("db down"))
+
+		req := adminRequest("DELETE", "/api/admin/users/alice/roles/admin", "", map[string]string{"username": "alice", "role": models.RoleAdmin})
+		rr := httptest.NewRecorder()
+		handlers.RevokeRoleHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		mockStorage.AssertNotCalled(t, "GetUserRoles", mock.Anything, mock.Anything)
+	})
+}
+
+func TestAuditEventsHandler(t *testing.T) {
+	t.Run("filters events", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("ListAuditEvents", mock.Anything, models.AuditFilter{Type: models.AuditAccountLocked, Subject: "alice", Limit: 10}).
+			Return([]models.AuditEvent{{ID: 3, Type: models.AuditAccountLocked, Subject: "alice"}}, nil)
+
+		req := adminRequest("GET", "/api/admin/audit?type=account_locked&subject=alice&limit=10", "", nil)
+		rr := httptest.NewRecorder()
+		handlers.AuditEventsHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var events []models.AuditEvent
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req := adminRequest("GET", "/api/admin/audit?limit=1000", "", nil)
+		rr := httptest.NewRecorder()
+		handlers.AuditEventsHandler(mocks.NewStorage(t)).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestUnlockUserHandler(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+	mockStorage.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, "alice").Return(nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+		return e.Type == models.AuditAccountUnlocked && e.Actor == "root" && e.Subject == "alice"
+	})).Return(nil)
+
+	req := adminRequest("POST", "/api/admin/users/alice/unlock", "", map[string]string{"username": "alice"})
+	rr := httptest.NewRecorder()
+	handlers.UnlockUserHandler(mockStorage, lockout.NewGuard(mockStorage, lockout.DefaultPolicy())).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestIssuePasswordResetHandler(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+	mockStorage.On("CreatePasswordResetToken", mock.Anything, mock.MatchedBy(func(token models.PasswordResetToken) bool {
+		return token.UserID == 7
+	})).Return(nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+		return e.Type == models.AuditPasswordResetIssued && e.Actor == "root" && e.Subject == "alice"
+	})).Return(nil)
+
+	req := adminRequest("POST", "/api/admin/users/alice/password-reset", "", map[string]string{"username": "alice"})
+	rr := httptest.NewRecorder()
+	handlers.IssuePasswordResetHandler(mockStorage).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response models.PasswordResetIssued
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	mockStorage.AssertCalled(t, "CreatePasswordResetToken", mock.Anything, mock.MatchedBy(func(token models.PasswordResetToken) bool {
+		return token.TokenHash == password.HashResetToken(response.Token)
+	}))
+}
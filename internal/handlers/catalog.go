@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// ItemsHandler lists the items on sale. With includeArchived it lists
+// the whole catalog, for admins.
+func ItemsHandler(store storage.Storage, includeArchived bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := store.ListItems(r.Context(), includeArchived)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to list items"))
+			return
+		}
+		if items == nil {
+			items = []models.Item{}
+		}
+		respondWithJSON(w, http.StatusOK, items)
+	}
+}
+
+func CreateItemHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.CreateItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		item, err := store.CreateItem(r.Context(), req)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to create item")))
+			return
+		}
+		auditItemChange(r, store, models.AuditItemCreated, item)
+		respondWithJSON(w, http.StatusCreated, item)
+	}
+}
+
+// UpdateItemHandler renames or reprices an item. Items already bought
+// keep their place in inventories under the new name.
+func UpdateItemHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+
+		var update models.ItemUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := update.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		item, err := store.UpdateItem(r.Context(), id, update)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to update item")))
+			return
+		}
+		auditItemChange(r, store, models.AuditItemUpdated, item)
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
+
+// ArchiveItemHandler takes an item off sale. Owners keep it in their
+// inventories.
+func ArchiveItemHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+
+		item, err := store.ArchiveItem(r.Context(), id, time.Now())
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to archive item")))
+			return
+		}
+		auditItemChange(r, store, models.AuditItemArchived, item)
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
+
+// RestockItemHandler adds to the stock of an item.
+func RestockItemHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.RestockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		item, err := store.RestockItem(r.Context(), id, req.Quantity)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to restock item")))
+			return
+		}
+		auditItemChange(r, store, models.AuditItemRestocked, item)
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
+
+// idParam parses the {id} URL parameter. If it returns false, the error
+// response has been written.
+func idParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		var v validation.Validator
+		v.Add("id", validation.CodeInvalidFormat, "must be a positive number")
+		respondWithError(w, r, apierror.FromError(v.Err(), nil))
+		return 0, false
+	}
+	return id, true
+}
+
+func auditItemChange(r *http.Request, store storage.Storage, eventType string, item *models.Item) {
+	details := map[string]string{
+		"id":    strconv.Itoa(item.ID),
+		"name":  item.Name,
+		"price": strconv.Itoa(item.Price),
+	}
+	if item.Stock != nil {
+		details["stock"] = strconv.Itoa(*item.Stock)
+	}
+	audit(r.Context(), store, models.AuditEvent{
+		Type:      eventType,
+		Actor:     auth.PrincipalFromContext(r.Context()).Username,
+		IP:        clientIP(r),
+		Details:   details,
+		CreatedAt: time.Now(),
+	})
+}
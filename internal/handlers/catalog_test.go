@@ -0,0 +1,232 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestItemsHandler(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("ListItems", mock.Anything, false).Return([]models.Item(nil), nil)
+
+	rr := httptest.NewRecorder()
+	handlers.ItemsHandler(mockStorage, false).ServeHTTP(rr, httptest.NewRequest("GET", "/api/items", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+}
+
+func TestCreateItemHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "creates the item",
+			body: `{"name":"sticker","price":5}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("CreateItem", mock.Anything, models.CreateItemRequest{Name: "sticker", Price: 5}).Return(&models.Item{ID: 11, Name: "sticker", Price: 5}, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditItemCreated && e.Actor == "root" && e.Details["name"] == "sticker"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "name taken",
+			body: `{"name":"cup","price":5}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("CreateItem", mock.Anything, models.CreateItemRequest{Name: "cup", Price: 5}).Return((*models.Item)(nil), storage.ErrItemExists)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "item_exists",
+		},
+		{
+			name:           "invalid name and price",
+			body:           `{"name":"Big Cup","price":-1}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("POST", "/api/admin/items", tt.body, nil)
+			rr := httptest.NewRecorder()
+			handlers.CreateItemHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateItemHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "reprices the item",
+			id:   "2",
+			body: `{"price":25}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("UpdateItem", mock.Anything, 2, mock.MatchedBy(func(u models.ItemUpdate) bool {
+					return u.Name == nil && u.Price != nil && *u.Price == 25
+				})).Return(&models.Item{ID: 2, Name: "cup", Price: 25}, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditItemUpdated && e.Details["price"] == "25"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "unknown item",
+			id:   "99",
+			body: `{"name":"mug"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("UpdateItem", mock.Anything, 99, mock.Anything).Return((*models.Item)(nil), storage.ErrItemNotFound)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "item_not_found",
+		},
+		{
+			name:           "nothing to update",
+			id:             "2",
+			body:           `{}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name:           "invalid id",
+			id:             "cup",
+			body:           `{"price":25}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("PATCH", "/api/admin/items/"+tt.id, tt.body, map[string]string{"id": tt.id})
+			rr := httptest.NewRecorder()
+			handlers.UpdateItemHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
+
+func TestArchiveItemHandler(t *testing.T) {
+	archivedAt := time.Now()
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("ArchiveItem", mock.Anything, 2, mock.Anything).
+		Return(&models.Item{ID: 2, Name: "cup", Price: 20, ArchivedAt: &archivedAt}, nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+		return e.Type == models.AuditItemArchived && e.Details["name"] == "cup"
+	})).Return(nil)
+
+	req := adminRequest("POST", "/api/admin/items/2/archive", "", map[string]string{"id": "2"})
+	rr := httptest.NewRecorder()
+	handlers.ArchiveItemHandler(mockStorage).ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var item models.Item
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &item))
+	assert.NotNil(t, item.ArchivedAt)
+}
+
+func TestRestockItemHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "restocks the item",
+			body: `{"quantity":10}`,
+			mockSetup: func(m *mocks.Storage) {
+				stock := 12
+				m.On("RestockItem", mock.Anything, 6, 10).Return(&models.Item{ID: 6, Name: "hoody", Price: 300, Stock: &stock}, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditItemRestocked && e.Details["stock"] == "12"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "zero quantity",
+			body:           `{"quantity":0}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "unknown item",
+			body: `{"quantity":1}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RestockItem", mock.Anything, 6, 1).Return((*models.Item)(nil), storage.ErrItemNotFound)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "item_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("POST", "/api/admin/items/6/restock", tt.body, map[string]string{"id": "6"})
+			rr := httptest.NewRecorder()
+			handlers.RestockItemHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
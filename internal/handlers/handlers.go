@@ -2,76 +2,197 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/registration"
 	"github.com/mi4r/avito-shop/internal/storage/models"
 	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func AuthHandler(store storage.Storage, secretKey []byte) http.HandlerFunc {
+// AuthHandler logs in an existing user. It never creates accounts, see RegisterHandler.
+// Failed attempts are counted by guard, which answers 429 once they pile up.
+// Password hashes in an outdated scheme are upgraded on successful login.
+// Users with 2FA get a challenge token instead, see TwoFactorLoginHandler.
+func AuthHandler(store storage.Storage, tokens *auth.TokenManager, guard *lockout.Guard, hasher *password.Hasher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.AuthRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "invalid request")
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		ip := clientIP(r)
+		if err := guard.Check(r.Context(), req.Username, ip); err != nil {
+			respondThrottled(w, r, err)
 			return
 		}
 
 		user, err := store.GetUserByUsername(r.Context(), req.Username)
 		if errors.Is(err, sql.ErrNoRows) {
-			hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-			user, err = store.CreateUser(r.Context(), req.Username, string(hashedPassword))
-			if err != nil {
-				respondWithError(w, http.StatusInternalServerError, "failed to create user")
-				return
-			}
+			// Take as long as for a wrong password.
+			hasher.VerifyDummy(req.Password)
+			err = password.ErrMismatch
 		} else if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "database error")
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
 			return
-		} else {
-			if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-				respondWithError(w, http.StatusUnauthorized, "invalid password")
+		}
+		var rehash bool
+		if err == nil {
+			rehash, err = hasher.Verify(user.PasswordHash, req.Password)
+		}
+		if errors.Is(err, password.ErrMismatch) {
+			if err := guard.Failure(r.Context(), req.Username, ip); err != nil {
+				respondWithError(w, r, apierror.Internal.WithMessage("database error"))
 				return
 			}
+			respondWithError(w, r, apierror.InvalidCredentials)
+			return
+		}
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to verify password"))
+			return
+		}
+		if rehash {
+			if hash, err := hasher.Hash(req.Password); err != nil {
+				log.Printf("failed to rehash password: %v", err)
+			} else if err := store.UpdatePasswordHash(r.Context(), user.ID, hash); err != nil {
+				log.Printf("failed to store rehashed password: %v", err)
+			}
+		}
+
+		// With 2FA on, failures are only cleared once the second factor is
+		// verified; otherwise a known password would allow unlimited guesses
+		// at the code.
+		enabled, err := twoFactorEnabled(r.Context(), store, user.ID)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+		if enabled {
+			respondChallenge(w, r, tokens, user)
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"username": user.Username,
-		})
-		tokenString, _ := token.SignedString(secretKey)
+		if err := guard.Success(r.Context(), req.Username); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+
+		resp, err := startSession(r.Context(), store, tokens, user)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// respondThrottled answers a failed guard check, with Retry-After if
+// logins are blocked.
+func respondThrottled(w http.ResponseWriter, r *http.Request, err error) {
+	var throttled *lockout.ThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	}
+	respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("database error")))
+}
+
+// RegisterHandler creates an account if the registration policy allows it
+// and logs the new user in.
+func RegisterHandler(store storage.Storage, tokens *auth.TokenManager, policy *registration.Policy, hasher *password.Hasher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.AuthRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		if err := req.ValidateRegistration(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+		if err := policy.Check(req); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		hashedPassword, err := hasher.Hash(req.Password)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to create user"))
+			return
+		}
+
+		newUser := models.NewUser{
+			Username:     req.Username,
+			Email:        req.Email,
+			PasswordHash: hashedPassword,
+		}
+		if policy.Mode() == registration.ModeInvite {
+			newUser.InviteCode = req.InviteCode
+		}
+
+		user, err := store.RegisterUser(r.Context(), newUser, time.Now())
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to create user")))
+			return
+		}
+
+		resp, err := startSession(r.Context(), store, tokens, user)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
 
-		respondWithJSON(w, http.StatusOK, models.AuthResponse{Token: tokenString})
+		respondWithJSON(w, http.StatusCreated, resp)
 	}
 }
 
 func InfoHandler(store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		username := r.Context().Value("username").(string)
+		username := auth.PrincipalFromContext(r.Context()).Username
 
 		user, err := store.GetUserByUsername(r.Context(), username)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "user not found")
+			respondWithError(w, r, apierror.Internal.WithMessage("user not found"))
 			return
 		}
 
 		inventory, err := store.GetUserInventory(r.Context(), user.ID)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to get inventory")
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to get inventory"))
 			return
 		}
 
 		received, sent, err := store.GetCoinHistory(r.Context(), user.ID)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to get history")
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to get history"))
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, models.InfoResponse{
+		resp := models.InfoResponse{
 			Coins:     user.Coins,
 			Inventory: inventory,
 			CoinHistory: struct {
@@ -81,28 +202,143 @@ func InfoHandler(store storage.Storage) http.HandlerFunc {
 				Received: received,
 				Sent:     sent,
 			},
-		})
+		}
+		// The latest purchases are only listed on request, e.g. ?include=purchases.
+		if slices.Contains(strings.Split(r.URL.Query().Get("include"), ","), "purchases") {
+			resp.Purchases, err = store.ListPurchases(r.Context(), user.ID, models.PurchaseFilter{Limit: defaultPurchasesLimit})
+			if err != nil {
+				respondWithError(w, r, apierror.Internal.WithMessage("failed to get purchases"))
+				return
+			}
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+const (
+	defaultTransactionsLimit = 20
+	maxTransactionsLimit     = 100
+)
+
+func TransactionsHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseTransactionFilter(r)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		username := auth.PrincipalFromContext(r.Context()).Username
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("user not found"))
+			return
+		}
+
+		// Fetch one extra row to find out whether there is a next page.
+		limit := filter.Limit
+		filter.Limit++
+		transactions, err := store.ListCoinTransactions(r.Context(), user.ID, filter)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to get transactions"))
+			return
+		}
+
+		resp := models.TransactionsResponse{Transactions: transactions}
+		if len(transactions) > limit {
+			resp.Transactions = transactions[:limit]
+			last := resp.Transactions[limit-1]
+			resp.NextCursor = encodeCursor(models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		}
+		if resp.Transactions == nil {
+			resp.Transactions = []models.CoinTransaction{}
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
 	}
 }
 
+func parseTransactionFilter(r *http.Request) (models.TransactionFilter, error) {
+	q := r.URL.Query()
+	filter := models.TransactionFilter{
+		Direction:    q.Get("direction"),
+		Counterparty: q.Get("counterparty"),
+		Limit:        defaultTransactionsLimit,
+	}
+
+	var v validation.Validator
+	switch filter.Direction {
+	case "", models.DirectionSent, models.DirectionReceived:
+	default:
+		v.Add("direction", validation.CodeInvalidFormat, "must be sent or received")
+	}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxTransactionsLimit {
+			v.Add("limit", validation.CodeInvalidFormat,
+				fmt.Sprintf("must be a number from 1 to %d", maxTransactionsLimit))
+		}
+		filter.Limit = limit
+	}
+
+	var err error
+	if s := q.Get("from"); s != "" {
+		if filter.From, err = time.Parse(time.RFC3339, s); err != nil {
+			v.Add("from", validation.CodeInvalidFormat, "must be an RFC 3339 timestamp")
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if filter.To, err = time.Parse(time.RFC3339, s); err != nil {
+			v.Add("to", validation.CodeInvalidFormat, "must be an RFC 3339 timestamp")
+		}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			v.Add("cursor", validation.CodeInvalidFormat, "is not a valid cursor")
+		}
+		filter.After = &cursor
+	}
+	return filter, v.Err()
+}
+
+// Cursors are opaque to clients: base64 of "<unix nanos>:<id>".
+func encodeCursor(c models.TransactionCursor) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)))
+}
+
+func decodeCursor(s string) (models.TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return models.TransactionCursor{}, err
+	}
+	var nanos int64
+	var id int
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &nanos, &id); err != nil {
+		return models.TransactionCursor{}, err
+	}
+	return models.TransactionCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
 func SendCoinHandler(store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.SendCoinRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "invalid request")
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
 			return
 		}
 
-		sender := r.Context().Value("username").(string)
+		sender := auth.PrincipalFromContext(r.Context()).Username
 		if err := store.SendCoins(r.Context(), sender, req.ToUser, req.Amount); err != nil {
-			switch err {
-			case storage.ErrInsufficientCoins:
-				respondWithError(w, http.StatusBadRequest, "insufficient coins")
-			case storage.ErrUserNotFound:
-				respondWithError(w, http.StatusBadRequest, "user not found")
-			default:
-				respondWithError(w, http.StatusInternalServerError, "transaction failed")
-			}
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("transaction failed")))
 			return
 		}
 
@@ -110,20 +346,18 @@ func SendCoinHandler(store storage.Storage) http.HandlerFunc {
 	}
 }
 
+// BuyItemHandler serves the deprecated GET /api/buy/{item}, which buys a
+// single unit. New clients check out with POST /api/orders.
 func BuyItemHandler(store storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", `</api/orders>; rel="successor-version"`)
+
 		itemName := chi.URLParam(r, "item")
-		username := r.Context().Value("username").(string)
+		username := auth.PrincipalFromContext(r.Context()).Username
 
 		if err := store.BuyItem(r.Context(), username, itemName); err != nil {
-			switch err {
-			case storage.ErrItemNotFound:
-				respondWithError(w, http.StatusBadRequest, "item not found")
-			case storage.ErrInsufficientCoins:
-				respondWithError(w, http.StatusBadRequest, "insufficient coins")
-			default:
-				respondWithError(w, http.StatusInternalServerError, "purchase failed")
-			}
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("purchase failed")))
 			return
 		}
 
@@ -137,6 +371,16 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	json.NewEncoder(w).Encode(payload)
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+// clientIP returns the host part of r.RemoteAddr. Behind a trusted proxy
+// chimw.RealIP puts the forwarded address there.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, e *apierror.Error) {
+	apierror.Write(w, r, e)
 }
@@ -6,42 +6,81 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
 	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/registration"
 	"github.com/mi4r/avito-shop/internal/storage/mocks"
 	"github.com/mi4r/avito-shop/internal/storage/models"
 	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
 )
 
+var testTokens = auth.NewTokenManager(auth.TokenConfig{
+	Key:        []byte("handlers-test-secret-0123456789ab"),
+	TTL:        time.Hour,
+	Issuer:     "avito-shop",
+	Audience:   "avito-shop",
+	RefreshTTL: 24 * time.Hour,
+})
+
+// testHasher hashes with bcrypt at the default cost, like the hashes
+// created in the tests.
+var testHasher = password.NewHasher(password.Bcrypt{})
+
+func verifies(hash, pw string) bool {
+	_, err := testHasher.Verify(hash, pw)
+	return err == nil
+}
+
+// allowLogins sets up the login throttle as if there were no earlier
+// failures, and 2FA as not enabled. Expectations set before it take
+// precedence.
+func allowLogins(m *mocks.Storage) {
+	m.On("GetLoginThrottle", mock.Anything, mock.Anything, mock.Anything).
+		Return(&models.LoginThrottle{}, nil).Maybe()
+	m.On("RecordLoginFailure", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(1, nil).Maybe()
+	m.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, mock.Anything).
+		Return(nil).Maybe()
+	m.On("GetTOTP", mock.Anything, mock.Anything).
+		Return((*models.TOTP)(nil), storage.ErrTOTPNotFound).Maybe()
+}
+
 func TestAuthHandler(t *testing.T) {
 	tests := []struct {
-		name           string
-		request        models.AuthRequest
-		mockSetup      func(*mocks.Storage)
-		expectedStatus int
-		expectedError  string
+		name               string
+		request            models.AuthRequest
+		mockSetup          func(*mocks.Storage)
+		expectedStatus     int
+		expectedCode       string
+		expectedRetryAfter string
 	}{
 		{
-			name: "successful registration",
+			name: "unknown user is not created",
 			request: models.AuthRequest{
 				Username: "newuser",
 				Password: "password",
 			},
 			mockSetup: func(m *mocks.Storage) {
 				m.On("GetUserByUsername", mock.Anything, "newuser").
-					Return((*models.User)(nil), sql.ErrNoRows)
-				m.On("CreateUser", mock.Anything, "newuser", mock.Anything).
-					Return(&models.User{Username: "newuser"}, nil)
+					Return((*models.User)(nil), fmt.Errorf("%w: %w", storage.ErrUserNotFound, sql.ErrNoRows))
 			},
-			expectedStatus: http.StatusOK,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_credentials",
 		},
 		{
 			name: "successful login",
@@ -56,6 +95,49 @@ func TestAuthHandler(t *testing.T) {
 						Username:     "existinguser",
 						PasswordHash: string(hash),
 					}, nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "account predating the format rules logs in",
+			request: models.AuthRequest{
+				Username: "legacy user",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *mocks.Storage) {
+				hash, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+				m.On("GetUserByUsername", mock.Anything, "legacy user").
+					Return(&models.User{
+						Username:     "legacy user",
+						PasswordHash: string(hash),
+					}, nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "outdated hash is upgraded",
+			request: models.AuthRequest{
+				Username: "existinguser",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *mocks.Storage) {
+				hash, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+				m.On("GetUserByUsername", mock.Anything, "existinguser").
+					Return(&models.User{
+						ID:           7,
+						Username:     "existinguser",
+						PasswordHash: string(hash),
+					}, nil)
+				m.On("UpdatePasswordHash", mock.Anything, 7, mock.MatchedBy(func(hash string) bool {
+					cost, err := bcrypt.Cost([]byte(hash))
+					return err == nil && cost == bcrypt.DefaultCost && verifies(hash, "correctpassword")
+				})).Return(nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
 			},
 			expectedStatus: http.StatusOK,
 		},
@@ -74,22 +156,60 @@ func TestAuthHandler(t *testing.T) {
 					}, nil)
 			},
 			expectedStatus: http.StatusUnauthorized,
-			expectedError:  "invalid password",
+			expectedCode:   "invalid_credentials",
 		},
 		{
-			name: "create user error",
+			name: "database error",
 			request: models.AuthRequest{
 				Username: "newuser",
 				Password: "password",
 			},
 			mockSetup: func(m *mocks.Storage) {
 				m.On("GetUserByUsername", mock.Anything, "newuser").
-					Return((*models.User)(nil), sql.ErrNoRows)
-				m.On("CreateUser", mock.Anything, "newuser", mock.Anything).
 					Return((*models.User)(nil), errors.New("db error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
-			expectedError:  "failed to create user",
+			expectedCode:   "internal_error",
+		},
+		{
+			name: "empty credentials",
+			request: models.AuthRequest{
+				Username: "",
+				Password: "",
+			},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "backoff after failures",
+			request: models.AuthRequest{
+				Username: "existinguser",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *mocks.Storage) {
+				until := time.Now().Add(30 * time.Second)
+				m.On("GetLoginThrottle", mock.Anything, models.ThrottleScopeUsername, "existinguser").
+					Return(&models.LoginThrottle{Failures: 5, BlockedUntil: &until}, nil)
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCode:       "too_many_attempts",
+			expectedRetryAfter: "30",
+		},
+		{
+			name: "locked account",
+			request: models.AuthRequest{
+				Username: "existinguser",
+				Password: "correctpassword",
+			},
+			mockSetup: func(m *mocks.Storage) {
+				until := time.Now().Add(10 * time.Minute)
+				m.On("GetLoginThrottle", mock.Anything, models.ThrottleScopeUsername, "existinguser").
+					Return(&models.LoginThrottle{Failures: 10, BlockedUntil: &until, Locked: true}, nil)
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCode:       "account_locked",
+			expectedRetryAfter: "600",
 		},
 	}
 
@@ -97,20 +217,33 @@ func TestAuthHandler(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := mocks.NewStorage(t)
 			tt.mockSetup(mockStorage)
+			allowLogins(mockStorage)
 
 			body, _ := json.Marshal(tt.request)
 			req := httptest.NewRequest("POST", "/auth", bytes.NewReader(body))
 			rr := httptest.NewRecorder()
 
-			handler := handlers.AuthHandler(mockStorage, []byte("secret"))
+			handler := handlers.AuthHandler(mockStorage, testTokens, lockout.NewGuard(mockStorage, lockout.DefaultPolicy()), testHasher)
 			handler.ServeHTTP(rr, req)
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedRetryAfter, rr.Header().Get("Retry-After"))
 
-			if tt.expectedError != "" {
-				var response map[string]string
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				assert.NotEmpty(t, response.Message)
+			} else {
+				var response models.AuthResponse
 				json.Unmarshal(rr.Body.Bytes(), &response)
-				assert.Contains(t, response["error"], tt.expectedError)
+				claims, err := testTokens.Parse(response.Token)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.request.Username, claims.Username)
+				assert.NotEmpty(t, claims.ID)
+				assert.NotEmpty(t, claims.SessionID)
+				assert.NotNil(t, claims.ExpiresAt)
+				assert.NotEmpty(t, response.RefreshToken)
 			}
 
 			mockStorage.AssertExpectations(t)
@@ -134,7 +267,7 @@ func TestInfoHandler(t *testing.T) {
 			Return([]models.ReceivedTransaction{}, []models.SentTransaction{}, nil)
 
 		req := httptest.NewRequest("GET", "/info", nil)
-		ctx := context.WithValue(req.Context(), "username", "testuser")
+		ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "testuser"})
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
@@ -146,6 +279,29 @@ func TestInfoHandler(t *testing.T) {
 		var response models.InfoResponse
 		json.Unmarshal(rr.Body.Bytes(), &response)
 		assert.Equal(t, expectedUser.Coins, response.Coins)
+		assert.NotContains(t, rr.Body.String(), "purchases")
+	})
+
+	t.Run("with purchases", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "testuser").
+			Return(&models.User{ID: 1, Coins: 950}, nil)
+		mockStorage.On("GetUserInventory", mock.Anything, 1).
+			Return([]models.InventoryItem{{Type: "book", Quantity: 1}}, nil)
+		mockStorage.On("GetCoinHistory", mock.Anything, 1).
+			Return([]models.ReceivedTransaction{}, []models.SentTransaction{}, nil)
+		mockStorage.On("ListPurchases", mock.Anything, 1, models.PurchaseFilter{Limit: 20}).
+			Return([]models.Purchase{{ID: 3, OrderID: 2, Item: "book", Quantity: 1, Price: 50, Amount: 50}}, nil)
+
+		req := httptest.NewRequest("GET", "/info?include=purchases", nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "testuser"}))
+		rr := httptest.NewRecorder()
+		handlers.InfoHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response models.InfoResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		assert.Equal(t, []models.Purchase{{ID: 3, OrderID: 2, Item: "book", Quantity: 1, Price: 50, Amount: 50}}, response.Purchases)
 	})
 
 	t.Run("user not found", func(t *testing.T) {
@@ -154,7 +310,7 @@ func TestInfoHandler(t *testing.T) {
 			Return((*models.User)(nil), errors.New("not found"))
 
 		req := httptest.NewRequest("GET", "/info", nil)
-		ctx := context.WithValue(req.Context(), "username", "testuser")
+		ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "testuser"})
 		req = req.WithContext(ctx)
 
 		rr := httptest.NewRecorder()
@@ -171,7 +327,7 @@ func TestSendCoinHandler(t *testing.T) {
 		request        models.SendCoinRequest
 		mockSetup      func(*mocks.Storage)
 		expectedStatus int
-		expectedError  string
+		expectedCode   string
 	}{
 		{
 			name: "successful transfer",
@@ -196,7 +352,7 @@ func TestSendCoinHandler(t *testing.T) {
 					Return(storage.ErrInsufficientCoins)
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "insufficient coins",
+			expectedCode:   "insufficient_coins",
 		},
 		{
 			name: "user not found",
@@ -209,7 +365,30 @@ func TestSendCoinHandler(t *testing.T) {
 					Return(storage.ErrUserNotFound)
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "user not found",
+			expectedCode:   "user_not_found",
+		},
+		{
+			name: "negative amount",
+			request: models.SendCoinRequest{
+				ToUser: "receiver",
+				Amount: -100,
+			},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "self transfer rejected by storage",
+			request: models.SendCoinRequest{
+				ToUser: "sender",
+				Amount: 100,
+			},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("SendCoins", mock.Anything, "sender", "sender", 100).
+					Return(validation.Transfer("sender", "sender", 100))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
 		},
 	}
 
@@ -220,7 +399,7 @@ func TestSendCoinHandler(t *testing.T) {
 
 			body, _ := json.Marshal(tt.request)
 			req := httptest.NewRequest("POST", "/send", bytes.NewReader(body))
-			ctx := context.WithValue(req.Context(), "username", "sender")
+			ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "sender"})
 			req = req.WithContext(ctx)
 
 			rr := httptest.NewRecorder()
@@ -229,10 +408,11 @@ func TestSendCoinHandler(t *testing.T) {
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
 
-			if tt.expectedError != "" {
-				var response map[string]string
+			if tt.expectedCode != "" {
+				var response apierror.Error
 				json.Unmarshal(rr.Body.Bytes(), &response)
-				assert.Contains(t, response["error"], tt.expectedError)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				assert.NotEmpty(t, response.Message)
 			}
 
 			mockStorage.AssertExpectations(t)
@@ -246,7 +426,7 @@ func TestBuyItemHandler(t *testing.T) {
 		itemName       string
 		mockSetup      func(*mocks.Storage)
 		expectedStatus int
-		expectedError  string
+		expectedCode   string
 	}{
 		{
 			name:     "successful purchase",
@@ -265,7 +445,7 @@ func TestBuyItemHandler(t *testing.T) {
 					Return(storage.ErrItemNotFound)
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "item not found",
+			expectedCode:   "item_not_found",
 		},
 		{
 			name:     "insufficient coins",
@@ -275,7 +455,7 @@ func TestBuyItemHandler(t *testing.T) {
 					Return(storage.ErrInsufficientCoins)
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "insufficient coins",
+			expectedCode:   "insufficient_coins",
 		},
 	}
 
@@ -288,7 +468,7 @@ func TestBuyItemHandler(t *testing.T) {
 			rctx.URLParams.Add("item", tt.itemName)
 
 			req := httptest.NewRequest("GET", "/buy/"+tt.itemName, nil)
-			ctx := context.WithValue(req.Context(), "username", "buyer")
+			ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"})
 			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
 			req = req.WithContext(ctx)
 
@@ -297,14 +477,204 @@ func TestBuyItemHandler(t *testing.T) {
 			handler.ServeHTTP(rr, req)
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, "true", rr.Header().Get("Deprecation"))
 
-			if tt.expectedError != "" {
-				var response map[string]string
+			if tt.expectedCode != "" {
+				var response apierror.Error
 				json.Unmarshal(rr.Body.Bytes(), &response)
-				assert.Contains(t, response["error"], tt.expectedError)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				assert.NotEmpty(t, response.Message)
 			}
 
 			mockStorage.AssertExpectations(t)
 		})
 	}
 }
+
+func TestTransactionsHandler(t *testing.T) {
+	createdAt := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	page := []models.CoinTransaction{
+		{ID: 3, Direction: models.DirectionSent, Counterparty: "bob", Amount: 30, CreatedAt: createdAt.Add(2 * time.Minute)},
+		{ID: 2, Direction: models.DirectionReceived, Counterparty: "bob", Amount: 20, CreatedAt: createdAt.Add(time.Minute)},
+		{ID: 1, Direction: models.DirectionSent, Counterparty: "bob", Amount: 10, CreatedAt: createdAt},
+	}
+
+	serve := func(mockStorage *mocks.Storage, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/transactions"+query, nil)
+		ctx := auth.WithClaims(req.Context(), &auth.Claims{Username: "alice"})
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handlers.TransactionsHandler(mockStorage).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("first page with next cursor", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").
+			Return(&models.User{ID: 1, Username: "alice"}, nil)
+		mockStorage.On("ListCoinTransactions", mock.Anything, 1, models.TransactionFilter{
+			Direction:    models.DirectionSent,
+			Counterparty: "bob",
+			From:         createdAt,
+			Limit:        3,
+		}).Return(page, nil)
+
+		rr := serve(mockStorage, "?limit=2&direction=sent&counterparty=bob&from=2025-02-01T12:00:00Z")
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var response models.TransactionsResponse
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		assert.Equal(t, page[:2], response.Transactions)
+		assert.NotEmpty(t, response.NextCursor)
+	})
+
+	t.Run("next page follows the cursor", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").
+			Return(&models.User{ID: 1, Username: "alice"}, nil).Twice()
+		mockStorage.On("ListCoinTransactions", mock.Anything, 1, mock.MatchedBy(func(f models.TransactionFilter) bool {
+			return f.After == nil
+		})).Return(page, nil).Once()
+		mockStorage.On("ListCoinTransactions", mock.Anything, 1, mock.MatchedBy(func(f models.TransactionFilter) bool {
+			return f.After != nil && f.After.ID == 2 && f.After.CreatedAt.Equal(page[1].CreatedAt)
+		})).Return(page[2:], nil).Once()
+
+		rr := serve(mockStorage, "?limit=2")
+		var first models.TransactionsResponse
+		json.Unmarshal(rr.Body.Bytes(), &first)
+
+		rr = serve(mockStorage, "?limit=2&cursor="+first.NextCursor)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var second models.TransactionsResponse
+		json.Unmarshal(rr.Body.Bytes(), &second)
+		assert.Equal(t, page[2:], second.Transactions)
+		assert.Empty(t, second.NextCursor)
+	})
+
+	for _, query := range []string{"?limit=0", "?limit=1000", "?direction=up", "?from=yesterday", "?cursor=bm9wZQ"} {
+		t.Run("invalid query "+query, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			rr := serve(mockStorage, query)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestRegisterHandler(t *testing.T) {
+	open, _ := registration.NewPolicy(registration.ModeOpen, nil)
+	invite, _ := registration.NewPolicy(registration.ModeInvite, nil)
+	allowlist, _ := registration.NewPolicy(registration.ModeAllowlist, []string{"@example.com"})
+
+	tests := []struct {
+		name           string
+		policy         *registration.Policy
+		request        models.AuthRequest
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:    "open registration",
+			policy:  open,
+			request: models.AuthRequest{Username: "newuser", Password: "password", InviteCode: "ignored"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RegisterUser", mock.Anything, mock.MatchedBy(func(u models.NewUser) bool {
+					return u.Username == "newuser" && u.InviteCode == "" &&
+						verifies(u.PasswordHash, "password")
+				}), mock.Anything).Return(&models.User{ID: 1, Username: "newuser"}, nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:    "username taken",
+			policy:  open,
+			request: models.AuthRequest{Username: "existinguser", Password: "password"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RegisterUser", mock.Anything, mock.Anything, mock.Anything).
+					Return((*models.User)(nil), storage.ErrUserExists)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "user_exists",
+		},
+		{
+			name:    "invite code is passed to storage",
+			policy:  invite,
+			request: models.AuthRequest{Username: "newuser", Password: "password", InviteCode: "code"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RegisterUser", mock.Anything, mock.MatchedBy(func(u models.NewUser) bool {
+					return u.InviteCode == "code"
+				}), mock.Anything).Return((*models.User)(nil), storage.ErrInviteCodeInvalid)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "invite_code_invalid",
+		},
+		{
+			name:           "invite code required",
+			policy:         invite,
+			request:        models.AuthRequest{Username: "newuser", Password: "password"},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name:           "not on the allowlist",
+			policy:         allowlist,
+			request:        models.AuthRequest{Username: "newuser", Password: "password", Email: "newuser@example.org"},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "registration_not_allowed",
+		},
+		{
+			name:    "allowlisted domain",
+			policy:  allowlist,
+			request: models.AuthRequest{Username: "newuser", Password: "password", Email: " newuser@example.com "},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RegisterUser", mock.Anything, mock.MatchedBy(func(u models.NewUser) bool {
+					return u.Email == "newuser@example.com"
+				}), mock.Anything).Return(&models.User{ID: 1, Username: "newuser"}, nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "invalid email",
+			policy:         open,
+			request:        models.AuthRequest{Username: "newuser", Password: "password", Email: "nope"},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			body, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest("POST", "/api/register", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			handlers.RegisterHandler(mockStorage, testTokens, tt.policy, testHasher).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.AuthResponse
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			claims, err := testTokens.Parse(response.Token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.request.Username, claims.Username)
+			assert.NotEmpty(t, response.RefreshToken)
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/mi4r/avito-shop/internal/auth"
+)
+
+// JWKSHandler publishes the public keys that verify access tokens, so that
+// other services can check tokens without sharing a secret.
+func JWKSHandler(tokens *auth.TokenManager) http.HandlerFunc {
+	set := tokens.JWKS()
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondWithJSON(w, http.StatusOK, set)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/handlers"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := auth.NewSigningKey(private)
+	require.NoError(t, err)
+
+	tokens := auth.NewTokenManager(auth.TokenConfig{SigningKey: &key, TTL: time.Hour})
+
+	rr := httptest.NewRecorder()
+	handlers.JWKSHandler(tokens).ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rr.Header().Get("Cache-Control"))
+
+	var set auth.JWKS
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, key.ID, set.Keys[0].Kid)
+	assert.Equal(t, "EdDSA", set.Keys[0].Alg)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/reconcile"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+const (
+	defaultLedgerLimit = 50
+	maxLedgerLimit     = 500
+)
+
+// LedgerHandler lists ledger transactions, newest first, optionally only
+// those touching the account in the query, e.g. "user:42" or "system:shop".
+func LedgerHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := models.LedgerFilter{Account: q.Get("account"), Limit: defaultLedgerLimit}
+		if s := q.Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit < 1 || limit > maxLedgerLimit {
+				var v validation.Validator
+				v.Add("limit", validation.CodeInvalidFormat,
+					fmt.Sprintf("must be a number from 1 to %d", maxLedgerLimit))
+				respondWithError(w, r, apierror.FromError(v.Err(), nil))
+				return
+			}
+			filter.Limit = limit
+		}
+
+		txns, err := store.ListLedgerTransactions(r.Context(), filter)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to list ledger"))
+			return
+		}
+		if txns == nil {
+			txns = []models.LedgerTransaction{}
+		}
+		respondWithJSON(w, http.StatusOK, txns)
+	}
+}
+
+// AdjustCoinsHandler corrects the balance of the user in the URL with an
+// adjustment entry against the system adjustments account.
+func AdjustCoinsHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.AdjustmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+		user, ok := adminTarget(w, r, store)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		coins, err := store.AdjustCoins(r.Context(), user.ID, req.Amount, req.Reason, now)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to adjust coins")))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:    models.AuditCoinsAdjusted,
+			Actor:   auth.PrincipalFromContext(r.Context()).Username,
+			Subject: user.Username,
+			IP:      clientIP(r),
+			Details: map[string]string{
+				"amount": strconv.Itoa(req.Amount),
+				"reason": req.Reason,
+			},
+			CreatedAt: now,
+		})
+		respondWithJSON(w, http.StatusOK, models.Balance{Username: user.Username, Coins: coins})
+	}
+}
+
+// ReconciliationHandler returns the report of the latest balance
+// reconciliation, or 204 No Content if none has run yet.
+func ReconciliationHandler(reconciler *reconcile.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := reconciler.Last()
+		if report == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/reconcile"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestLedgerHandler(t *testing.T) {
+	t.Run("filters by account", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("ListLedgerTransactions", mock.Anything, models.LedgerFilter{Account: "user:7", Limit: 10}).
+			Return([]models.LedgerTransaction{{ID: 3, Kind: models.LedgerGrant, Lines: []models.LedgerLine{
+				{Account: models.AccountIssuance, Debit: 1000},
+				{Account: "user:7", Credit: 1000},
+			}}}, nil)
+
+		req := adminRequest("GET", "/api/admin/ledger?account=user:7&limit=10", "", nil)
+		rr := httptest.NewRecorder()
+		handlers.LedgerHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var txns []models.LedgerTransaction
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &txns))
+		require.Len(t, txns, 1)
+		assert.Len(t, txns[0].Lines, 2)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req := adminRequest("GET", "/api/admin/ledger?limit=0", "", nil)
+		rr := httptest.NewRecorder()
+		handlers.LedgerHandler(mocks.NewStorage(t)).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestAdjustCoinsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+		expectedCoins  int
+	}{
+		{
+			name: "adjusts the balance",
+			body: `{"amount":-50,"reason":"duplicate grant"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("AdjustCoins", mock.Anything, 7, -50, "duplicate grant", mock.Anything).Return(950, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditCoinsAdjusted && e.Actor == "root" && e.Subject == "alice" &&
+						e.Details["amount"] == "-50" && e.Details["reason"] == "duplicate grant"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCoins:  950,
+		},
+		{
+			name:           "missing reason",
+			body:           `{"amount":10}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "balance would go negative",
+			body: `{"amount":-5000,"reason":"fraud"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("AdjustCoins", mock.Anything, 7, -5000, "fraud", mock.Anything).Return(0, storage.ErrInsufficientCoins)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "insufficient_coins",
+		},
+		{
+			name: "unknown user",
+			body: `{"amount":10,"reason":"bonus"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return((*models.User)(nil), storage.ErrUserNotFound)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "user_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("POST", "/api/admin/users/alice/adjustments", tt.body, map[string]string{"username": "alice"})
+			rr := httptest.NewRecorder()
+			handlers.AdjustCoinsHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+			var balance models.Balance
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &balance))
+			assert.Equal(t, models.Balance{Username: "alice", Coins: tt.expectedCoins}, balance)
+		})
+	}
+}
+
+func TestReconciliationHandler(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	reconciler := reconcile.New(mockStorage)
+	handler := handlers.ReconciliationHandler(reconciler)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/api/admin/reconciliation", "", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code, "no run yet")
+
+	mockStorage.On("ListBalanceMismatches", mock.Anything).
+		Return([]models.BalanceMismatch{{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000}}, nil)
+	_, err := reconciler.Run(context.Background(), false, "test")
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/api/admin/reconciliation", "", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report models.ReconciliationReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, []models.BalanceMismatch{{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000}}, report.Mismatches)
+}
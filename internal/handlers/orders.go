@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+const (
+	defaultOrdersLimit = 20
+	maxOrdersLimit     = 100
+
+	defaultPurchasesLimit = 20
+	maxPurchasesLimit     = 100
+)
+
+// CreateOrderHandler checks out a cart, charging its total in a single
+// transaction, and answers with the receipt.
+func CreateOrderHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.CreateOrderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		username := auth.PrincipalFromContext(r.Context()).Username
+		order, err := store.CreateOrder(r.Context(), username, req.Items, time.Now())
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("order failed")))
+			return
+		}
+		respondWithJSON(w, http.StatusCreated, order)
+	}
+}
+
+// OrdersHandler lists the orders of the current user, newest first.
+func OrdersHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listOrders(w, r, store, models.OrderFilter{
+			Username: auth.PrincipalFromContext(r.Context()).Username,
+		})
+	}
+}
+
+// AdminOrdersHandler lists all orders, optionally of one user or in one
+// status.
+func AdminOrdersHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := models.OrderFilter{
+			Username: q.Get("username"),
+			Status:   q.Get("status"),
+		}
+		if filter.Status != "" && !models.IsOrderStatus(filter.Status) {
+			var v validation.Validator
+			v.Add("status", validation.CodeInvalidFormat,
+				"must be placed, ready_for_pickup, delivered or cancelled")
+			respondWithError(w, r, apierror.FromError(v.Err(), nil))
+			return
+		}
+		listOrders(w, r, store, filter)
+	}
+}
+
+// SetOrderStatusHandler moves an order along its lifecycle. Cancelling an
+// order refunds it.
+func SetOrderStatusHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := idParam(w, r)
+		if !ok {
+			return
+		}
+
+		var req models.OrderStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		now := time.Now()
+		order, err := store.SetOrderStatus(r.Context(), id, req.Status, now)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to update order")))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:    models.AuditOrderStatusChanged,
+			Actor:   auth.PrincipalFromContext(r.Context()).Username,
+			Subject: order.Username,
+			IP:      clientIP(r),
+			Details: map[string]string{
+				"id":     strconv.Itoa(order.ID),
+				"status": order.Status,
+				"total":  strconv.Itoa(order.Total),
+			},
+			CreatedAt: now,
+		})
+		respondWithJSON(w, http.StatusOK, order)
+	}
+}
+
+func listOrders(w http.ResponseWriter, r *http.Request, store storage.Storage, filter models.OrderFilter) {
+	filter.Limit = defaultOrdersLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxOrdersLimit {
+			var v validation.Validator
+			v.Add("limit", validation.CodeInvalidFormat,
+				fmt.Sprintf("must be a number from 1 to %d", maxOrdersLimit))
+			respondWithError(w, r, apierror.FromError(v.Err(), nil))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	orders, err := store.ListOrders(r.Context(), filter)
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("failed to list orders"))
+		return
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
+	respondWithJSON(w, http.StatusOK, orders)
+}
+
+// PurchasesHandler pages through the user's purchase history, newest first,
+// in the same way as TransactionsHandler.
+func PurchasesHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parsePurchaseFilter(r)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		username := auth.PrincipalFromContext(r.Context()).Username
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("user not found"))
+			return
+		}
+
+		// Fetch one extra row to find out whether there is a next page.
+		limit := filter.Limit
+		filter.Limit++
+		purchases, err := store.ListPurchases(r.Context(), user.ID, filter)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to get purchases"))
+			return
+		}
+
+		resp := models.PurchasesResponse{Purchases: purchases}
+		if len(purchases) > limit {
+			resp.Purchases = purchases[:limit]
+			last := resp.Purchases[limit-1]
+			resp.NextCursor = encodeCursor(models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		}
+		if resp.Purchases == nil {
+			resp.Purchases = []models.Purchase{}
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+func parsePurchaseFilter(r *http.Request) (models.PurchaseFilter, error) {
+	q := r.URL.Query()
+	filter := models.PurchaseFilter{Limit: defaultPurchasesLimit}
+
+	var v validation.Validator
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPurchasesLimit {
+			v.Add("limit", validation.CodeInvalidFormat,
+				fmt.Sprintf("must be a number from 1 to %d", maxPurchasesLimit))
+		}
+		filter.Limit = limit
+	}
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			v.Add("cursor", validation.CodeInvalidFormat, "is not a valid cursor")
+		}
+		filter.After = &cursor
+	}
+	return filter, v.Err()
+}
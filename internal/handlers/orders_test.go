@@ -0,0 +1,264 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestCreateOrderHandler(t *testing.T) {
+	cart := []models.OrderLine{{Item: "cup", Quantity: 2}, {Item: "pen", Quantity: 1}}
+	receipt := &models.Order{
+		ID: 7,
+		Items: []models.OrderItem{
+			{Item: "cup", Quantity: 2, Price: 20, Amount: 40},
+			{Item: "pen", Quantity: 1, Price: 10, Amount: 10},
+		},
+		Total:     50,
+		CreatedAt: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "successful checkout",
+			body: `{"items":[{"item":"cup","quantity":2},{"item":"pen","quantity":1}]}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("CreateOrder", mock.Anything, "buyer", cart, mock.Anything).Return(receipt, nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "empty cart",
+			body:           `{"items":[]}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name:           "invalid body",
+			body:           `{"items":`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_request",
+		},
+		{
+			name: "out of stock",
+			body: `{"items":[{"item":"cup","quantity":2},{"item":"pen","quantity":1}]}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("CreateOrder", mock.Anything, "buyer", cart, mock.Anything).Return((*models.Order)(nil), storage.ErrOutOfStock)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "out_of_stock",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest("POST", "/api/orders", strings.NewReader(tt.body))
+			req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"}))
+			rr := httptest.NewRecorder()
+			handlers.CreateOrderHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+			var order models.Order
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &order))
+			assert.Equal(t, *receipt, order)
+		})
+	}
+}
+
+func TestOrdersHandler(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("ListOrders", mock.Anything, models.OrderFilter{Username: "buyer", Limit: 5}).
+		Return([]models.Order(nil), nil)
+
+	req := httptest.NewRequest("GET", "/api/orders?limit=5", nil)
+	req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"}))
+	rr := httptest.NewRecorder()
+	handlers.OrdersHandler(mockStorage).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[]`, rr.Body.String())
+}
+
+func TestPurchasesHandler(t *testing.T) {
+	created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("pages through purchases", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "buyer").Return(&models.User{ID: 4, Username: "buyer"}, nil)
+		mockStorage.On("ListPurchases", mock.Anything, 4, models.PurchaseFilter{Limit: 3}).Return([]models.Purchase{
+			{ID: 9, Item: "book", CreatedAt: created},
+			{ID: 8, Item: "cup", CreatedAt: created},
+			{ID: 7, Item: "pen", CreatedAt: created},
+		}, nil)
+
+		req := httptest.NewRequest("GET", "/api/purchases?limit=2", nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"}))
+		rr := httptest.NewRecorder()
+		handlers.PurchasesHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var resp models.PurchasesResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Len(t, resp.Purchases, 2)
+		require.NotEmpty(t, resp.NextCursor)
+
+		// The cursor points at the last purchase of the page.
+		mockStorage.On("ListPurchases", mock.Anything, 4, models.PurchaseFilter{
+			After: &models.TransactionCursor{CreatedAt: created, ID: 8},
+			Limit: 3,
+		}).Return([]models.Purchase{{ID: 7, Item: "pen", CreatedAt: created}}, nil)
+
+		req = httptest.NewRequest("GET", "/api/purchases?limit=2&cursor="+resp.NextCursor, nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"}))
+		rr = httptest.NewRecorder()
+		handlers.PurchasesHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		resp = models.PurchasesResponse{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Len(t, resp.Purchases, 1)
+		assert.Empty(t, resp.NextCursor)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/purchases?cursor=!!", nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: "buyer"}))
+		rr := httptest.NewRecorder()
+		handlers.PurchasesHandler(mocks.NewStorage(t)).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestAdminOrdersHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+	}{
+		{
+			name:   "filters by user and status",
+			target: "/api/admin/orders?username=alice&status=placed",
+			mockSetup: func(m *mocks.Storage) {
+				m.On("ListOrders", mock.Anything, models.OrderFilter{Username: "alice", Status: models.OrderPlaced, Limit: 20}).
+					Return([]models.Order{{ID: 1, Username: "alice", Status: models.OrderPlaced}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown status",
+			target:         "/api/admin/orders?status=lost",
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid limit",
+			target:         "/api/admin/orders?limit=101",
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			rr := httptest.NewRecorder()
+			handlers.AdminOrdersHandler(mockStorage).ServeHTTP(rr, adminRequest("GET", tt.target, "", nil))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestSetOrderStatusHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "cancels the order",
+			body: `{"status":"cancelled"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("SetOrderStatus", mock.Anything, 3, models.OrderCancelled, mock.Anything).
+					Return(&models.Order{ID: 3, Username: "alice", Status: models.OrderCancelled, Total: 40}, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditOrderStatusChanged && e.Actor == "root" && e.Subject == "alice" &&
+						e.Details["status"] == models.OrderCancelled
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "delivered orders are final",
+			body: `{"status":"cancelled"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("SetOrderStatus", mock.Anything, 3, models.OrderCancelled, mock.Anything).
+					Return((*models.Order)(nil), storage.ErrOrderStatusTransition)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "invalid_order_transition",
+		},
+		{
+			name:           "orders cannot be placed again",
+			body:           `{"status":"placed"}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := adminRequest("POST", "/api/admin/orders/3/status", tt.body, map[string]string{"id": "3"})
+			rr := httptest.NewRecorder()
+			handlers.SetOrderStatusHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+			}
+		})
+	}
+}
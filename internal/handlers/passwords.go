@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+// ChangePasswordHandler sets a new password for the authenticated user.
+// All sessions, including the current one, are revoked and a new one is
+// started, after the second factor if 2FA is on. Wrong current passwords
+// count as failed logins.
+func ChangePasswordHandler(store storage.Storage, tokens *auth.TokenManager, guard *lockout.Guard, hasher *password.Hasher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := auth.PrincipalFromContext(r.Context()).Username
+
+		var req models.ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		ip := clientIP(r)
+		if err := guard.Check(r.Context(), username, ip); err != nil {
+			respondThrottled(w, r, err)
+			return
+		}
+
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+		if _, err := hasher.Verify(user.PasswordHash, req.CurrentPassword); err != nil {
+			if !errors.Is(err, password.ErrMismatch) {
+				respondWithError(w, r, apierror.Internal.WithMessage("failed to verify password"))
+				return
+			}
+			if err := guard.Failure(r.Context(), username, ip); err != nil {
+				respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+				return
+			}
+			respondWithError(w, r, apierror.WrongPassword)
+			return
+		}
+
+		hash, err := hasher.Hash(req.NewPassword)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to hash password"))
+			return
+		}
+		now := time.Now()
+		if err := store.ChangePassword(r.Context(), user.ID, hash, now); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to change password"))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditPasswordChanged,
+			Actor:     username,
+			Subject:   username,
+			IP:        ip,
+			CreatedAt: now,
+		})
+		if err := guard.Success(r.Context(), username); err != nil {
+			log.Printf("failed to reset login failures: %v", err)
+		}
+
+		// The new session takes the second factor, as at login.
+		enabled, err := twoFactorEnabled(r.Context(), store, user.ID)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+		if enabled {
+			respondChallenge(w, r, tokens, user)
+			return
+		}
+
+		resp, err := startSession(r.Context(), store, tokens, user)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// ResetPasswordHandler sets a new password with a one-time reset token
+// issued by an administrator, and logs the user in, asking for the second
+// factor first if 2FA is on. Earlier sessions and failed login attempts of
+// the user are cleared.
+func ResetPasswordHandler(store storage.Storage, tokens *auth.TokenManager, guard *lockout.Guard, hasher *password.Hasher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		hash, err := hasher.Hash(req.NewPassword)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to hash password"))
+			return
+		}
+		now := time.Now()
+		user, err := store.ResetPassword(r.Context(), password.HashResetToken(req.Token), hash, now)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to reset password")))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditPasswordReset,
+			Subject:   user.Username,
+			IP:        clientIP(r),
+			CreatedAt: now,
+		})
+		if err := guard.Success(r.Context(), user.Username); err != nil {
+			log.Printf("failed to reset login failures: %v", err)
+		}
+
+		// The new session takes the second factor, as at login.
+		enabled, err := twoFactorEnabled(r.Context(), store, user.ID)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+		if enabled {
+			respondChallenge(w, r, tokens, user)
+			return
+		}
+
+		resp, err := startSession(r.Context(), store, tokens, user)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// audit records event. The action it describes has already happened, so a
+// failure is only logged.
+func audit(ctx context.Context, store storage.Storage, event models.AuditEvent) {
+	if err := store.CreateAuditEvent(ctx, event); err != nil {
+		log.Printf("failed to record %s audit event: %v", event.Type, err)
+	}
+}
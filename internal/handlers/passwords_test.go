@@ -0,0 +1,229 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestChangePasswordHandler(t *testing.T) {
+	currentHash, err := testHasher.Hash("current")
+	require.NoError(t, err)
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		request        models.ChangePasswordRequest
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:    "changes the password and starts a new session",
+			request: models.ChangePasswordRequest{CurrentPassword: "current", NewPassword: "new-password"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").
+					Return(&models.User{ID: 7, Username: "alice", PasswordHash: currentHash}, nil)
+				m.On("ChangePassword", mock.Anything, 7, mock.MatchedBy(func(hash string) bool {
+					return verifies(hash, "new-password")
+				}), mock.Anything).Return(nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditPasswordChanged && e.Subject == "alice"
+				})).Return(nil)
+				m.On("GetTOTP", mock.Anything, 7).Return((*models.TOTP)(nil), storage.ErrTOTPNotFound)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "wrong current password",
+			request: models.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "new-password"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").
+					Return(&models.User{ID: 7, Username: "alice", PasswordHash: currentHash}, nil)
+				m.On("RecordLoginFailure", mock.Anything, models.ThrottleScopeUsername, "alice", mock.Anything, mock.Anything).
+					Return(1, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "wrong_password",
+		},
+		{
+			name:           "missing new password",
+			request:        models.ChangePasswordRequest{CurrentPassword: "current"},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+			allowLogins(mockStorage)
+
+			body, _ := json.Marshal(tt.request)
+			req := withClaims(httptest.NewRequest("POST", "/api/password", bytes.NewReader(body)), claims)
+			rr := httptest.NewRecorder()
+			guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+			handlers.ChangePasswordHandler(mockStorage, testTokens, guard, testHasher).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.AuthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			newClaims, err := testTokens.Parse(response.Token)
+			require.NoError(t, err)
+			assert.NotEqual(t, claims.SessionID, newClaims.SessionID)
+		})
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        models.ResetPasswordRequest
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:    "resets the password",
+			request: models.ResetPasswordRequest{Token: "reset-token", NewPassword: "new-password"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("ResetPassword", mock.Anything, password.HashResetToken("reset-token"), mock.MatchedBy(func(hash string) bool {
+					return verifies(hash, "new-password")
+				}), mock.Anything).Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditPasswordReset && e.Subject == "alice"
+				})).Return(nil)
+				m.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, "alice").Return(nil)
+				m.On("GetTOTP", mock.Anything, 7).Return((*models.TOTP)(nil), storage.ErrTOTPNotFound)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "invalid token",
+			request: models.ResetPasswordRequest{Token: "used-token", NewPassword: "new-password"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("ResetPassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return((*models.User)(nil), storage.ErrResetTokenInvalid)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "invalid_reset_token",
+		},
+		{
+			name:           "missing token",
+			request:        models.ResetPasswordRequest{NewPassword: "new-password"},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			body, _ := json.Marshal(tt.request)
+			req := httptest.NewRequest("POST", "/api/password/reset", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+			handlers.ResetPasswordHandler(mockStorage, testTokens, guard, testHasher).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.AuthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			claims, err := testTokens.Parse(response.Token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Username)
+		})
+	}
+}
+
+// assertChallenge checks that rr asks alice for the second factor instead
+// of carrying a session.
+func assertChallenge(t *testing.T, rr *httptest.ResponseRecorder, mockStorage *mocks.Storage) {
+	t.Helper()
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response models.TwoFactorChallenge
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.True(t, response.TwoFactorRequired)
+	username, err := testTokens.ParseChallenge(response.ChallengeToken)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	mockStorage.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestChangePasswordHandlerTwoFactor(t *testing.T) {
+	currentHash, err := testHasher.Hash("current")
+	require.NoError(t, err)
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").
+		Return(&models.User{ID: 7, Username: "alice", PasswordHash: currentHash}, nil)
+	mockStorage.On("ChangePassword", mock.Anything, 7, mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+	allowLogins(mockStorage)
+
+	body, _ := json.Marshal(models.ChangePasswordRequest{CurrentPassword: "current", NewPassword: "new-password"})
+	req := withClaims(httptest.NewRequest("POST", "/api/password", bytes.NewReader(body)), claims)
+	rr := httptest.NewRecorder()
+	guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+	handlers.ChangePasswordHandler(mockStorage, testTokens, guard, testHasher).ServeHTTP(rr, req)
+
+	assertChallenge(t, rr, mockStorage)
+}
+
+func TestResetPasswordHandlerTwoFactor(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("ResetPassword", mock.Anything, password.HashResetToken("reset-token"), mock.Anything, mock.Anything).
+		Return(&models.User{ID: 7, Username: "alice"}, nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.Anything).Return(nil)
+	mockStorage.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, "alice").Return(nil)
+	mockStorage.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+
+	body, _ := json.Marshal(models.ResetPasswordRequest{Token: "reset-token", NewPassword: "new-password"})
+	rr := httptest.NewRecorder()
+	guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+	handlers.ResetPasswordHandler(mockStorage, testTokens, guard, testHasher).
+		ServeHTTP(rr, httptest.NewRequest("POST", "/api/password/reset", bytes.NewReader(body)))
+
+	// A reset token alone does not skip the second factor.
+	assertChallenge(t, rr, mockStorage)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+// startSession opens a new session for user and returns its first token pair.
+func startSession(ctx context.Context, store storage.Storage, tokens *auth.TokenManager, user *models.User) (models.AuthResponse, error) {
+	roles, err := store.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return models.AuthResponse{}, err
+	}
+
+	session, refreshToken, record, err := tokens.NewSession(user.ID)
+	if err != nil {
+		return models.AuthResponse{}, err
+	}
+	if err := store.CreateSession(ctx, session, record); err != nil {
+		return models.AuthResponse{}, err
+	}
+
+	accessToken, _, err := tokens.Issue(user.Username, session.ID, roles)
+	if err != nil {
+		return models.AuthResponse{}, err
+	}
+	return models.AuthResponse{Token: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new token pair. Every
+// refresh token can be used once; presenting it again revokes its session.
+func RefreshHandler(store storage.Storage, tokens *auth.TokenManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		refreshToken, next, err := tokens.NewRefreshToken()
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+
+		session, err := store.RotateRefreshToken(r.Context(), auth.HashRefreshToken(req.RefreshToken), next)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to refresh token")))
+			return
+		}
+
+		roles, err := store.GetUserRoles(r.Context(), session.UserID)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return
+		}
+
+		accessToken, _, err := tokens.Issue(session.Username, session.ID, roles)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.AuthResponse{Token: accessToken, RefreshToken: refreshToken})
+	}
+}
+
+// LogoutHandler revokes the access token of the request and its session,
+// which also invalidates the session's refresh token.
+func LogoutHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.ClaimsFromContext(r.Context())
+		now := time.Now()
+
+		if err := store.RevokeAccessToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to revoke token"))
+			return
+		}
+		if err := store.RevokeSession(r.Context(), claims.SessionID, now); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to revoke session"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// LogoutAllHandler revokes every session of the user, on all devices.
+func LogoutAllHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := auth.PrincipalFromContext(r.Context()).Username
+
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("database error")))
+			return
+		}
+
+		if _, err := store.RevokeUserSessions(r.Context(), user.ID, time.Now()); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to revoke sessions"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "rotates the token",
+			body: `{"refreshToken":"old-token"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RotateRefreshToken", mock.Anything, auth.HashRefreshToken("old-token"), mock.Anything).
+					Return(&models.Session{ID: "session-1", UserID: 1, Username: "alice"}, nil)
+				m.On("GetUserRoles", mock.Anything, 1).Return([]string{models.RoleUser, models.RoleAdmin}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing token",
+			body:           `{}`,
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "validation_failed",
+		},
+		{
+			name: "unknown token",
+			body: `{"refreshToken":"unknown"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything).
+					Return((*models.Session)(nil), storage.ErrRefreshTokenNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_refresh_token",
+		},
+		{
+			name: "reused token",
+			body: `{"refreshToken":"old-token"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything).
+					Return((*models.Session)(nil), storage.ErrRefreshTokenReused)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "refresh_token_reused",
+		},
+		{
+			name: "storage error",
+			body: `{"refreshToken":"old-token"}`,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("RotateRefreshToken", mock.Anything, mock.Anything, mock.Anything).
+					Return((*models.Session)(nil), errors.New("db error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "internal_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+
+			req := httptest.NewRequest("POST", "/api/auth/refresh", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handlers.RefreshHandler(mockStorage, testTokens).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.AuthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			claims, err := testTokens.Parse(response.Token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Username)
+			assert.Equal(t, "session-1", claims.SessionID)
+			assert.Equal(t, []string{models.RoleUser, models.RoleAdmin}, claims.Roles, "roles are reloaded on refresh")
+			assert.NotEmpty(t, response.RefreshToken)
+			assert.NotEqual(t, "old-token", response.RefreshToken)
+		})
+	}
+}
+
+func withClaims(req *http.Request, claims *auth.Claims) *http.Request {
+	return req.WithContext(auth.WithClaims(req.Context(), claims))
+}
+
+func TestLogoutHandler(t *testing.T) {
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	t.Run("revokes the token and its session", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("RevokeAccessToken", mock.Anything, claims.ID, claims.ExpiresAt.Time).Return(nil)
+		mockStorage.On("RevokeSession", mock.Anything, "session-1", mock.Anything).Return(nil)
+
+		rr := httptest.NewRecorder()
+		req := withClaims(httptest.NewRequest("POST", "/api/auth/logout", nil), claims)
+		handlers.LogoutHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("RevokeAccessToken", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("db error"))
+
+		rr := httptest.NewRecorder()
+		req := withClaims(httptest.NewRequest("POST", "/api/auth/logout", nil), claims)
+		handlers.LogoutHandler(mockStorage).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
+
+func TestLogoutAllHandler(t *testing.T) {
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+	mockStorage.On("RevokeUserSessions", mock.Anything, 7, mock.Anything).Return(int64(3), nil)
+
+	rr := httptest.NewRecorder()
+	req := withClaims(httptest.NewRequest("POST", "/api/auth/logout-all", nil), claims)
+	handlers.LogoutAllHandler(mockStorage).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
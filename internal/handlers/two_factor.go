@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/totp"
+)
+
+// errWrongCode is returned by verifySecondFactor for TOTP codes that do
+// not match the secret.
+var errWrongCode = errors.New("wrong TOTP code")
+
+// EnrollTwoFactorHandler generates a new TOTP secret for the user. It has
+// no effect until confirmed with a code from the authenticator app.
+func EnrollTwoFactorHandler(store storage.Storage, issuer string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := auth.PrincipalFromContext(r.Context()).Username
+
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("user not found"))
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to generate secret"))
+			return
+		}
+		if err := store.SetPendingTOTP(r.Context(), user.ID, secret, time.Now()); err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to enroll")))
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.TwoFactorEnrollment{
+			Secret: secret,
+			URI:    totp.URI(issuer, username, secret),
+		})
+	}
+}
+
+// ConfirmTwoFactorHandler enables 2FA once the user proves the app works
+// by sending a current code. It returns the recovery codes, which are
+// shown only this once.
+func ConfirmTwoFactorHandler(store storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := auth.PrincipalFromContext(r.Context()).Username
+
+		var req models.TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		user, err := store.GetUserByUsername(r.Context(), username)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("user not found"))
+			return
+		}
+		pending, err := store.GetTOTP(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("database error")))
+			return
+		}
+		if pending.ConfirmedAt != nil {
+			respondWithError(w, r, apierror.TwoFactorEnabled)
+			return
+		}
+
+		now := time.Now()
+		step, ok := totp.Validate(pending.Secret, req.Code, now)
+		if !ok {
+			respondWithError(w, r, apierror.InvalidTwoFactorCode)
+			return
+		}
+
+		codes, hashes, err := totp.NewRecoveryCodes()
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to generate recovery codes"))
+			return
+		}
+		if err := store.ConfirmTOTP(r.Context(), user.ID, step, hashes, now); err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to enable 2FA")))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditTwoFactorEnabled,
+			Actor:     username,
+			Subject:   username,
+			IP:        clientIP(r),
+			CreatedAt: now,
+		})
+
+		respondWithJSON(w, http.StatusOK, models.RecoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+// DisableTwoFactorHandler turns 2FA off given a TOTP or recovery code.
+// Wrong codes count as failed logins.
+func DisableTwoFactorHandler(store storage.Storage, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := auth.PrincipalFromContext(r.Context()).Username
+
+		var req models.TwoFactorCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		user, ok := checkSecondFactor(w, r, store, guard, username, req.Code)
+		if !ok {
+			return
+		}
+		if err := store.DeleteTOTP(r.Context(), user.ID); err != nil {
+			respondWithError(w, r, apierror.FromError(err, apierror.Internal.WithMessage("failed to disable 2FA")))
+			return
+		}
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditTwoFactorDisabled,
+			Actor:     username,
+			Subject:   username,
+			IP:        clientIP(r),
+			CreatedAt: time.Now(),
+		})
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// TwoFactorLoginHandler completes a login started at /api/auth: it
+// exchanges the challenge token and a TOTP or recovery code for tokens.
+func TwoFactorLoginHandler(store storage.Storage, tokens *auth.TokenManager, guard *lockout.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.TwoFactorLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, apierror.InvalidRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			respondWithError(w, r, apierror.FromError(err, nil))
+			return
+		}
+
+		username, err := tokens.ParseChallenge(req.ChallengeToken)
+		if err != nil {
+			respondWithError(w, r, apierror.InvalidChallengeToken)
+			return
+		}
+
+		user, ok := checkSecondFactor(w, r, store, guard, username, req.Code)
+		if !ok {
+			return
+		}
+
+		resp, err := startSession(r.Context(), store, tokens, user)
+		if err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+			return
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// checkSecondFactor verifies code for a user with 2FA enabled, counting
+// wrong codes as failed logins. If it returns false, the error response has
+// been written.
+func checkSecondFactor(w http.ResponseWriter, r *http.Request, store storage.Storage, guard *lockout.Guard, username, code string) (*models.User, bool) {
+	ip := clientIP(r)
+	if err := guard.Check(r.Context(), username, ip); err != nil {
+		respondThrottled(w, r, err)
+		return nil, false
+	}
+
+	user, err := store.GetUserByUsername(r.Context(), username)
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+		return nil, false
+	}
+	enrollment, err := store.GetTOTP(r.Context(), user.ID)
+	if errors.Is(err, storage.ErrTOTPNotFound) || (err == nil && enrollment.ConfirmedAt == nil) {
+		respondWithError(w, r, apierror.TwoFactorNotEnabled)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+		return nil, false
+	}
+
+	now := time.Now()
+	recovery, err := verifySecondFactor(r.Context(), store, enrollment, code, now)
+	if errors.Is(err, errWrongCode) || errors.Is(err, storage.ErrTOTPCodeReused) || errors.Is(err, storage.ErrRecoveryCodeInvalid) {
+		if err := guard.Failure(r.Context(), username, ip); err != nil {
+			respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+			return nil, false
+		}
+		respondWithError(w, r, apierror.InvalidTwoFactorCode)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+		return nil, false
+	}
+	if err := guard.Success(r.Context(), username); err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("database error"))
+		return nil, false
+	}
+
+	if recovery {
+		audit(r.Context(), store, models.AuditEvent{
+			Type:      models.AuditRecoveryCodeUsed,
+			Actor:     username,
+			Subject:   username,
+			IP:        ip,
+			CreatedAt: now,
+		})
+	}
+	return user, true
+}
+
+// verifySecondFactor accepts a TOTP code for a step later than the last
+// one used, or an unused recovery code. It reports which one it was.
+func verifySecondFactor(ctx context.Context, store storage.Storage, enrollment *models.TOTP, code string, now time.Time) (bool, error) {
+	if isDigits(code) {
+		step, ok := totp.Validate(enrollment.Secret, code, now)
+		if !ok {
+			return false, errWrongCode
+		}
+		return false, store.UseTOTPStep(ctx, enrollment.UserID, step)
+	}
+	return true, store.UseRecoveryCode(ctx, enrollment.UserID, totp.HashRecoveryCode(code), now)
+}
+
+// respondChallenge asks a user with 2FA on for the second factor instead of
+// starting a session; POST /api/auth/2fa starts it.
+func respondChallenge(w http.ResponseWriter, r *http.Request, tokens *auth.TokenManager, user *models.User) {
+	challenge, expiresAt, err := tokens.IssueChallenge(user.Username)
+	if err != nil {
+		respondWithError(w, r, apierror.Internal.WithMessage("failed to issue token"))
+		return
+	}
+	respondWithJSON(w, http.StatusOK, models.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresAt:         expiresAt,
+	})
+}
+
+// twoFactorEnabled reports whether the user has confirmed 2FA.
+func twoFactorEnabled(ctx context.Context, store storage.Storage, userID int) (bool, error) {
+	enrollment, err := store.GetTOTP(ctx, userID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enrollment.ConfirmedAt != nil, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,313 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func confirmedTOTP(userID int) *models.TOTP {
+	confirmed := time.Now().Add(-time.Hour)
+	return &models.TOTP{UserID: userID, Secret: testTOTPSecret, ConfirmedAt: &confirmed}
+}
+
+func currentCode(t *testing.T) (string, int64) {
+	step := totp.Step(time.Now())
+	code, err := totp.Code(testTOTPSecret, step)
+	require.NoError(t, err)
+	return code, step
+}
+
+func TestAuthHandlerTwoFactorChallenge(t *testing.T) {
+	hash, err := testHasher.Hash("correctpassword")
+	require.NoError(t, err)
+
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").
+		Return(&models.User{ID: 7, Username: "alice", PasswordHash: hash}, nil)
+	mockStorage.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+	allowLogins(mockStorage)
+
+	body, _ := json.Marshal(models.AuthRequest{Username: "alice", Password: "correctpassword"})
+	rr := httptest.NewRecorder()
+	guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+	handlers.AuthHandler(mockStorage, testTokens, guard, testHasher).
+		ServeHTTP(rr, httptest.NewRequest("POST", "/api/auth", bytes.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response models.TwoFactorChallenge
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.True(t, response.TwoFactorRequired)
+	username, err := testTokens.ParseChallenge(response.ChallengeToken)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+
+	mockStorage.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "ResetLoginFailures", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEnrollTwoFactorHandler(t *testing.T) {
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+
+	t.Run("returns a new secret", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+		mockStorage.On("SetPendingTOTP", mock.Anything, 7, mock.Anything, mock.Anything).Return(nil)
+
+		rr := httptest.NewRecorder()
+		handlers.EnrollTwoFactorHandler(mockStorage, "Avito Shop").
+			ServeHTTP(rr, withClaims(httptest.NewRequest("POST", "/api/2fa/enroll", nil), claims))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var response models.TwoFactorEnrollment
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.NotEmpty(t, response.Secret)
+		assert.True(t, strings.HasPrefix(response.URI, "otpauth://totp/Avito%20Shop:alice?"), response.URI)
+		assert.Contains(t, response.URI, "secret="+response.Secret)
+		mockStorage.AssertCalled(t, "SetPendingTOTP", mock.Anything, 7, response.Secret, mock.Anything)
+	})
+
+	t.Run("already enabled", func(t *testing.T) {
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+		mockStorage.On("SetPendingTOTP", mock.Anything, 7, mock.Anything, mock.Anything).Return(storage.ErrTOTPEnabled)
+
+		rr := httptest.NewRecorder()
+		handlers.EnrollTwoFactorHandler(mockStorage, "Avito Shop").
+			ServeHTTP(rr, withClaims(httptest.NewRequest("POST", "/api/2fa/enroll", nil), claims))
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		var response apierror.Error
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		assert.Equal(t, "two_factor_enabled", response.Code)
+	})
+}
+
+func TestConfirmTwoFactorHandler(t *testing.T) {
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	code, step := currentCode(t)
+
+	tests := []struct {
+		name           string
+		code           string
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name: "enables 2FA",
+			code: code,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetTOTP", mock.Anything, 7).Return(&models.TOTP{UserID: 7, Secret: testTOTPSecret}, nil)
+				m.On("ConfirmTOTP", mock.Anything, 7, step, mock.MatchedBy(func(hashes []string) bool {
+					return len(hashes) == totp.RecoveryCodeCount
+				}), mock.Anything).Return(nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditTwoFactorEnabled && e.Subject == "alice"
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "wrong code",
+			code: "000000",
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetTOTP", mock.Anything, 7).Return(&models.TOTP{UserID: 7, Secret: "GEZDGNBVGY3TQOJQ"}, nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_two_factor_code",
+		},
+		{
+			name: "not enrolled",
+			code: code,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetTOTP", mock.Anything, 7).Return((*models.TOTP)(nil), storage.ErrTOTPNotFound)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "two_factor_not_enabled",
+		},
+		{
+			name: "already enabled",
+			code: code,
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "two_factor_enabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+			tt.mockSetup(mockStorage)
+
+			body, _ := json.Marshal(models.TwoFactorCodeRequest{Code: tt.code})
+			req := withClaims(httptest.NewRequest("POST", "/api/2fa/confirm", bytes.NewReader(body)), claims)
+			rr := httptest.NewRecorder()
+			handlers.ConfirmTwoFactorHandler(mockStorage).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.RecoveryCodes
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Len(t, response.RecoveryCodes, totp.RecoveryCodeCount)
+		})
+	}
+}
+
+func TestTwoFactorLoginHandler(t *testing.T) {
+	challenge, _, err := testTokens.IssueChallenge("alice")
+	require.NoError(t, err)
+	access, _, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	code, step := currentCode(t)
+
+	tests := []struct {
+		name           string
+		request        models.TwoFactorLoginRequest
+		mockSetup      func(*mocks.Storage)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:    "TOTP code",
+			request: models.TwoFactorLoginRequest{ChallengeToken: challenge, Code: code},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+				m.On("UseTOTPStep", mock.Anything, 7, step).Return(nil)
+				m.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, "alice").Return(nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "recovery code",
+			request: models.TwoFactorLoginRequest{ChallengeToken: challenge, Code: "ABCDE-FGHJK"},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+				m.On("UseRecoveryCode", mock.Anything, 7, totp.HashRecoveryCode("abcde-fghjk"), mock.Anything).Return(nil)
+				m.On("ResetLoginFailures", mock.Anything, models.ThrottleScopeUsername, "alice").Return(nil)
+				m.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+					return e.Type == models.AuditRecoveryCodeUsed && e.Subject == "alice"
+				})).Return(nil)
+				m.On("CreateSession", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+				m.On("GetUserRoles", mock.Anything, mock.Anything).Return([]string{models.RoleUser}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "reused code counts as a failure",
+			request: models.TwoFactorLoginRequest{ChallengeToken: challenge, Code: code},
+			mockSetup: func(m *mocks.Storage) {
+				m.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+				m.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+				m.On("UseTOTPStep", mock.Anything, 7, step).Return(storage.ErrTOTPCodeReused)
+				m.On("RecordLoginFailure", mock.Anything, models.ThrottleScopeUsername, "alice", mock.Anything, mock.Anything).
+					Return(1, nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_two_factor_code",
+		},
+		{
+			name:           "access token is not a challenge",
+			request:        models.TwoFactorLoginRequest{ChallengeToken: access, Code: code},
+			mockSetup:      func(m *mocks.Storage) {},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "invalid_challenge_token",
+		},
+		{
+			name:    "locked account",
+			request: models.TwoFactorLoginRequest{ChallengeToken: challenge, Code: code},
+			mockSetup: func(m *mocks.Storage) {
+				until := time.Now().Add(10 * time.Minute)
+				m.On("GetLoginThrottle", mock.Anything, models.ThrottleScopeUsername, "alice").
+					Return(&models.LoginThrottle{Failures: 10, BlockedUntil: &until, Locked: true}, nil)
+			},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   "account_locked",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := mocks.NewStorage(t)
+			tt.mockSetup(mockStorage)
+			allowLogins(mockStorage)
+
+			body, _ := json.Marshal(tt.request)
+			rr := httptest.NewRecorder()
+			guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+			handlers.TwoFactorLoginHandler(mockStorage, testTokens, guard).
+				ServeHTTP(rr, httptest.NewRequest("POST", "/api/auth/2fa", bytes.NewReader(body)))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedCode != "" {
+				var response apierror.Error
+				json.Unmarshal(rr.Body.Bytes(), &response)
+				assert.Equal(t, tt.expectedCode, response.Code)
+				return
+			}
+
+			var response models.AuthResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			claims, err := testTokens.Parse(response.Token)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Username)
+		})
+	}
+}
+
+func TestDisableTwoFactorHandler(t *testing.T) {
+	_, claims, err := testTokens.Issue("alice", "session-1", nil)
+	require.NoError(t, err)
+	code, step := currentCode(t)
+
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("GetUserByUsername", mock.Anything, "alice").Return(&models.User{ID: 7, Username: "alice"}, nil)
+	mockStorage.On("GetTOTP", mock.Anything, 7).Return(confirmedTOTP(7), nil)
+	mockStorage.On("UseTOTPStep", mock.Anything, 7, step).Return(nil)
+	mockStorage.On("DeleteTOTP", mock.Anything, 7).Return(nil)
+	mockStorage.On("CreateAuditEvent", mock.Anything, mock.MatchedBy(func(e models.AuditEvent) bool {
+		return e.Type == models.AuditTwoFactorDisabled && e.Subject == "alice"
+	})).Return(nil)
+	allowLogins(mockStorage)
+
+	body, _ := json.Marshal(models.TwoFactorCodeRequest{Code: code})
+	rr := httptest.NewRecorder()
+	guard := lockout.NewGuard(mockStorage, lockout.DefaultPolicy())
+	handlers.DisableTwoFactorHandler(mockStorage, guard).
+		ServeHTTP(rr, withClaims(httptest.NewRequest("POST", "/api/2fa/disable", bytes.NewReader(body)), claims))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,201 @@
+// Package lockout slows down password guessing. Failed logins are counted
+// per username and per client IP; after a few free attempts every failure
+// blocks further logins for an exponentially growing delay, and too many
+// failures for a username lock the account.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+// Limits configures the throttling of one scope.
+type Limits struct {
+	// FreeAttempts failures are allowed without any delay.
+	FreeAttempts int
+	// BaseDelay is the block after the first failure past FreeAttempts;
+	// it doubles with every further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockAfter failures lock the key for LockDuration. Zero disables locking.
+	LockAfter    int
+	LockDuration time.Duration
+}
+
+// Block returns how long logins are blocked after the given number of
+// consecutive failures and whether that block is a lockout.
+func (l Limits) Block(failures int) (time.Duration, bool) {
+	if l.LockAfter > 0 && failures >= l.LockAfter {
+		return l.LockDuration, true
+	}
+	if failures <= l.FreeAttempts || l.BaseDelay <= 0 {
+		return 0, false
+	}
+
+	delay := l.BaseDelay
+	for i := l.FreeAttempts + 1; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.MaxDelay), false
+}
+
+type Policy struct {
+	Username Limits
+	IP       Limits
+	// ResetAfter is how long after the last failure the counters start over.
+	ResetAfter time.Duration
+}
+
+// DefaultPolicy locks an account for 15 minutes after 10 failures. A single
+// IP gets more free attempts since it may be shared, but is never locked.
+func DefaultPolicy() Policy {
+	return Policy{
+		Username: Limits{
+			FreeAttempts: 3,
+			BaseDelay:    time.Second,
+			MaxDelay:     5 * time.Minute,
+			LockAfter:    10,
+			LockDuration: 15 * time.Minute,
+		},
+		IP: Limits{
+			FreeAttempts: 10,
+			BaseDelay:    time.Second,
+			MaxDelay:     15 * time.Minute,
+		},
+		ResetAfter: 24 * time.Hour,
+	}
+}
+
+// ThrottledError is returned by Guard.Check while logins are blocked.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	// Locked is set if the account is locked rather than delayed.
+	Locked bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+type Guard struct {
+	store  storage.Storage
+	policy Policy
+	now    func() time.Time
+}
+
+func NewGuard(store storage.Storage, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy, now: time.Now}
+}
+
+// WithClock returns a copy of g that reads the time from now. It is meant for tests.
+func (g *Guard) WithClock(now func() time.Time) *Guard {
+	c := *g
+	c.now = now
+	return &c
+}
+
+// Check returns a *ThrottledError if logins for username or from ip are
+// currently blocked. An empty ip is not checked.
+func (g *Guard) Check(ctx context.Context, username, ip string) error {
+	now := g.now()
+	var blocked *ThrottledError
+	for _, k := range g.keys(username, ip) {
+		throttle, err := g.store.GetLoginThrottle(ctx, k.scope, k.key)
+		if err != nil {
+			return err
+		}
+		if throttle.BlockedUntil == nil || !throttle.BlockedUntil.After(now) {
+			continue
+		}
+		retryAfter := throttle.BlockedUntil.Sub(now)
+		if blocked == nil || retryAfter > blocked.RetryAfter {
+			blocked = &ThrottledError{RetryAfter: retryAfter, Locked: throttle.Locked}
+		}
+	}
+	if blocked != nil {
+		return blocked
+	}
+	return nil
+}
+
+// Failure records a failed login and blocks further attempts as the policy
+// says. Locking an account writes an audit event.
+func (g *Guard) Failure(ctx context.Context, username, ip string) error {
+	now := g.now()
+	resetBefore := now.Add(-g.policy.ResetAfter)
+	for _, k := range g.keys(username, ip) {
+		failures, err := g.store.RecordLoginFailure(ctx, k.scope, k.key, now, resetBefore)
+		if err != nil {
+			return err
+		}
+		delay, locked := k.limits.Block(failures)
+		if delay <= 0 {
+			continue
+		}
+		if err := g.store.BlockLogin(ctx, k.scope, k.key, now.Add(delay), locked); err != nil {
+			return err
+		}
+		if !locked {
+			continue
+		}
+
+		event := models.AuditEvent{
+			Type:      models.AuditAccountLocked,
+			IP:        ip,
+			CreatedAt: now,
+			Details: map[string]string{
+				"scope":    k.scope,
+				"failures": strconv.Itoa(failures),
+				"until":    now.Add(delay).UTC().Format(time.RFC3339),
+			},
+		}
+		if k.scope == models.ThrottleScopeUsername {
+			event.Subject = username
+		}
+		if err := g.store.CreateAuditEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Success clears the username's failures. The IP counter is kept, so that
+// logging into one's own account does not reset guessing at others.
+func (g *Guard) Success(ctx context.Context, username string) error {
+	return g.store.ResetLoginFailures(ctx, models.ThrottleScopeUsername, username)
+}
+
+// Unlock clears the username's failures and any lockout on behalf of actor.
+func (g *Guard) Unlock(ctx context.Context, username, actor string) error {
+	if err := g.store.ResetLoginFailures(ctx, models.ThrottleScopeUsername, username); err != nil {
+		return err
+	}
+	return g.store.CreateAuditEvent(ctx, models.AuditEvent{
+		Type:      models.AuditAccountUnlocked,
+		Actor:     actor,
+		Subject:   username,
+		CreatedAt: g.now(),
+	})
+}
+
+type scopedKey struct {
+	scope  string
+	key    string
+	limits Limits
+}
+
+func (g *Guard) keys(username, ip string) []scopedKey {
+	keys := []scopedKey{{models.ThrottleScopeUsername, username, g.policy.Username}}
+	if ip != "" {
+		keys = append(keys, scopedKey{models.ThrottleScopeIP, ip, g.policy.IP})
+	}
+	return keys
+}
@@ -0,0 +1,147 @@
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/lockout"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+func TestLimitsBlock(t *testing.T) {
+	limits := lockout.Limits{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Second,
+		LockAfter:    8,
+		LockDuration: time.Hour,
+	}
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, 5 * time.Second, false},
+		{7, 5 * time.Second, false},
+		{8, time.Hour, true},
+		{20, time.Hour, true},
+	}
+	for _, tt := range tests {
+		delay, locked := limits.Block(tt.failures)
+		assert.Equal(t, tt.delay, delay, "failures=%d", tt.failures)
+		assert.Equal(t, tt.locked, locked, "failures=%d", tt.failures)
+	}
+
+	delay, locked := lockout.Limits{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Minute}.Block(1000)
+	assert.Equal(t, time.Minute, delay, "no overflow for large counts")
+	assert.False(t, locked, "locking is disabled without LockAfter")
+}
+
+type clock struct{ now time.Time }
+
+func newClock() *clock {
+	return &clock{now: time.Now().UTC().Truncate(time.Second)}
+}
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func throttled(t *testing.T, err error) *lockout.ThrottledError {
+	t.Helper()
+	var e *lockout.ThrottledError
+	require.ErrorAs(t, err, &e)
+	return e
+}
+
+func newGuard(store storage.Storage, c *clock) *lockout.Guard {
+	return lockout.NewGuard(store, lockout.Policy{
+		Username:   lockout.Limits{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Minute, LockAfter: 4, LockDuration: time.Hour},
+		IP:         lockout.Limits{FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute},
+		ResetAfter: 24 * time.Hour,
+	}).WithClock(c.Now)
+}
+
+func TestGuardBackoffAndLockout(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	c := newClock()
+	guard := newGuard(store, c)
+
+	require.NoError(t, guard.Check(ctx, "alice", "10.0.0.1"))
+	require.NoError(t, guard.Failure(ctx, "alice", "10.0.0.1"))
+	assert.NoError(t, guard.Check(ctx, "alice", "10.0.0.1"), "first failure is free")
+
+	require.NoError(t, guard.Failure(ctx, "alice", "10.0.0.1"))
+	blocked := throttled(t, guard.Check(ctx, "alice", "10.0.0.2"))
+	assert.Equal(t, time.Second, blocked.RetryAfter)
+	assert.False(t, blocked.Locked)
+	assert.NoError(t, guard.Check(ctx, "bob", "10.0.0.1"), "other users from the same IP are not blocked yet")
+
+	c.Advance(time.Second)
+	require.NoError(t, guard.Check(ctx, "alice", "10.0.0.1"))
+	require.NoError(t, guard.Failure(ctx, "alice", "10.0.0.1"))
+	assert.Equal(t, 2*time.Second, throttled(t, guard.Check(ctx, "alice", "")).RetryAfter)
+
+	require.NoError(t, guard.Failure(ctx, "alice", "10.0.0.1"))
+	blocked = throttled(t, guard.Check(ctx, "alice", ""))
+	assert.Equal(t, time.Hour, blocked.RetryAfter)
+	assert.True(t, blocked.Locked)
+
+	events, err := store.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditAccountLocked})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "alice", events[0].Subject)
+	assert.Equal(t, "10.0.0.1", events[0].IP)
+	assert.Equal(t, "4", events[0].Details["failures"])
+
+	require.NoError(t, guard.Unlock(ctx, "alice", "admin"))
+	assert.NoError(t, guard.Check(ctx, "alice", ""))
+	events, err = store.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditAccountUnlocked})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "admin", events[0].Actor)
+}
+
+func TestGuardIPScope(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	c := newClock()
+	guard := newGuard(store, c)
+
+	// Spraying one password over many usernames is caught by the IP counter.
+	for _, username := range []string{"u1", "u2", "u3", "u4", "u5", "u6"} {
+		require.NoError(t, guard.Failure(ctx, username, "10.0.0.1"))
+	}
+	blocked := throttled(t, guard.Check(ctx, "u7", "10.0.0.1"))
+	assert.Equal(t, time.Second, blocked.RetryAfter)
+	assert.NoError(t, guard.Check(ctx, "u7", "10.0.0.2"))
+
+	// A successful login resets the username but not the IP.
+	require.NoError(t, guard.Success(ctx, "u1"))
+	throttled(t, guard.Check(ctx, "u1", "10.0.0.1"))
+}
+
+func TestGuardResetAfter(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	c := newClock()
+	guard := newGuard(store, c)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, guard.Failure(ctx, "alice", ""))
+	}
+	c.Advance(25 * time.Hour)
+	require.NoError(t, guard.Failure(ctx, "alice", ""))
+	assert.NoError(t, guard.Check(ctx, "alice", ""), "old failures are forgotten")
+}
@@ -1,48 +1,68 @@
 package middleware
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
 	"github.com/mi4r/avito-shop/internal/storage/storage"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(secretKey []byte, store storage.Storage) func(http.Handler) http.Handler {
+// AuthMiddleware accepts requests with a valid access token whose session
+// has not been revoked. The claims and the principal are stored in the
+// request context.
+func AuthMiddleware(tokens *auth.TokenManager, store storage.Storage) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.Unauthorized)
 				return
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return secretKey, nil
-			})
-
-			if err != nil || !token.Valid {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+			claims, err := tokens.Parse(tokenString)
+			if errors.Is(err, auth.ErrMissingUsername) || errors.Is(err, auth.ErrMissingSession) {
+				apierror.Write(w, r, apierror.InvalidToken.WithMessage("Invalid token claims"))
 				return
 			}
-
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidToken)
 				return
 			}
 
-			username, ok := claims["username"].(string)
-			if !ok {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			revoked, err := store.IsAccessTokenRevoked(r.Context(), claims.ID, claims.SessionID)
+			if err != nil {
+				apierror.Write(w, r, apierror.Internal.WithMessage("Failed to check token"))
+				return
+			}
+			if revoked {
+				apierror.Write(w, r, apierror.TokenRevoked)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), "username", username)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// RequireRole accepts requests whose principal has any of roles. It must
+// run after AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.PrincipalFromContext(r.Context())
+			if principal == nil {
+				apierror.Write(w, r, apierror.Unauthorized)
+				return
+			}
+			if !principal.HasRole(roles...) {
+				apierror.Write(w, r, apierror.Forbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -1,6 +1,7 @@
 package middleware_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -8,12 +9,35 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
+	"github.com/mi4r/avito-shop/internal/auth"
 	"github.com/mi4r/avito-shop/internal/middlewares"
 	"github.com/mi4r/avito-shop/internal/storage/mocks"
 )
 
-var testSecret = []byte("test-secret")
+var testSecret = []byte("middleware-test-secret-0123456789")
+
+var testTokens = auth.NewTokenManager(auth.TokenConfig{
+	Key:      testSecret,
+	TTL:      time.Hour,
+	Issuer:   "avito-shop",
+	Audience: "avito-shop",
+})
+
+// validClaims returns claims that pass every check of testTokens.
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"username": "testuser",
+		"sid":      "session-1",
+		"jti":      "token-1",
+		"iss":      "avito-shop",
+		"aud":      "avito-shop",
+		"iat":      now.Unix(),
+		"exp":      now.Add(time.Hour).Unix(),
+	}
+}
 
 func TestAuthMiddleware(t *testing.T) {
 	t.Run("no authorization header", func(t *testing.T) {
@@ -21,7 +45,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +65,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -61,7 +85,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -76,10 +100,9 @@ func TestAuthMiddleware(t *testing.T) {
 	})
 
 	t.Run("expired token", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"username": "testuser",
-			"exp":      time.Now().Add(-1 * time.Hour).Unix(),
-		})
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-1 * time.Hour).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 		tokenString, _ := token.SignedString(testSecret)
 
 		req := httptest.NewRequest("GET", "/", nil)
@@ -87,7 +110,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -103,7 +126,11 @@ func TestAuthMiddleware(t *testing.T) {
 
 	t.Run("invalid claims type", func(t *testing.T) {
 		token := jwt.New(jwt.SigningMethodHS256)
-		token.Claims = jwt.RegisteredClaims{}
+		token.Claims = jwt.RegisteredClaims{
+			Issuer:    "avito-shop",
+			Audience:  jwt.ClaimStrings{"avito-shop"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
 		tokenString, _ := token.SignedString(testSecret)
 
 		req := httptest.NewRequest("GET", "/", nil)
@@ -111,7 +138,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -126,9 +153,10 @@ func TestAuthMiddleware(t *testing.T) {
 	})
 
 	t.Run("missing username in claims", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"role": "admin",
-		})
+		claims := validClaims()
+		delete(claims, "username")
+		claims["role"] = "admin"
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 		tokenString, _ := token.SignedString(testSecret)
 
 		req := httptest.NewRequest("GET", "/", nil)
@@ -136,7 +164,7 @@ func TestAuthMiddleware(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -150,38 +178,156 @@ func TestAuthMiddleware(t *testing.T) {
 		assert.False(t, handlerCalled)
 	})
 
+	t.Run("missing session in claims", func(t *testing.T) {
+		claims := validClaims()
+		delete(claims, "sid")
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+
+		mw := middleware.AuthMiddleware(testTokens, mocks.NewStorage(t))
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler must not be called")
+		})).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Invalid token claims")
+	})
+
 	t.Run("valid token", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"username": "testuser",
-		})
-		tokenString, _ := token.SignedString(testSecret)
+		tokenString, issued, err := testTokens.Issue("testuser", "session-1", []string{"user", "auditor"})
+		assert.NoError(t, err)
 
 		req := httptest.NewRequest("GET", "/", nil)
 		req.Header.Set("Authorization", "Bearer "+tokenString)
 		rr := httptest.NewRecorder()
 
 		mockStorage := mocks.NewStorage(t)
-		middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+		mockStorage.On("IsAccessTokenRevoked", mock.Anything, issued.ID, "session-1").Return(false, nil)
+		middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 		handlerCalled := false
-		var contextUsername string
+		var principal *auth.Principal
+		var contextClaims *auth.Claims
 		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			handlerCalled = true
-			contextUsername = r.Context().Value("username").(string)
+			principal = auth.PrincipalFromContext(r.Context())
+			contextClaims = auth.ClaimsFromContext(r.Context())
 		})
 
 		middleware(testHandler).ServeHTTP(rr, req)
 
 		assert.True(t, handlerCalled)
-		assert.Equal(t, "testuser", contextUsername)
+		if assert.NotNil(t, principal) {
+			assert.Equal(t, "testuser", principal.Username)
+			assert.Equal(t, "session-1", principal.SessionID)
+			assert.True(t, principal.HasRole("auditor"))
+			assert.False(t, principal.HasRole("admin"))
+		}
+		if assert.NotNil(t, contextClaims) {
+			assert.Equal(t, issued.ID, contextClaims.ID)
+		}
 		assert.Equal(t, http.StatusOK, rr.Code)
 	})
+
+	t.Run("revoked token", func(t *testing.T) {
+		tokenString, issued, err := testTokens.Issue("testuser", "session-1", nil)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("IsAccessTokenRevoked", mock.Anything, issued.ID, "session-1").Return(true, nil)
+		mw := middleware.AuthMiddleware(testTokens, mockStorage)
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler must not be called")
+		})).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "token_revoked")
+	})
+
+	t.Run("revocation check fails", func(t *testing.T) {
+		tokenString, _, err := testTokens.Issue("testuser", "session-1", nil)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+
+		mockStorage := mocks.NewStorage(t)
+		mockStorage.On("IsAccessTokenRevoked", mock.Anything, mock.Anything, mock.Anything).Return(false, errors.New("db down"))
+		mw := middleware.AuthMiddleware(testTokens, mockStorage)
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler must not be called")
+		})).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
 }
 
-func TestAuthMiddlewareWithDifferentSecret(t *testing.T) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": "testuser",
+func TestAuthMiddlewareRejectsClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		modify func(jwt.MapClaims)
+	}{
+		{"missing exp", jwt.SigningMethodHS256, func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"wrong issuer", jwt.SigningMethodHS256, func(c jwt.MapClaims) { c["iss"] = "someone-else" }},
+		{"missing issuer", jwt.SigningMethodHS256, func(c jwt.MapClaims) { delete(c, "iss") }},
+		{"wrong audience", jwt.SigningMethodHS256, func(c jwt.MapClaims) { c["aud"] = "another-service" }},
+		{"issued in the future", jwt.SigningMethodHS256, func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"not yet valid", jwt.SigningMethodHS256, func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Hour).Unix() }},
+		{"other HMAC algorithm", jwt.SigningMethodHS512, func(jwt.MapClaims) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			tokenString, err := jwt.NewWithClaims(tt.method, claims).SignedString(testSecret)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tokenString)
+			rr := httptest.NewRecorder()
+
+			handlerCalled := false
+			mw := middleware.AuthMiddleware(testTokens, mocks.NewStorage(t))
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})).ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+			assert.Contains(t, rr.Body.String(), "invalid_token")
+			assert.False(t, handlerCalled)
+		})
+	}
+
+	t.Run("unsigned token", func(t *testing.T) {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).
+			SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		rr := httptest.NewRecorder()
+
+		mw := middleware.AuthMiddleware(testTokens, mocks.NewStorage(t))
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler must not be called")
+		})).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
+}
+
+func TestAuthMiddlewareWithDifferentSecret(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
 	wrongSecret := []byte("wrong-secret")
 	tokenString, _ := token.SignedString(wrongSecret)
 
@@ -190,7 +336,7 @@ func TestAuthMiddlewareWithDifferentSecret(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	mockStorage := mocks.NewStorage(t)
-	middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+	middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 	handlerCalled := false
 	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -205,9 +351,7 @@ func TestAuthMiddlewareWithDifferentSecret(t *testing.T) {
 }
 
 func TestAuthMiddlewareWithInvalidTokenType(t *testing.T) {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
-		"username": "testuser",
-	})
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
 	tokenString, _ := token.SignedString(testSecret)
 
 	req := httptest.NewRequest("GET", "/", nil)
@@ -215,7 +359,7 @@ func TestAuthMiddlewareWithInvalidTokenType(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	mockStorage := mocks.NewStorage(t)
-	middleware := middleware.AuthMiddleware(testSecret, mockStorage)
+	middleware := middleware.AuthMiddleware(testTokens, mockStorage)
 
 	handlerCalled := false
 	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -228,3 +372,34 @@ func TestAuthMiddlewareWithInvalidTokenType(t *testing.T) {
 	assert.Contains(t, rr.Body.String(), "Invalid token")
 	assert.False(t, handlerCalled)
 }
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name           string
+		claims         *auth.Claims
+		expectedStatus int
+	}{
+		{"no principal", nil, http.StatusUnauthorized},
+		{"missing role", &auth.Claims{Username: "alice", Roles: []string{"user"}}, http.StatusForbidden},
+		{"one of the roles", &auth.Claims{Username: "alice", Roles: []string{"user", "auditor"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithClaims(req.Context(), tt.claims))
+			}
+			rr := httptest.NewRecorder()
+
+			handlerCalled := false
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			middleware.RequireRole("admin", "auditor")(testHandler).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, handlerCalled)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/apierror"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 1 << 20
+	// idempotencyStoreTimeout bounds storing the response once the handler
+	// has run.
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to
+// retry: the first response is stored per user and key and replayed for
+// later requests with the same key instead of running the handler again.
+// It must run after AuthMiddleware.
+func Idempotency(store storage.Storage, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				apierror.Write(w, r, apierror.InvalidRequest.WithMessage("Idempotency key is too long"))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierror.Write(w, r, apierror.RequestTooLarge)
+				return
+			}
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidRequest.WithMessage("Failed to read request"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			username := auth.PrincipalFromContext(r.Context()).Username
+			now := time.Now()
+			record := models.IdempotencyRecord{
+				Username:    username,
+				Key:         key,
+				RequestHash: requestHash(r, body),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(ttl),
+			}
+
+			err = store.CreateIdempotencyRecord(r.Context(), record)
+			if errors.Is(err, storage.ErrIdempotencyKeyExists) {
+				replay(w, r, store, record)
+				return
+			}
+			if err != nil {
+				apierror.Write(w, r, apierror.Internal.WithMessage("Failed to store idempotency key"))
+				return
+			}
+
+			// A client that gave up on the request is about to retry it, so
+			// the outcome is stored even if the request was cancelled.
+			storeContext := func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.WithoutCancel(r.Context()), idempotencyStoreTimeout)
+			}
+
+			// A panicking handler never completes the key; release it so
+			// that a retry is not stuck in progress.
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				ctx, cancel := storeContext()
+				defer cancel()
+				if err := store.DeleteIdempotencyRecord(ctx, username, key); err != nil {
+					log.Printf("failed to release idempotency key: %v", err)
+				}
+			}()
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			completed = true
+
+			ctx, cancel := storeContext()
+			defer cancel()
+
+			// Server errors are not stored so that the client can retry.
+			if rec.status >= http.StatusInternalServerError {
+				if err := store.DeleteIdempotencyRecord(ctx, username, key); err != nil {
+					log.Printf("failed to release idempotency key: %v", err)
+				}
+				return
+			}
+			if err := store.CompleteIdempotencyRecord(ctx, username, key,
+				rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+				log.Printf("failed to store idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, store storage.Storage, request models.IdempotencyRecord) {
+	stored, err := store.GetIdempotencyRecord(r.Context(), request.Username, request.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrIdempotencyKeyNotFound) {
+			apierror.Write(w, r, apierror.IdempotencyKeyInProgress)
+			return
+		}
+		apierror.Write(w, r, apierror.Internal.WithMessage("Failed to load idempotency key"))
+		return
+	}
+
+	if stored.RequestHash != request.RequestHash {
+		apierror.Write(w, r, apierror.IdempotencyKeyReused)
+		return
+	}
+	if stored.StatusCode == 0 {
+		apierror.Write(w, r, apierror.IdempotencyKeyInProgress)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Body)
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, "\n")
+	io.WriteString(h, r.URL.Path)
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,181 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/middlewares"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+// cancellableStorage fails writes on a cancelled context, as a database would.
+type cancellableStorage struct {
+	storage.Storage
+}
+
+func (s cancellableStorage) CompleteIdempotencyRecord(ctx context.Context, username, key string, statusCode int, contentType string, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Storage.CompleteIdempotencyRecord(ctx, username, key, statusCode, contentType, body)
+}
+
+func (s cancellableStorage) DeleteIdempotencyRecord(ctx context.Context, username, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Storage.DeleteIdempotencyRecord(ctx, username, key)
+}
+
+func TestIdempotency(t *testing.T) {
+	newRequest := func(username, key, body string) *http.Request {
+		req := httptest.NewRequest("POST", "/api/sendCoin", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		return req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Username: username}))
+	}
+
+	setup := func(status int) (http.Handler, *int) {
+		calls := 0
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"call":1}`))
+		})
+		return middleware.Idempotency(storage.NewMemoryStorage(), time.Hour)(handler), &calls
+	}
+
+	t.Run("without key every request runs", func(t *testing.T) {
+		handler, calls := setup(http.StatusOK)
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequest("alice", "", `{"amount":1}`))
+			assert.Equal(t, http.StatusOK, rr.Code)
+		}
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("retry replays the stored response", func(t *testing.T) {
+		handler, calls := setup(http.StatusBadRequest)
+
+		first := httptest.NewRecorder()
+		handler.ServeHTTP(first, newRequest("alice", "k1", `{"amount":1}`))
+
+		retry := httptest.NewRecorder()
+		handler.ServeHTTP(retry, newRequest("alice", "k1", `{"amount":1}`))
+
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusBadRequest, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+		assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+		assert.Empty(t, first.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+
+	t.Run("keys are scoped per user", func(t *testing.T) {
+		handler, calls := setup(http.StatusOK)
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("alice", "k1", `{"amount":1}`))
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("bob", "k1", `{"amount":1}`))
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("reuse with a different body is rejected", func(t *testing.T) {
+		handler, calls := setup(http.StatusOK)
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("alice", "k1", `{"amount":1}`))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequest("alice", "k1", `{"amount":2}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("oversized body is rejected", func(t *testing.T) {
+		handler, calls := setup(http.StatusOK)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequest("alice", "k1", strings.Repeat("x", 1<<20+1)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Equal(t, 0, *calls)
+	})
+
+	t.Run("server errors are not stored", func(t *testing.T) {
+		handler, calls := setup(http.StatusInternalServerError)
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequest("alice", "k1", `{"amount":1}`))
+			assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		}
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("outcome is stored after the client gave up", func(t *testing.T) {
+		for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+			req := newRequest("alice", "k1", `{"amount":1}`)
+			ctx, cancel := context.WithCancel(req.Context())
+			calls := 0
+			handler := middleware.Idempotency(cancellableStorage{storage.NewMemoryStorage()}, time.Hour)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls++
+					cancel()
+					w.WriteHeader(status)
+				}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+			retry := httptest.NewRecorder()
+			handler.ServeHTTP(retry, newRequest("alice", "k1", `{"amount":1}`))
+			assert.Equal(t, status, retry.Code, "retry is not stuck in progress")
+			if status == http.StatusOK {
+				assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+				assert.Equal(t, 1, calls)
+			} else {
+				assert.Equal(t, 2, calls)
+			}
+		}
+	})
+
+	t.Run("panicking handler releases the key", func(t *testing.T) {
+		calls := 0
+		handler := middleware.Idempotency(storage.NewMemoryStorage(), time.Hour)(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					panic("boom")
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+		assert.PanicsWithValue(t, "boom", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), newRequest("alice", "k1", `{"amount":1}`))
+		})
+
+		retry := httptest.NewRecorder()
+		handler.ServeHTTP(retry, newRequest("alice", "k1", `{"amount":1}`))
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("concurrent duplicate is rejected while in flight", func(t *testing.T) {
+		store := storage.NewMemoryStorage()
+		var inner http.Handler
+		handler := middleware.Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rr := httptest.NewRecorder()
+			inner.ServeHTTP(rr, newRequest("alice", "k1", `{"amount":1}`))
+			assert.Equal(t, http.StatusConflict, rr.Code)
+			w.WriteHeader(http.StatusOK)
+		}))
+		inner = handler
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequest("alice", "k1", `{"amount":1}`))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id defaults follow the second recommended option of RFC 9106 with
+// less memory: 3 passes over 64 MiB.
+const (
+	DefaultArgon2Time     = 3
+	DefaultArgon2MemoryKB = 64 * 1024
+	DefaultArgon2Threads  = 2
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Argon2id hashes with argon2id. Zero parameters mean the defaults.
+type Argon2id struct {
+	Time     uint32
+	MemoryKB uint32
+	Threads  uint8
+}
+
+type argon2Params struct {
+	time, memory uint32
+	threads      uint8
+}
+
+func (a Argon2id) params() argon2Params {
+	p := argon2Params{a.Time, a.MemoryKB, a.Threads}
+	if p.time == 0 {
+		p.time = DefaultArgon2Time
+	}
+	if p.memory == 0 {
+		p.memory = DefaultArgon2MemoryKB
+	}
+	if p.threads == 0 {
+		p.threads = DefaultArgon2Threads
+	}
+	return p
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := a.params()
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (Argon2id) Verify(hash, password string) error {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Outdated(hash string) bool {
+	p, _, _, err := parseArgon2id(hash)
+	want := a.params()
+	return err != nil || p != want
+}
+
+func parseArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownScheme
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,119 @@
+// Package password hashes and verifies user passwords.
+//
+// Hashes are self-describing: bcrypt hashes start with "$2", argon2id hashes
+// use the PHC string format "$argon2id$v=19$m=...,t=...,p=...$salt$key".
+// A Hasher verifies both, hashes new passwords with its configured scheme
+// and reports hashes that should be upgraded to it.
+package password
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SchemeBcrypt   = "bcrypt"
+	SchemeArgon2id = "argon2id"
+)
+
+var (
+	// ErrMismatch is returned by Verify when the password is wrong.
+	ErrMismatch = errors.New("password does not match")
+	// ErrUnknownScheme is returned for hashes no scheme recognizes.
+	ErrUnknownScheme = errors.New("unknown password hash scheme")
+)
+
+// Scheme is one hashing algorithm with fixed parameters.
+type Scheme interface {
+	Hash(password string) (string, error)
+	// Recognizes reports whether hash was produced by this algorithm,
+	// with any parameters.
+	Recognizes(hash string) bool
+	// Verify returns ErrMismatch if password does not match hash.
+	Verify(hash, password string) error
+	// Outdated reports whether a recognized hash uses other parameters
+	// than the scheme.
+	Outdated(hash string) bool
+}
+
+type Hasher struct {
+	current Scheme
+	schemes []Scheme
+	dummy   func() (string, error)
+}
+
+// NewHasher hashes with current and verifies current, bcrypt and argon2id hashes.
+func NewHasher(current Scheme) *Hasher {
+	h := &Hasher{
+		current: current,
+		schemes: []Scheme{current, Bcrypt{}, Argon2id{}},
+	}
+	h.dummy = sync.OnceValues(func() (string, error) {
+		return h.current.Hash("dummy password")
+	})
+	return h
+}
+
+// Config selects and tunes the scheme for NewHasherFromConfig. Zero
+// parameters mean the scheme's defaults.
+type Config struct {
+	Scheme         string
+	BcryptCost     int
+	Argon2Time     int
+	Argon2MemoryKB int
+	Argon2Threads  int
+}
+
+func NewHasherFromConfig(cfg Config) (*Hasher, error) {
+	switch cfg.Scheme {
+	case SchemeBcrypt:
+		scheme := Bcrypt{Cost: cfg.BcryptCost}
+		if c := scheme.cost(); c < bcrypt.MinCost || c > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return NewHasher(scheme), nil
+	case SchemeArgon2id, "":
+		if cfg.Argon2Time < 0 || cfg.Argon2MemoryKB < 0 || cfg.Argon2Threads < 0 || cfg.Argon2Threads > 255 {
+			return nil, errors.New("argon2id parameters out of range")
+		}
+		scheme := Argon2id{
+			Time:     uint32(cfg.Argon2Time),
+			MemoryKB: uint32(cfg.Argon2MemoryKB),
+			Threads:  uint8(cfg.Argon2Threads),
+		}
+		return NewHasher(scheme), nil
+	}
+	return nil, fmt.Errorf("unknown password hash scheme %q", cfg.Scheme)
+}
+
+// Hash hashes password with the current scheme.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify checks password against hash. If it matches and the hash is not
+// in the current scheme and parameters, rehash is true and the caller
+// should store a new Hash of the password.
+func (h *Hasher) Verify(hash, password string) (rehash bool, err error) {
+	for i, scheme := range h.schemes {
+		if !scheme.Recognizes(hash) {
+			continue
+		}
+		if err := scheme.Verify(hash, password); err != nil {
+			return false, err
+		}
+		return i > 0 || scheme.Outdated(hash), nil
+	}
+	return false, ErrUnknownScheme
+}
+
+// VerifyDummy takes as long as a Verify with the current scheme. It is used
+// for unknown users, so that they cannot be told apart by response time.
+func (h *Hasher) VerifyDummy(password string) {
+	if hash, err := h.dummy(); err == nil {
+		h.current.Verify(hash, password)
+	}
+}
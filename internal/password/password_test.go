@@ -0,0 +1,95 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mi4r/avito-shop/internal/password"
+)
+
+// fastArgon2 keeps the tests quick; production uses the defaults.
+var fastArgon2 = password.Argon2id{Time: 1, MemoryKB: 1024, Threads: 1}
+
+func TestSchemes(t *testing.T) {
+	for _, scheme := range []password.Scheme{password.Bcrypt{Cost: bcrypt.MinCost}, fastArgon2} {
+		hash, err := scheme.Hash("secret")
+		require.NoError(t, err)
+		assert.True(t, scheme.Recognizes(hash))
+		assert.False(t, scheme.Outdated(hash))
+		assert.NoError(t, scheme.Verify(hash, "secret"))
+		assert.ErrorIs(t, scheme.Verify(hash, "wrong"), password.ErrMismatch)
+
+		other, err := scheme.Hash("secret")
+		require.NoError(t, err)
+		assert.NotEqual(t, hash, other, "hashes are salted")
+	}
+}
+
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := fastArgon2.Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	assert.True(t, password.Argon2id{Time: 2, MemoryKB: 1024, Threads: 1}.Outdated(hash))
+	assert.Error(t, fastArgon2.Verify("$argon2id$v=19$m=1024$salt$key", "secret"))
+}
+
+func TestHasherRehash(t *testing.T) {
+	oldHash, err := password.Bcrypt{Cost: bcrypt.MinCost}.Hash("secret")
+	require.NoError(t, err)
+
+	hasher := password.NewHasher(fastArgon2)
+
+	rehash, err := hasher.Verify(oldHash, "secret")
+	require.NoError(t, err)
+	assert.True(t, rehash, "bcrypt hashes are upgraded to the current scheme")
+
+	_, err = hasher.Verify(oldHash, "wrong")
+	assert.ErrorIs(t, err, password.ErrMismatch)
+
+	newHash, err := hasher.Hash("secret")
+	require.NoError(t, err)
+	rehash, err = hasher.Verify(newHash, "secret")
+	require.NoError(t, err)
+	assert.False(t, rehash)
+
+	weaker, err := password.Argon2id{Time: 1, MemoryKB: 512, Threads: 1}.Hash("secret")
+	require.NoError(t, err)
+	rehash, err = hasher.Verify(weaker, "secret")
+	require.NoError(t, err)
+	assert.True(t, rehash, "hashes with old parameters are upgraded too")
+
+	_, err = hasher.Verify("plaintext", "plaintext")
+	assert.ErrorIs(t, err, password.ErrUnknownScheme)
+}
+
+func TestNewHasherFromConfig(t *testing.T) {
+	_, err := password.NewHasherFromConfig(password.Config{Scheme: "md5"})
+	assert.Error(t, err)
+	_, err = password.NewHasherFromConfig(password.Config{Scheme: password.SchemeBcrypt, BcryptCost: 100})
+	assert.Error(t, err)
+
+	hasher, err := password.NewHasherFromConfig(password.Config{Scheme: password.SchemeBcrypt, BcryptCost: bcrypt.MinCost})
+	require.NoError(t, err)
+	hash, err := hasher.Hash("secret")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+}
+
+func TestNewResetToken(t *testing.T) {
+	now := time.Now()
+	token, record, err := password.NewResetToken(42, now, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, 42, record.UserID)
+	assert.Equal(t, password.HashResetToken(token), record.TokenHash)
+	assert.NotEqual(t, token, record.TokenHash)
+	assert.Equal(t, now.Add(time.Hour), record.ExpiresAt)
+}
@@ -0,0 +1,38 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// DefaultResetTokenTTL is how long a password reset token stays valid.
+const DefaultResetTokenTTL = time.Hour
+
+// NewResetToken generates a one-time reset token for the user and the
+// record to store for it.
+func NewResetToken(userID int, now time.Time, ttl time.Duration) (string, models.PasswordResetToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", models.PasswordResetToken{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	record := models.PasswordResetToken{
+		UserID:    userID,
+		TokenHash: HashResetToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	return token, record, nil
+}
+
+// HashResetToken returns the form in which reset tokens are stored.
+func HashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,123 @@
+// Package reconcile checks the balances cached in users.coins against the
+// ledger. Drift means coins moved without a ledger entry, e.g. a manual
+// UPDATE; a run reports it and can post adjustments that record the
+// missing movements, so that the ledger explains every balance again.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+type Reconciler struct {
+	store storage.Storage
+	now   func() time.Time
+
+	// run serializes runs; mu guards last.
+	run  sync.Mutex
+	mu   sync.Mutex
+	last *models.ReconciliationReport
+}
+
+func New(store storage.Storage) *Reconciler {
+	return &Reconciler{store: store, now: time.Now}
+}
+
+// WithClock returns a reconciler that reads the time from now. It is meant for tests.
+func (r *Reconciler) WithClock(now func() time.Time) *Reconciler {
+	return &Reconciler{store: r.store, now: now}
+}
+
+// Run looks for mismatched balances and, if correct is set, posts an
+// adjustment for each on behalf of actor. The report is kept for Last even
+// if the run fails; a failed correction does not stop the others.
+func (r *Reconciler) Run(ctx context.Context, correct bool, actor string) (models.ReconciliationReport, error) {
+	r.run.Lock()
+	defer r.run.Unlock()
+
+	report := models.ReconciliationReport{StartedAt: r.now(), Correct: correct}
+	err := r.reconcile(ctx, &report, actor)
+	report.FinishedAt = r.now()
+	if report.Mismatches == nil {
+		report.Mismatches = []models.BalanceMismatch{}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.last = &report
+	r.mu.Unlock()
+	return report, err
+}
+
+func (r *Reconciler) reconcile(ctx context.Context, report *models.ReconciliationReport, actor string) error {
+	mismatches, err := r.store.ListBalanceMismatches(ctx)
+	if err != nil {
+		return fmt.Errorf("list mismatches: %w", err)
+	}
+	report.Mismatches = mismatches
+	if !report.Correct {
+		return nil
+	}
+
+	var errs []error
+	for i, m := range report.Mismatches {
+		now := r.now()
+		var corrected *models.BalanceMismatch
+		// The correction and its audit event commit together.
+		err := r.store.InTx(ctx, nil, func(tx storage.Storage) (err error) {
+			corrected, err = tx.CorrectBalance(ctx, m.UserID, now)
+			if err != nil {
+				return fmt.Errorf("correct %s: %w", m.Username, err)
+			}
+			if corrected == nil {
+				// Fixed in the meantime, e.g. by a concurrent run.
+				return nil
+			}
+
+			err = tx.CreateAuditEvent(ctx, models.AuditEvent{
+				Type:    models.AuditBalanceCorrected,
+				Actor:   actor,
+				Subject: corrected.Username,
+				Details: map[string]string{
+					"coins":  strconv.Itoa(corrected.Coins),
+					"ledger": strconv.Itoa(corrected.Ledger),
+				},
+				CreatedAt: now,
+			})
+			if err != nil {
+				return fmt.Errorf("audit %s: %w", m.Username, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if corrected != nil {
+			report.Mismatches[i] = *corrected
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Last returns the report of the latest run, or nil before the first one.
+func (r *Reconciler) Last() *models.ReconciliationReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last == nil {
+		return nil
+	}
+	report := *r.last
+	report.Mismatches = append([]models.BalanceMismatch{}, r.last.Mismatches...)
+	return &report
+}
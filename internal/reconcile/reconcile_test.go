@@ -0,0 +1,121 @@
+package reconcile_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/reconcile"
+	"github.com/mi4r/avito-shop/internal/storage/mocks"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+)
+
+var now = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func clock() time.Time { return now }
+
+// runUnits makes the units of work of store run on store itself.
+func runUnits(store *mocks.Storage) {
+	store.On("InTx", mock.Anything, (*sql.TxOptions)(nil), mock.Anything).
+		Return(func(_ context.Context, _ *sql.TxOptions, fn func(storage.Storage) error) error {
+			return fn(store)
+		})
+}
+
+func TestRunReportsMismatches(t *testing.T) {
+	store := mocks.NewStorage(t)
+	store.On("ListBalanceMismatches", mock.Anything).Return([]models.BalanceMismatch{
+		{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000},
+	}, nil)
+
+	reconciler := reconcile.New(store).WithClock(clock)
+	assert.Nil(t, reconciler.Last())
+
+	report, err := reconciler.Run(context.Background(), false, "test")
+	require.NoError(t, err)
+	assert.Equal(t, models.ReconciliationReport{
+		StartedAt:  now,
+		FinishedAt: now,
+		Mismatches: []models.BalanceMismatch{{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000}},
+	}, report)
+	assert.Equal(t, 200, report.Mismatches[0].Difference())
+	assert.Equal(t, &report, reconciler.Last())
+}
+
+func TestRunCorrectsMismatches(t *testing.T) {
+	store := mocks.NewStorage(t)
+	store.On("ListBalanceMismatches", mock.Anything).Return([]models.BalanceMismatch{
+		{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000},
+		{UserID: 8, Username: "bob", Coins: 900, Ledger: 1000},
+		{UserID: 9, Username: "carol", Coins: 0, Ledger: 50},
+	}, nil)
+	runUnits(store)
+	// Alice's balance changed again before she was locked.
+	store.On("CorrectBalance", mock.Anything, 7, now).
+		Return(&models.BalanceMismatch{UserID: 7, Username: "alice", Coins: 1150, Ledger: 950, Corrected: true}, nil)
+	// Bob's was fixed in the meantime.
+	store.On("CorrectBalance", mock.Anything, 8, now).Return((*models.BalanceMismatch)(nil), nil)
+	store.On("CorrectBalance", mock.Anything, 9, now).Return((*models.BalanceMismatch)(nil), errors.New("connection reset"))
+	store.On("CreateAuditEvent", mock.Anything, models.AuditEvent{
+		Type:      models.AuditBalanceCorrected,
+		Actor:     "test",
+		Subject:   "alice",
+		Details:   map[string]string{"coins": "1150", "ledger": "950"},
+		CreatedAt: now,
+	}).Return(nil)
+
+	reconciler := reconcile.New(store).WithClock(clock)
+	report, err := reconciler.Run(context.Background(), true, "test")
+	require.ErrorContains(t, err, "correct carol: connection reset")
+	assert.True(t, report.Correct)
+	assert.Equal(t, []models.BalanceMismatch{
+		{UserID: 7, Username: "alice", Coins: 1150, Ledger: 950, Corrected: true},
+		{UserID: 8, Username: "bob", Coins: 900, Ledger: 1000},
+		{UserID: 9, Username: "carol", Coins: 0, Ledger: 50},
+	}, report.Mismatches)
+
+	last := reconciler.Last()
+	require.NotNil(t, last)
+	assert.Equal(t, "correct carol: connection reset", last.Error)
+}
+
+func TestRunReportsFailedAudit(t *testing.T) {
+	store := mocks.NewStorage(t)
+	store.On("ListBalanceMismatches", mock.Anything).Return([]models.BalanceMismatch{
+		{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000},
+	}, nil)
+	runUnits(store)
+	store.On("CorrectBalance", mock.Anything, 7, now).
+		Return(&models.BalanceMismatch{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000, Corrected: true}, nil)
+	store.On("CreateAuditEvent", mock.Anything, mock.Anything).Return(errors.New("connection reset"))
+
+	// The correction is rolled back with its audit event, so the mismatch
+	// is reported as not corrected.
+	reconciler := reconcile.New(store).WithClock(clock)
+	report, err := reconciler.Run(context.Background(), true, "test")
+	require.ErrorContains(t, err, "audit alice: connection reset")
+	assert.Equal(t, []models.BalanceMismatch{
+		{UserID: 7, Username: "alice", Coins: 1200, Ledger: 1000},
+	}, report.Mismatches)
+}
+
+func TestRunFailure(t *testing.T) {
+	store := mocks.NewStorage(t)
+	store.On("ListBalanceMismatches", mock.Anything).Return(nil, errors.New("connection refused"))
+
+	reconciler := reconcile.New(store).WithClock(clock)
+	_, err := reconciler.Run(context.Background(), true, "test")
+	require.Error(t, err)
+
+	last := reconciler.Last()
+	require.NotNil(t, last)
+	assert.Equal(t, "list mismatches: connection refused", last.Error)
+	assert.Empty(t, last.Mismatches)
+}
@@ -0,0 +1,76 @@
+// Package registration decides who may create an account.
+package registration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+type Mode string
+
+const (
+	// ModeOpen lets anyone register.
+	ModeOpen Mode = "open"
+	// ModeInvite requires a valid invite code.
+	ModeInvite Mode = "invite"
+	// ModeAllowlist admits listed usernames and emails in listed domains.
+	ModeAllowlist Mode = "allowlist"
+)
+
+// ErrNotAllowed is returned for requests the policy rejects.
+var ErrNotAllowed = errors.New("registration is not allowed")
+
+type Policy struct {
+	mode      Mode
+	usernames map[string]bool
+	domains   map[string]bool
+}
+
+// NewPolicy builds a policy. Allowlist entries starting with "@" are email
+// domains, the others are usernames; they are only used in ModeAllowlist.
+func NewPolicy(mode Mode, allowlist []string) (*Policy, error) {
+	p := &Policy{mode: mode, usernames: make(map[string]bool), domains: make(map[string]bool)}
+	switch mode {
+	case ModeOpen, ModeInvite:
+	case ModeAllowlist:
+		for _, entry := range allowlist {
+			if domain, ok := strings.CutPrefix(entry, "@"); ok {
+				p.domains[strings.ToLower(domain)] = true
+			} else {
+				p.usernames[entry] = true
+			}
+		}
+		if len(p.usernames) == 0 && len(p.domains) == 0 {
+			return nil, errors.New("allowlist registration mode needs a non-empty allowlist")
+		}
+	default:
+		return nil, fmt.Errorf("unknown registration mode %q", mode)
+	}
+	return p, nil
+}
+
+func (p *Policy) Mode() Mode { return p.mode }
+
+// Check reports whether req may register. In ModeInvite it only checks that
+// a code is present; the code itself is redeemed by the storage.
+func (p *Policy) Check(req models.AuthRequest) error {
+	switch p.mode {
+	case ModeInvite:
+		var v validation.Validator
+		v.Required("inviteCode", req.InviteCode)
+		return v.Err()
+	case ModeAllowlist:
+		if p.usernames[req.Username] {
+			return nil
+		}
+		if _, domain, ok := strings.Cut(req.Email, "@"); ok && p.domains[strings.ToLower(domain)] {
+			return nil
+		}
+		return ErrNotAllowed
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package registration_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/registration"
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+func TestNewPolicy(t *testing.T) {
+	_, err := registration.NewPolicy("closed", nil)
+	assert.Error(t, err)
+
+	_, err = registration.NewPolicy(registration.ModeAllowlist, nil)
+	assert.Error(t, err, "an empty allowlist would admit nobody")
+
+	p, err := registration.NewPolicy(registration.ModeOpen, nil)
+	require.NoError(t, err)
+	assert.Equal(t, registration.ModeOpen, p.Mode())
+}
+
+func TestPolicyCheck(t *testing.T) {
+	open, _ := registration.NewPolicy(registration.ModeOpen, nil)
+	invite, _ := registration.NewPolicy(registration.ModeInvite, nil)
+	allowlist, err := registration.NewPolicy(registration.ModeAllowlist, []string{"alice", "@Example.com"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		policy  *registration.Policy
+		request models.AuthRequest
+		err     error
+	}{
+		{"open", open, models.AuthRequest{Username: "anyone"}, nil},
+		{"invite with code", invite, models.AuthRequest{Username: "anyone", InviteCode: "code"}, nil},
+		{"invite without code", invite, models.AuthRequest{Username: "anyone"}, validation.ErrInvalid},
+		{"allowlisted username", allowlist, models.AuthRequest{Username: "alice"}, nil},
+		{"allowlisted domain", allowlist, models.AuthRequest{Username: "bob", Email: "bob@example.COM"}, nil},
+		{"other domain", allowlist, models.AuthRequest{Username: "bob", Email: "bob@example.org"}, registration.ErrNotAllowed},
+		{"subdomain", allowlist, models.AuthRequest{Username: "bob", Email: "bob@mail.example.com"}, registration.ErrNotAllowed},
+		{"no email", allowlist, models.AuthRequest{Username: "bob"}, registration.ErrNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.request)
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -1,29 +1,164 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/mi4r/avito-shop/internal/auth"
+	"github.com/mi4r/avito-shop/internal/config"
 	"github.com/mi4r/avito-shop/internal/handlers"
+	"github.com/mi4r/avito-shop/internal/lockout"
 	"github.com/mi4r/avito-shop/internal/middlewares"
+	"github.com/mi4r/avito-shop/internal/password"
+	"github.com/mi4r/avito-shop/internal/reconcile"
+	"github.com/mi4r/avito-shop/internal/registration"
+	"github.com/mi4r/avito-shop/internal/storage/models"
 	"github.com/mi4r/avito-shop/internal/storage/storage"
 )
 
-func NewServer(store storage.Storage) *http.Server {
+// IdempotencyKeyTTL is how long responses to requests with an
+// Idempotency-Key header are kept for replay.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// NewServer builds the API. The reconciler is only read from, to expose
+// the result of its latest run; running it is up to the caller.
+func NewServer(store storage.Storage, cfg config.Config, reconciler *reconcile.Reconciler) (*http.Server, error) {
+	tokens, err := newTokenManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := registration.NewPolicy(registration.Mode(cfg.RegistrationMode), cfg.RegistrationAllowlist)
+	if err != nil {
+		return nil, err
+	}
+
+	guard := lockout.NewGuard(store, LoginPolicy(cfg))
+
+	hasher, err := password.NewHasherFromConfig(password.Config{
+		Scheme:         cfg.PasswordHashScheme,
+		BcryptCost:     cfg.PasswordBcryptCost,
+		Argon2Time:     cfg.PasswordArgon2Time,
+		Argon2MemoryKB: cfg.PasswordArgon2MemoryKB,
+		Argon2Threads:  cfg.PasswordArgon2Threads,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	r := chi.NewRouter()
+	r.Use(chimw.RequestID)
+	if cfg.TrustProxyHeaders {
+		r.Use(chimw.RealIP)
+	}
 
-	secretKey := []byte("secret-key")
-	authMiddleware := middleware.AuthMiddleware(secretKey, store)
+	authMiddleware := middleware.AuthMiddleware(tokens, store)
+	idempotency := middleware.Idempotency(store, IdempotencyKeyTTL)
 
-	r.Post("/api/auth", handlers.AuthHandler(store, secretKey))
+	r.Get("/.well-known/jwks.json", handlers.JWKSHandler(tokens))
+	r.Post("/api/register", handlers.RegisterHandler(store, tokens, policy, hasher))
+	r.Post("/api/auth", handlers.AuthHandler(store, tokens, guard, hasher))
+	r.Post("/api/auth/2fa", handlers.TwoFactorLoginHandler(store, tokens, guard))
+	r.Post("/api/auth/refresh", handlers.RefreshHandler(store, tokens))
+	r.Post("/api/password/reset", handlers.ResetPasswordHandler(store, tokens, guard, hasher))
+	r.Get("/api/items", handlers.ItemsHandler(store, false))
 
 	r.With(authMiddleware).Group(func(r chi.Router) {
+		r.Post("/api/auth/logout", handlers.LogoutHandler(store))
+		r.Post("/api/auth/logout-all", handlers.LogoutAllHandler(store))
+		r.Post("/api/password", handlers.ChangePasswordHandler(store, tokens, guard, hasher))
+		r.Post("/api/2fa/enroll", handlers.EnrollTwoFactorHandler(store, cfg.TwoFactorIssuer))
+		r.Post("/api/2fa/confirm", handlers.ConfirmTwoFactorHandler(store))
+		r.Post("/api/2fa/disable", handlers.DisableTwoFactorHandler(store, guard))
 		r.Get("/api/info", handlers.InfoHandler(store))
-		r.Post("/api/sendCoin", handlers.SendCoinHandler(store))
-		r.Get("/api/buy/{item}", handlers.BuyItemHandler(store))
+		r.Get("/api/transactions", handlers.TransactionsHandler(store))
+		r.With(idempotency).Post("/api/sendCoin", handlers.SendCoinHandler(store))
+		r.Get("/api/orders", handlers.OrdersHandler(store))
+		r.With(idempotency).Post("/api/orders", handlers.CreateOrderHandler(store))
+		r.Get("/api/purchases", handlers.PurchasesHandler(store))
+		r.With(idempotency).Get("/api/buy/{item}", handlers.BuyItemHandler(store))
+
+		r.With(middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)).
+			Get("/api/admin/audit", handlers.AuditEventsHandler(store))
+		r.With(middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)).
+			Get("/api/admin/ledger", handlers.LedgerHandler(store))
+		r.With(middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)).
+			Get("/api/admin/reconciliation", handlers.ReconciliationHandler(reconciler))
+		r.With(middleware.RequireRole(models.RoleAdmin)).Route("/api/admin/users/{username}", func(r chi.Router) {
+			r.Put("/roles/{role}", handlers.GrantRoleHandler(store))
+			r.Delete("/roles/{role}", handlers.RevokeRoleHandler(store))
+			r.Post("/unlock", handlers.UnlockUserHandler(store, guard))
+			r.Post("/password-reset", handlers.IssuePasswordResetHandler(store))
+			r.Post("/adjustments", handlers.AdjustCoinsHandler(store))
+		})
+		r.With(middleware.RequireRole(models.RoleAdmin)).Route("/api/admin/items", func(r chi.Router) {
+			r.Get("/", handlers.ItemsHandler(store, true))
+			r.Post("/", handlers.CreateItemHandler(store))
+			r.Patch("/{id}", handlers.UpdateItemHandler(store))
+			r.Post("/{id}/archive", handlers.ArchiveItemHandler(store))
+			r.Post("/{id}/restock", handlers.RestockItemHandler(store))
+		})
+		r.With(middleware.RequireRole(models.RoleAdmin)).Route("/api/admin/orders", func(r chi.Router) {
+			r.Get("/", handlers.AdminOrdersHandler(store))
+			r.Post("/{id}/status", handlers.SetOrderStatusHandler(store))
+		})
 	})
 	return &http.Server{
 		Addr:    ":8080",
 		Handler: r,
+	}, nil
+}
+
+// LoginPolicy is lockout.DefaultPolicy with the account lockout limits from cfg.
+func LoginPolicy(cfg config.Config) lockout.Policy {
+	policy := lockout.DefaultPolicy()
+	if cfg.LoginMaxFailures > 0 {
+		policy.Username.LockAfter = cfg.LoginMaxFailures
+	}
+	if cfg.LoginLockoutDuration > 0 {
+		policy.Username.LockDuration = cfg.LoginLockoutDuration
+	}
+	return policy
+}
+
+// newTokenManager loads the JWT keys configured in cfg. The HMAC secret is
+// required only when no signing key file is configured; if both are set,
+// HS256 tokens issued before the switch stay valid until they expire.
+func newTokenManager(cfg config.Config) (*auth.TokenManager, error) {
+	tc := auth.TokenConfig{
+		TTL:        cfg.JWTTTL,
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		RefreshTTL: cfg.JWTRefreshTTL,
+	}
+
+	if cfg.JWTSigningKeyFile != "" {
+		key, err := auth.LoadKeyFile(cfg.JWTSigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load JWT signing key: %w", err)
+		}
+		if key.Private == nil {
+			return nil, fmt.Errorf("JWT signing key %s is not a private key", cfg.JWTSigningKeyFile)
+		}
+		tc.SigningKey = &key
+	}
+	for _, path := range cfg.JWTVerificationKeyFiles {
+		key, err := auth.LoadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("load JWT verification key: %w", err)
+		}
+		tc.VerificationKeys = append(tc.VerificationKeys, key)
+	}
+
+	if tc.SigningKey == nil || cfg.HasJWTSecret() {
+		secret, err := cfg.GetJWTKey()
+		if err != nil {
+			return nil, err
+		}
+		tc.Key = secret
 	}
+	return auth.NewTokenManager(tc), nil
 }
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP INDEX IF EXISTS coin_transactions_receiver_created_idx;
+DROP INDEX IF EXISTS coin_transactions_sender_created_idx;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS coin_transactions_sender_created_idx
+    ON coin_transactions (sender_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS coin_transactions_receiver_created_idx
+    ON coin_transactions (receiver_id, created_at DESC, id DESC);
+
+COMMIT;
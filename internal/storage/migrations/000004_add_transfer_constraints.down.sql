@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE users DROP CONSTRAINT users_coins_non_negative;
+
+ALTER TABLE coin_transactions
+    DROP CONSTRAINT coin_transactions_not_self,
+    DROP CONSTRAINT coin_transactions_amount_positive;
+
+COMMIT;
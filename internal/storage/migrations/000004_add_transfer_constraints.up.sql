@@ -0,0 +1,11 @@
+BEGIN;
+
+-- NOT VALID: rows written before these rules existed are left as they are.
+ALTER TABLE coin_transactions
+    ADD CONSTRAINT coin_transactions_amount_positive CHECK (amount > 0) NOT VALID,
+    ADD CONSTRAINT coin_transactions_not_self CHECK (sender_id <> receiver_id) NOT VALID;
+
+ALTER TABLE users
+    ADD CONSTRAINT users_coins_non_negative CHECK (coins >= 0) NOT VALID;
+
+COMMIT;
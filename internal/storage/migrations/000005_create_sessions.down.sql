@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE revoked_access_tokens;
+DROP TABLE refresh_tokens;
+DROP TABLE sessions;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE invite_codes;
+DROP INDEX users_email_key;
+ALTER TABLE users DROP COLUMN email;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE audit_events;
+DROP TABLE login_throttles;
+
+COMMIT;
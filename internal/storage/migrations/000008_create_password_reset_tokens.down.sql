@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE password_reset_tokens;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE recovery_codes;
+DROP TABLE user_totp;
+
+COMMIT;
@@ -0,0 +1,19 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(32) PRIMARY KEY
+);
+
+INSERT INTO roles (name) VALUES ('user'), ('admin'), ('auditor')
+ON CONFLICT (name) DO NOTHING;
+
+-- Every user has the user role without a row here.
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(32) NOT NULL REFERENCES roles(name),
+    granted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, role),
+    CHECK (role <> 'user')
+);
+
+COMMIT;
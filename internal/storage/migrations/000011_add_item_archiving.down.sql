@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE merch_items DROP CONSTRAINT IF EXISTS merch_items_price_positive;
+ALTER TABLE merch_items DROP COLUMN IF EXISTS archived_at;
+
+COMMIT;
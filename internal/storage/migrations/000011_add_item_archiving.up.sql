@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE merch_items ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+ALTER TABLE merch_items ADD CONSTRAINT merch_items_price_positive CHECK (price > 0);
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE merch_items DROP CONSTRAINT IF EXISTS merch_items_low_stock_threshold_non_negative;
+ALTER TABLE merch_items DROP CONSTRAINT IF EXISTS merch_items_stock_non_negative;
+ALTER TABLE merch_items DROP COLUMN IF EXISTS low_stock_threshold;
+ALTER TABLE merch_items DROP COLUMN IF EXISTS stock;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- NULL stock means unlimited supply.
+ALTER TABLE merch_items ADD COLUMN IF NOT EXISTS stock INTEGER;
+ALTER TABLE merch_items ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER;
+
+ALTER TABLE merch_items ADD CONSTRAINT merch_items_stock_non_negative CHECK (stock >= 0);
+ALTER TABLE merch_items ADD CONSTRAINT merch_items_low_stock_threshold_non_negative CHECK (low_stock_threshold >= 0);
+
+COMMIT;
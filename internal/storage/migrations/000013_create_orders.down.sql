@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_orders_status;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_known;
+ALTER TABLE orders DROP COLUMN IF EXISTS cancelled_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS delivered_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS ready_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS status;
+
+COMMIT;
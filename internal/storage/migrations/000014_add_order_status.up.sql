@@ -0,0 +1,13 @@
+BEGIN;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status VARCHAR(32) NOT NULL DEFAULT 'placed';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS ready_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+
+ALTER TABLE orders ADD CONSTRAINT orders_status_known
+    CHECK (status IN ('placed', 'ready_for_pickup', 'delivered', 'cancelled'));
+
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status);
+
+COMMIT;
@@ -0,0 +1,59 @@
+BEGIN;
+
+-- The ledger is append-only. Each transaction is a balanced set of entries:
+-- its debits and credits add up to the same amount. A user's balance is the
+-- sum of credits minus debits on the account 'user:<id>'; users.coins caches it.
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL
+        CHECK (kind IN ('opening', 'grant', 'transfer', 'purchase', 'refund', 'adjustment')),
+    reference VARCHAR(64) NOT NULL DEFAULT '',
+    memo VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES ledger_transactions(id),
+    account VARCHAR(64) NOT NULL,
+    debit INTEGER NOT NULL DEFAULT 0 CHECK (debit >= 0),
+    credit INTEGER NOT NULL DEFAULT 0 CHECK (credit >= 0),
+    CHECK ((debit = 0) <> (credit = 0))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id ON ledger_entries(transaction_id);
+
+-- Checked at commit, once all entries of a transaction are written.
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(debit) - SUM(credit) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT OR UPDATE ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+-- Existing balances open the ledger, issued in one transaction.
+WITH opening AS (
+    INSERT INTO ledger_transactions (kind, memo, created_at)
+    SELECT 'opening', 'balances before the ledger', NOW() AT TIME ZONE 'UTC'
+    WHERE EXISTS (SELECT 1 FROM users WHERE coins > 0)
+    RETURNING id
+)
+INSERT INTO ledger_entries (transaction_id, account, debit, credit)
+SELECT opening.id, 'user:' || users.id, 0, users.coins
+FROM opening, users
+WHERE users.coins > 0
+UNION ALL
+SELECT opening.id, 'system:issuance', SUM(users.coins), 0
+FROM opening, users
+WHERE users.coins > 0
+GROUP BY opening.id;
+
+COMMIT;
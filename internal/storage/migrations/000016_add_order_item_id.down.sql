@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_orders_user_created;
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_id_key;
+ALTER TABLE order_items DROP COLUMN IF EXISTS id;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- Each order line is a purchase; the id orders the purchase history
+-- and serves as its page cursor together with the order time.
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+ALTER TABLE order_items ADD CONSTRAINT order_items_id_key UNIQUE (id);
+
+CREATE INDEX IF NOT EXISTS idx_orders_user_created ON orders(user_id, created_at DESC);
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN;
+
+-- The running balance of each user account, kept with every posting so
+-- that checking users.coins against the ledger does not sum the account's
+-- whole history. The user row lock taken for the posting guards it.
+CREATE TABLE IF NOT EXISTS ledger_balances (
+    account VARCHAR(64) PRIMARY KEY,
+    balance INTEGER NOT NULL
+);
+
+INSERT INTO ledger_balances (account, balance)
+SELECT account, SUM(credit - debit)
+FROM ledger_entries
+WHERE account LIKE 'user:%'
+GROUP BY account
+ON CONFLICT (account) DO NOTHING;
+
+COMMIT;
@@ -4,8 +4,11 @@ package mocks
 
 import (
 	context "context"
+	sql "database/sql"
+	time "time"
 
 	models "github.com/mi4r/avito-shop/internal/storage/models"
+	storage "github.com/mi4r/avito-shop/internal/storage/storage"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -14,17 +17,1016 @@ type Storage struct {
 	mock.Mock
 }
 
+// AdjustCoins provides a mock function with given fields: ctx, userID, amount, reason, now
+func (_m *Storage) AdjustCoins(ctx context.Context, userID int, amount int, reason string, now time.Time) (int, error) {
+	ret := _m.Called(ctx, userID, amount, reason, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AdjustCoins")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, string, time.Time) (int, error)); ok {
+		return rf(ctx, userID, amount, reason, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, string, time.Time) int); ok {
+		r0 = rf(ctx, userID, amount, reason, now)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, int, string, time.Time) error); ok {
+		r1 = rf(ctx, userID, amount, reason, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ArchiveItem provides a mock function with given fields: ctx, id, now
+func (_m *Storage) ArchiveItem(ctx context.Context, id int, now time.Time) (*models.Item, error) {
+	ret := _m.Called(ctx, id, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ArchiveItem")
+	}
+
+	var r0 *models.Item
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) (*models.Item, error)); ok {
+		return rf(ctx, id, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) *models.Item); ok {
+		r0 = rf(ctx, id, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Item)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, id, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BlockLogin provides a mock function with given fields: ctx, scope, key, until, locked
+func (_m *Storage) BlockLogin(ctx context.Context, scope string, key string, until time.Time, locked bool) error {
+	ret := _m.Called(ctx, scope, key, until, locked)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockLogin")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, bool) error); ok {
+		r0 = rf(ctx, scope, key, until, locked)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // BuyItem provides a mock function with given fields: ctx, username, itemName
 func (_m *Storage) BuyItem(ctx context.Context, username string, itemName string) error {
 	ret := _m.Called(ctx, username, itemName)
 
 	if len(ret) == 0 {
-		panic("no return value specified for BuyItem")
+		panic("no return value specified for BuyItem")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, username, itemName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ChangePassword provides a mock function with given fields: ctx, userID, passwordHash, now
+func (_m *Storage) ChangePassword(ctx context.Context, userID int, passwordHash string, now time.Time) error {
+	ret := _m.Called(ctx, userID, passwordHash, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ChangePassword")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, passwordHash, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CompleteIdempotencyRecord provides a mock function with given fields: ctx, username, key, statusCode, contentType, body
+func (_m *Storage) CompleteIdempotencyRecord(ctx context.Context, username string, key string, statusCode int, contentType string, body []byte) error {
+	ret := _m.Called(ctx, username, key, statusCode, contentType, body)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CompleteIdempotencyRecord")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int, string, []byte) error); ok {
+		r0 = rf(ctx, username, key, statusCode, contentType, body)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ConfirmTOTP provides a mock function with given fields: ctx, userID, step, recoveryCodeHashes, now
+func (_m *Storage) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string, now time.Time) error {
+	ret := _m.Called(ctx, userID, step, recoveryCodeHashes, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ConfirmTOTP")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int64, []string, time.Time) error); ok {
+		r0 = rf(ctx, userID, step, recoveryCodeHashes, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CorrectBalance provides a mock function with given fields: ctx, userID, now
+func (_m *Storage) CorrectBalance(ctx context.Context, userID int, now time.Time) (*models.BalanceMismatch, error) {
+	ret := _m.Called(ctx, userID, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CorrectBalance")
+	}
+
+	var r0 *models.BalanceMismatch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) (*models.BalanceMismatch, error)); ok {
+		return rf(ctx, userID, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) *models.BalanceMismatch); ok {
+		r0 = rf(ctx, userID, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.BalanceMismatch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, userID, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateAuditEvent provides a mock function with given fields: ctx, event
+func (_m *Storage) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	ret := _m.Called(ctx, event)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateAuditEvent")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AuditEvent) error); ok {
+		r0 = rf(ctx, event)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateIdempotencyRecord provides a mock function with given fields: ctx, record
+func (_m *Storage) CreateIdempotencyRecord(ctx context.Context, record models.IdempotencyRecord) error {
+	ret := _m.Called(ctx, record)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateIdempotencyRecord")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.IdempotencyRecord) error); ok {
+		r0 = rf(ctx, record)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateInviteCode provides a mock function with given fields: ctx, invite
+func (_m *Storage) CreateInviteCode(ctx context.Context, invite models.InviteCode) error {
+	ret := _m.Called(ctx, invite)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateInviteCode")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.InviteCode) error); ok {
+		r0 = rf(ctx, invite)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateItem provides a mock function with given fields: ctx, item
+func (_m *Storage) CreateItem(ctx context.Context, item models.CreateItemRequest) (*models.Item, error) {
+	ret := _m.Called(ctx, item)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateItem")
+	}
+
+	var r0 *models.Item
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.CreateItemRequest) (*models.Item, error)); ok {
+		return rf(ctx, item)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.CreateItemRequest) *models.Item); ok {
+		r0 = rf(ctx, item)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Item)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.CreateItemRequest) error); ok {
+		r1 = rf(ctx, item)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateOrder provides a mock function with given fields: ctx, username, lines, now
+func (_m *Storage) CreateOrder(ctx context.Context, username string, lines []models.OrderLine, now time.Time) (*models.Order, error) {
+	ret := _m.Called(ctx, username, lines, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateOrder")
+	}
+
+	var r0 *models.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []models.OrderLine, time.Time) (*models.Order, error)); ok {
+		return rf(ctx, username, lines, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []models.OrderLine, time.Time) *models.Order); ok {
+		r0 = rf(ctx, username, lines, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Order)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []models.OrderLine, time.Time) error); ok {
+		r1 = rf(ctx, username, lines, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreatePasswordResetToken provides a mock function with given fields: ctx, token
+func (_m *Storage) CreatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+	ret := _m.Called(ctx, token)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreatePasswordResetToken")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.PasswordResetToken) error); ok {
+		r0 = rf(ctx, token)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateSession provides a mock function with given fields: ctx, session, refresh
+func (_m *Storage) CreateSession(ctx context.Context, session models.Session, refresh models.RefreshToken) error {
+	ret := _m.Called(ctx, session, refresh)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateSession")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.Session, models.RefreshToken) error); ok {
+		r0 = rf(ctx, session, refresh)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateUser provides a mock function with given fields: ctx, username, passwordHash
+func (_m *Storage) CreateUser(ctx context.Context, username string, passwordHash string) (*models.User, error) {
+	ret := _m.Called(ctx, username, passwordHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateUser")
+	}
+
+	var r0 *models.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*models.User, error)); ok {
+		return rf(ctx, username, passwordHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *models.User); ok {
+		r0 = rf(ctx, username, passwordHash)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, username, passwordHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteExpiredIdempotencyRecords provides a mock function with given fields: ctx, now
+func (_m *Storage) DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int64, error) {
+	ret := _m.Called(ctx, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteExpiredIdempotencyRecords")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(ctx, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, now)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteExpiredLoginThrottles provides a mock function with given fields: ctx, before
+func (_m *Storage) DeleteExpiredLoginThrottles(ctx context.Context, before time.Time) (int64, error) {
+	ret := _m.Called(ctx, before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteExpiredLoginThrottles")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(ctx, before)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteExpiredSessions provides a mock function with given fields: ctx, before
+func (_m *Storage) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	ret := _m.Called(ctx, before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteExpiredSessions")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(ctx, before)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteIdempotencyRecord provides a mock function with given fields: ctx, username, key
+func (_m *Storage) DeleteIdempotencyRecord(ctx context.Context, username string, key string) error {
+	ret := _m.Called(ctx, username, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteIdempotencyRecord")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, username, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTOTP provides a mock function with given fields: ctx, userID
+func (_m *Storage) DeleteTOTP(ctx context.Context, userID int) error {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteTOTP")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetCoinHistory provides a mock function with given fields: ctx, userID
+func (_m *Storage) GetCoinHistory(ctx context.Context, userID int) ([]models.ReceivedTransaction, []models.SentTransaction, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetCoinHistory")
+	}
+
+	var r0 []models.ReceivedTransaction
+	var r1 []models.SentTransaction
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.ReceivedTransaction, []models.SentTransaction, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []models.ReceivedTransaction); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.ReceivedTransaction)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) []models.SentTransaction); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]models.SentTransaction)
+		}
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, int) error); ok {
+		r2 = rf(ctx, userID)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetIdempotencyRecord provides a mock function with given fields: ctx, username, key
+func (_m *Storage) GetIdempotencyRecord(ctx context.Context, username string, key string) (*models.IdempotencyRecord, error) {
+	ret := _m.Called(ctx, username, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetIdempotencyRecord")
+	}
+
+	var r0 *models.IdempotencyRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*models.IdempotencyRecord, error)); ok {
+		return rf(ctx, username, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *models.IdempotencyRecord); ok {
+		r0 = rf(ctx, username, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.IdempotencyRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, username, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLedgerBalance provides a mock function with given fields: ctx, account
+func (_m *Storage) GetLedgerBalance(ctx context.Context, account string) (int, error) {
+	ret := _m.Called(ctx, account)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetLedgerBalance")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int, error)); ok {
+		return rf(ctx, account)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int); ok {
+		r0 = rf(ctx, account)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, account)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLoginThrottle provides a mock function with given fields: ctx, scope, key
+func (_m *Storage) GetLoginThrottle(ctx context.Context, scope string, key string) (*models.LoginThrottle, error) {
+	ret := _m.Called(ctx, scope, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetLoginThrottle")
+	}
+
+	var r0 *models.LoginThrottle
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*models.LoginThrottle, error)); ok {
+		return rf(ctx, scope, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *models.LoginThrottle); ok {
+		r0 = rf(ctx, scope, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.LoginThrottle)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, scope, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTOTP provides a mock function with given fields: ctx, userID
+func (_m *Storage) GetTOTP(ctx context.Context, userID int) (*models.TOTP, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetTOTP")
+	}
+
+	var r0 *models.TOTP
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (*models.TOTP, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) *models.TOTP); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.TOTP)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserByUsername provides a mock function with given fields: ctx, username
+func (_m *Storage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	ret := _m.Called(ctx, username)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserByUsername")
+	}
+
+	var r0 *models.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.User, error)); ok {
+		return rf(ctx, username)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.User); ok {
+		r0 = rf(ctx, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserInventory provides a mock function with given fields: ctx, userID
+func (_m *Storage) GetUserInventory(ctx context.Context, userID int) ([]models.InventoryItem, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserInventory")
+	}
+
+	var r0 []models.InventoryItem
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.InventoryItem, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []models.InventoryItem); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.InventoryItem)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserRoles provides a mock function with given fields: ctx, userID
+func (_m *Storage) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserRoles")
+	}
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) ([]string, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) []string); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GrantRole provides a mock function with given fields: ctx, userID, role, now
+func (_m *Storage) GrantRole(ctx context.Context, userID int, role string, now time.Time) error {
+	ret := _m.Called(ctx, userID, role, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GrantRole")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, role, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InTx provides a mock function with given fields: ctx, opts, fn
+func (_m *Storage) InTx(ctx context.Context, opts *sql.TxOptions, fn func(storage.Storage) error) error {
+	ret := _m.Called(ctx, opts, fn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InTx")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *sql.TxOptions, func(storage.Storage) error) error); ok {
+		r0 = rf(ctx, opts, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IsAccessTokenRevoked provides a mock function with given fields: ctx, jti, sessionID
+func (_m *Storage) IsAccessTokenRevoked(ctx context.Context, jti string, sessionID string) (bool, error) {
+	ret := _m.Called(ctx, jti, sessionID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for IsAccessTokenRevoked")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (bool, error)); ok {
+		return rf(ctx, jti, sessionID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, jti, sessionID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, jti, sessionID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListAuditEvents provides a mock function with given fields: ctx, filter
+func (_m *Storage) ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListAuditEvents")
+	}
+
+	var r0 []models.AuditEvent
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AuditFilter) ([]models.AuditEvent, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.AuditFilter) []models.AuditEvent); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.AuditEvent)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.AuditFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListBalanceMismatches provides a mock function with given fields: ctx
+func (_m *Storage) ListBalanceMismatches(ctx context.Context) ([]models.BalanceMismatch, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListBalanceMismatches")
+	}
+
+	var r0 []models.BalanceMismatch
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]models.BalanceMismatch, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []models.BalanceMismatch); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.BalanceMismatch)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListCoinTransactions provides a mock function with given fields: ctx, userID, filter
+func (_m *Storage) ListCoinTransactions(ctx context.Context, userID int, filter models.TransactionFilter) ([]models.CoinTransaction, error) {
+	ret := _m.Called(ctx, userID, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListCoinTransactions")
+	}
+
+	var r0 []models.CoinTransaction
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.TransactionFilter) ([]models.CoinTransaction, error)); ok {
+		return rf(ctx, userID, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.TransactionFilter) []models.CoinTransaction); ok {
+		r0 = rf(ctx, userID, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.CoinTransaction)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, models.TransactionFilter) error); ok {
+		r1 = rf(ctx, userID, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListItems provides a mock function with given fields: ctx, includeArchived
+func (_m *Storage) ListItems(ctx context.Context, includeArchived bool) ([]models.Item, error) {
+	ret := _m.Called(ctx, includeArchived)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListItems")
+	}
+
+	var r0 []models.Item
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, bool) ([]models.Item, error)); ok {
+		return rf(ctx, includeArchived)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, bool) []models.Item); ok {
+		r0 = rf(ctx, includeArchived)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Item)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, bool) error); ok {
+		r1 = rf(ctx, includeArchived)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListLedgerTransactions provides a mock function with given fields: ctx, filter
+func (_m *Storage) ListLedgerTransactions(ctx context.Context, filter models.LedgerFilter) ([]models.LedgerTransaction, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListLedgerTransactions")
+	}
+
+	var r0 []models.LedgerTransaction
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.LedgerFilter) ([]models.LedgerTransaction, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.LedgerFilter) []models.LedgerTransaction); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.LedgerTransaction)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.LedgerFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListOrders provides a mock function with given fields: ctx, filter
+func (_m *Storage) ListOrders(ctx context.Context, filter models.OrderFilter) ([]models.Order, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListOrders")
+	}
+
+	var r0 []models.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.OrderFilter) ([]models.Order, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.OrderFilter) []models.Order); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Order)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.OrderFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListPurchases provides a mock function with given fields: ctx, userID, filter
+func (_m *Storage) ListPurchases(ctx context.Context, userID int, filter models.PurchaseFilter) ([]models.Purchase, error) {
+	ret := _m.Called(ctx, userID, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListPurchases")
+	}
+
+	var r0 []models.Purchase
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.PurchaseFilter) ([]models.Purchase, error)); ok {
+		return rf(ctx, userID, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.PurchaseFilter) []models.Purchase); ok {
+		r0 = rf(ctx, userID, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Purchase)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, models.PurchaseFilter) error); ok {
+		r1 = rf(ctx, userID, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Migrate provides a mock function with given fields: dsn
+func (_m *Storage) Migrate(dsn string) error {
+	ret := _m.Called(dsn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Migrate")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, username, itemName)
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(dsn)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -32,29 +1034,27 @@ func (_m *Storage) BuyItem(ctx context.Context, username string, itemName string
 	return r0
 }
 
-// CreateUser provides a mock function with given fields: ctx, username, passwordHash
-func (_m *Storage) CreateUser(ctx context.Context, username string, passwordHash string) (*models.User, error) {
-	ret := _m.Called(ctx, username, passwordHash)
+// RecordLoginFailure provides a mock function with given fields: ctx, scope, key, now, resetBefore
+func (_m *Storage) RecordLoginFailure(ctx context.Context, scope string, key string, now time.Time, resetBefore time.Time) (int, error) {
+	ret := _m.Called(ctx, scope, key, now, resetBefore)
 
 	if len(ret) == 0 {
-		panic("no return value specified for CreateUser")
+		panic("no return value specified for RecordLoginFailure")
 	}
 
-	var r0 *models.User
+	var r0 int
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*models.User, error)); ok {
-		return rf(ctx, username, passwordHash)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, time.Time) (int, error)); ok {
+		return rf(ctx, scope, key, now, resetBefore)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) *models.User); ok {
-		r0 = rf(ctx, username, passwordHash)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time, time.Time) int); ok {
+		r0 = rf(ctx, scope, key, now, resetBefore)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*models.User)
-		}
+		r0 = ret.Get(0).(int)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
-		r1 = rf(ctx, username, passwordHash)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Time, time.Time) error); ok {
+		r1 = rf(ctx, scope, key, now, resetBefore)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -62,68 +1062,77 @@ func (_m *Storage) CreateUser(ctx context.Context, username string, passwordHash
 	return r0, r1
 }
 
-// GetCoinHistory provides a mock function with given fields: ctx, userID
-func (_m *Storage) GetCoinHistory(ctx context.Context, userID int) ([]models.ReceivedTransaction, []models.SentTransaction, error) {
-	ret := _m.Called(ctx, userID)
+// RegisterUser provides a mock function with given fields: ctx, user, now
+func (_m *Storage) RegisterUser(ctx context.Context, user models.NewUser, now time.Time) (*models.User, error) {
+	ret := _m.Called(ctx, user, now)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetCoinHistory")
+		panic("no return value specified for RegisterUser")
 	}
 
-	var r0 []models.ReceivedTransaction
-	var r1 []models.SentTransaction
-	var r2 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.ReceivedTransaction, []models.SentTransaction, error)); ok {
-		return rf(ctx, userID)
+	var r0 *models.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.NewUser, time.Time) (*models.User, error)); ok {
+		return rf(ctx, user, now)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int) []models.ReceivedTransaction); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, models.NewUser, time.Time) *models.User); ok {
+		r0 = rf(ctx, user, now)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]models.ReceivedTransaction)
+			r0 = ret.Get(0).(*models.User)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int) []models.SentTransaction); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context, models.NewUser, time.Time) error); ok {
+		r1 = rf(ctx, user, now)
 	} else {
-		if ret.Get(1) != nil {
-			r1 = ret.Get(1).([]models.SentTransaction)
-		}
+		r1 = ret.Error(1)
 	}
 
-	if rf, ok := ret.Get(2).(func(context.Context, int) error); ok {
-		r2 = rf(ctx, userID)
+	return r0, r1
+}
+
+// ResetLoginFailures provides a mock function with given fields: ctx, scope, key
+func (_m *Storage) ResetLoginFailures(ctx context.Context, scope string, key string) error {
+	ret := _m.Called(ctx, scope, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ResetLoginFailures")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, scope, key)
 	} else {
-		r2 = ret.Error(2)
+		r0 = ret.Error(0)
 	}
 
-	return r0, r1, r2
+	return r0
 }
 
-// GetUserByUsername provides a mock function with given fields: ctx, username
-func (_m *Storage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	ret := _m.Called(ctx, username)
+// ResetPassword provides a mock function with given fields: ctx, tokenHash, passwordHash, now
+func (_m *Storage) ResetPassword(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (*models.User, error) {
+	ret := _m.Called(ctx, tokenHash, passwordHash, now)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetUserByUsername")
+		panic("no return value specified for ResetPassword")
 	}
 
 	var r0 *models.User
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.User, error)); ok {
-		return rf(ctx, username)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) (*models.User, error)); ok {
+		return rf(ctx, tokenHash, passwordHash, now)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) *models.User); ok {
-		r0 = rf(ctx, username)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) *models.User); ok {
+		r0 = rf(ctx, tokenHash, passwordHash, now)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*models.User)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, username)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Time) error); ok {
+		r1 = rf(ctx, tokenHash, passwordHash, now)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -131,29 +1140,29 @@ func (_m *Storage) GetUserByUsername(ctx context.Context, username string) (*mod
 	return r0, r1
 }
 
-// GetUserInventory provides a mock function with given fields: ctx, userID
-func (_m *Storage) GetUserInventory(ctx context.Context, userID int) ([]models.InventoryItem, error) {
-	ret := _m.Called(ctx, userID)
+// RestockItem provides a mock function with given fields: ctx, id, quantity
+func (_m *Storage) RestockItem(ctx context.Context, id int, quantity int) (*models.Item, error) {
+	ret := _m.Called(ctx, id, quantity)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetUserInventory")
+		panic("no return value specified for RestockItem")
 	}
 
-	var r0 []models.InventoryItem
+	var r0 *models.Item
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) ([]models.InventoryItem, error)); ok {
-		return rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) (*models.Item, error)); ok {
+		return rf(ctx, id, quantity)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int) []models.InventoryItem); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) *models.Item); ok {
+		r0 = rf(ctx, id, quantity)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]models.InventoryItem)
+			r0 = ret.Get(0).(*models.Item)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, id, quantity)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -161,9 +1170,116 @@ func (_m *Storage) GetUserInventory(ctx context.Context, userID int) ([]models.I
 	return r0, r1
 }
 
-// Migrate provides a mock function with given fields: dsn
-func (_m *Storage) Migrate(dsn string) {
-	_m.Called(dsn)
+// RevokeAccessToken provides a mock function with given fields: ctx, jti, expiresAt
+func (_m *Storage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, jti, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeAccessToken")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, jti, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeRole provides a mock function with given fields: ctx, userID, role
+func (_m *Storage) RevokeRole(ctx context.Context, userID int, role string) error {
+	ret := _m.Called(ctx, userID, role)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeRole")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string) error); ok {
+		r0 = rf(ctx, userID, role)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeSession provides a mock function with given fields: ctx, sessionID, now
+func (_m *Storage) RevokeSession(ctx context.Context, sessionID string, now time.Time) error {
+	ret := _m.Called(ctx, sessionID, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeSession")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, sessionID, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeUserSessions provides a mock function with given fields: ctx, userID, now
+func (_m *Storage) RevokeUserSessions(ctx context.Context, userID int, now time.Time) (int64, error) {
+	ret := _m.Called(ctx, userID, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeUserSessions")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) (int64, error)); ok {
+		return rf(ctx, userID, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) int64); ok {
+		r0 = rf(ctx, userID, now)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, userID, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateRefreshToken provides a mock function with given fields: ctx, tokenHash, next
+func (_m *Storage) RotateRefreshToken(ctx context.Context, tokenHash string, next models.RefreshToken) (*models.Session, error) {
+	ret := _m.Called(ctx, tokenHash, next)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RotateRefreshToken")
+	}
+
+	var r0 *models.Session
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.RefreshToken) (*models.Session, error)); ok {
+		return rf(ctx, tokenHash, next)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.RefreshToken) *models.Session); ok {
+		r0 = rf(ctx, tokenHash, next)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Session)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, models.RefreshToken) error); ok {
+		r1 = rf(ctx, tokenHash, next)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // SendCoins provides a mock function with given fields: ctx, senderUsername, receiverUsername, amount
@@ -184,6 +1300,138 @@ func (_m *Storage) SendCoins(ctx context.Context, senderUsername string, receive
 	return r0
 }
 
+// SetOrderStatus provides a mock function with given fields: ctx, id, status, now
+func (_m *Storage) SetOrderStatus(ctx context.Context, id int, status string, now time.Time) (*models.Order, error) {
+	ret := _m.Called(ctx, id, status, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetOrderStatus")
+	}
+
+	var r0 *models.Order
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) (*models.Order, error)); ok {
+		return rf(ctx, id, status, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) *models.Order); ok {
+		r0 = rf(ctx, id, status, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Order)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, string, time.Time) error); ok {
+		r1 = rf(ctx, id, status, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetPendingTOTP provides a mock function with given fields: ctx, userID, secret, now
+func (_m *Storage) SetPendingTOTP(ctx context.Context, userID int, secret string, now time.Time) error {
+	ret := _m.Called(ctx, userID, secret, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetPendingTOTP")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, secret, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateItem provides a mock function with given fields: ctx, id, update
+func (_m *Storage) UpdateItem(ctx context.Context, id int, update models.ItemUpdate) (*models.Item, error) {
+	ret := _m.Called(ctx, id, update)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateItem")
+	}
+
+	var r0 *models.Item
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.ItemUpdate) (*models.Item, error)); ok {
+		return rf(ctx, id, update)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, models.ItemUpdate) *models.Item); ok {
+		r0 = rf(ctx, id, update)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Item)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, models.ItemUpdate) error); ok {
+		r1 = rf(ctx, id, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdatePasswordHash provides a mock function with given fields: ctx, userID, passwordHash
+func (_m *Storage) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	ret := _m.Called(ctx, userID, passwordHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdatePasswordHash")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string) error); ok {
+		r0 = rf(ctx, userID, passwordHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseRecoveryCode provides a mock function with given fields: ctx, userID, codeHash, now
+func (_m *Storage) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error {
+	ret := _m.Called(ctx, userID, codeHash, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UseRecoveryCode")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, codeHash, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseTOTPStep provides a mock function with given fields: ctx, userID, step
+func (_m *Storage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	ret := _m.Called(ctx, userID, step)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UseTOTPStep")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int64) error); ok {
+		r0 = rf(ctx, userID, step)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewStorage creates a new instance of Storage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewStorage(t interface {
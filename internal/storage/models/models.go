@@ -1,21 +1,82 @@
 package models
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// AuthRequest is the body of both login and registration. Email and
+// InviteCode are only used on registration, depending on the policy.
 type AuthRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Email      string `json:"email,omitempty"`
+	InviteCode string `json:"inviteCode,omitempty"`
+}
+
+// Validate checks a login. Accounts may predate the format rules, so it
+// only requires the credentials; checking them is up to the password.
+func (r AuthRequest) Validate() error {
+	var v validation.Validator
+	v.Required("username", r.Username)
+	v.Required("password", r.Password)
+	return v.Err()
+}
+
+// ValidateRegistration checks a new account against the format rules.
+func (r AuthRequest) ValidateRegistration() error {
+	var v validation.Validator
+	v.Username("username", r.Username)
+	v.Password("password", r.Password)
+	v.Email("email", r.Email)
+	return v.Err()
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (r RefreshRequest) Validate() error {
+	var v validation.Validator
+	v.Required("refreshToken", r.RefreshToken)
+	return v.Err()
 }
 
 type User struct {
 	ID           int    `json:"-"`
 	Username     string `json:"username"`
+	Email        string `json:"email,omitempty"`
 	PasswordHash string `json:"-"`
 	Coins        int    `json:"coins"`
 }
 
+// NewUser is an account to create on registration.
+type NewUser struct {
+	Username     string
+	Email        string
+	PasswordHash string
+	// InviteCode, if set, is redeemed together with creating the user.
+	InviteCode string
+}
+
+type InviteCode struct {
+	Code      string
+	MaxUses   int
+	Uses      int
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
 type InfoResponse struct {
 	Coins       int             `json:"coins"`
 	Inventory   []InventoryItem `json:"inventory"`
@@ -23,6 +84,8 @@ type InfoResponse struct {
 		Received []ReceivedTransaction `json:"received"`
 		Sent     []SentTransaction     `json:"sent"`
 	} `json:"coinHistory"`
+	// Purchases are the latest purchases, only on request.
+	Purchases []Purchase `json:"purchases,omitempty"`
 }
 
 type InventoryItem struct {
@@ -30,6 +93,322 @@ type InventoryItem struct {
 	Quantity int    `json:"quantity"`
 }
 
+// Item is a merch item of the catalog. Archived items cannot be bought
+// but stay in the inventories of their owners.
+type Item struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+	// Stock is the number of items left, nil for unlimited supply.
+	Stock *int `json:"stock,omitempty"`
+	// LowStockThreshold, if set, records an item_low_stock audit event
+	// when a purchase brings the stock down to it.
+	LowStockThreshold *int       `json:"lowStockThreshold,omitempty"`
+	ArchivedAt        *time.Time `json:"archivedAt,omitempty"`
+}
+
+type CreateItemRequest struct {
+	Name              string `json:"name"`
+	Price             int    `json:"price"`
+	Stock             *int   `json:"stock,omitempty"`
+	LowStockThreshold *int   `json:"lowStockThreshold,omitempty"`
+}
+
+func (r CreateItemRequest) Validate() error {
+	var v validation.Validator
+	v.ItemName("name", r.Name)
+	v.Positive("price", r.Price)
+	if r.Stock != nil {
+		v.NotNegative("stock", *r.Stock)
+	}
+	if r.LowStockThreshold != nil {
+		v.NotNegative("lowStockThreshold", *r.LowStockThreshold)
+	}
+	return v.Err()
+}
+
+// ItemUpdate renames or reprices an item or changes its low-stock
+// threshold. Nil fields are left unchanged.
+type ItemUpdate struct {
+	Name              *string `json:"name,omitempty"`
+	Price             *int    `json:"price,omitempty"`
+	LowStockThreshold *int    `json:"lowStockThreshold,omitempty"`
+}
+
+func (u ItemUpdate) Validate() error {
+	var v validation.Validator
+	if u.Name == nil && u.Price == nil && u.LowStockThreshold == nil {
+		v.Add("name", validation.CodeRequired, "name, price or lowStockThreshold must be set")
+	}
+	if u.Name != nil {
+		v.ItemName("name", *u.Name)
+	}
+	if u.Price != nil {
+		v.Positive("price", *u.Price)
+	}
+	if u.LowStockThreshold != nil {
+		v.NotNegative("lowStockThreshold", *u.LowStockThreshold)
+	}
+	return v.Err()
+}
+
+type RestockRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+func (r RestockRequest) Validate() error {
+	var v validation.Validator
+	v.Positive("quantity", r.Quantity)
+	return v.Err()
+}
+
+// OrderLine is a line of a cart: Quantity units of the named item.
+type OrderLine struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+type CreateOrderRequest struct {
+	Items []OrderLine `json:"items"`
+}
+
+func (r CreateOrderRequest) Validate() error {
+	var v validation.Validator
+	if len(r.Items) == 0 {
+		v.Add("items", validation.CodeRequired, "must not be empty")
+	}
+	if len(r.Items) > validation.MaxOrderLines {
+		v.Add("items", validation.CodeTooLong, fmt.Sprintf("must have at most %d lines", validation.MaxOrderLines))
+		return v.Err()
+	}
+
+	seen := make(map[string]bool, len(r.Items))
+	for i, line := range r.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if v.Required(field+".item", line.Item) {
+			if seen[line.Item] {
+				v.Add(field+".item", validation.CodeDuplicate, "item is already in the cart")
+			}
+			seen[line.Item] = true
+		}
+		v.Positive(field+".quantity", line.Quantity)
+		if line.Quantity > validation.MaxOrderQuantity {
+			v.Add(field+".quantity", validation.CodeTooLarge, fmt.Sprintf("must be at most %d", validation.MaxOrderQuantity))
+		}
+	}
+	return v.Err()
+}
+
+// Order statuses. A placed order becomes ready for pickup and then
+// delivered; until it is delivered it can be cancelled, which refunds it.
+const (
+	OrderPlaced         = "placed"
+	OrderReadyForPickup = "ready_for_pickup"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+)
+
+func IsOrderStatus(status string) bool {
+	switch status {
+	case OrderPlaced, OrderReadyForPickup, OrderDelivered, OrderCancelled:
+		return true
+	}
+	return false
+}
+
+// OrderTransitionAllowed reports whether an order can move from one
+// status to another.
+func OrderTransitionAllowed(from, to string) bool {
+	switch from {
+	case OrderPlaced:
+		return to == OrderReadyForPickup || to == OrderCancelled
+	case OrderReadyForPickup:
+		return to == OrderDelivered || to == OrderCancelled
+	}
+	return false
+}
+
+// Order is a checkout. It is also the receipt returned when the order is
+// placed.
+type Order struct {
+	ID          int         `json:"id"`
+	Username    string      `json:"username,omitempty"`
+	Status      string      `json:"status"`
+	Items       []OrderItem `json:"items"`
+	Total       int         `json:"total"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	ReadyAt     *time.Time  `json:"readyAt,omitempty"`
+	DeliveredAt *time.Time  `json:"deliveredAt,omitempty"`
+	CancelledAt *time.Time  `json:"cancelledAt,omitempty"`
+}
+
+type OrderFilter struct {
+	Username string
+	Status   string
+	Limit    int
+}
+
+type OrderStatusRequest struct {
+	Status string `json:"status"`
+}
+
+func (r OrderStatusRequest) Validate() error {
+	var v validation.Validator
+	if v.Required("status", r.Status) && (!IsOrderStatus(r.Status) || r.Status == OrderPlaced) {
+		v.Add("status", validation.CodeInvalidFormat, "must be ready_for_pickup, delivered or cancelled")
+	}
+	return v.Err()
+}
+
+// OrderItem is a line of an order with the unit price paid and the
+// line amount.
+type OrderItem struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+	Price    int    `json:"price"`
+	Amount   int    `json:"amount"`
+}
+
+// Purchase is one line of an order as seen in the purchase history, with
+// the price paid and the status of its order.
+type Purchase struct {
+	ID        int       `json:"id"`
+	OrderID   int       `json:"orderId"`
+	Item      string    `json:"item"`
+	Quantity  int       `json:"quantity"`
+	Price     int       `json:"price"`
+	Amount    int       `json:"amount"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PurchaseFilter selects a page of a user's purchases, newest first.
+type PurchaseFilter struct {
+	After *TransactionCursor
+	Limit int
+}
+
+type PurchasesResponse struct {
+	Purchases  []Purchase `json:"purchases"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// Ledger transaction kinds.
+const (
+	LedgerOpening    = "opening"
+	LedgerGrant      = "grant"
+	LedgerTransfer   = "transfer"
+	LedgerPurchase   = "purchase"
+	LedgerRefund     = "refund"
+	LedgerAdjustment = "adjustment"
+)
+
+// System ledger accounts. Issuance funds grants, the shop receives
+// purchases and pays refunds, and adjustments balance manual corrections.
+const (
+	AccountIssuance    = "system:issuance"
+	AccountShop        = "system:shop"
+	AccountAdjustments = "system:adjustments"
+)
+
+const userAccountPrefix = "user:"
+
+// UserAccount names the ledger account holding a user's coins.
+func UserAccount(userID int) string {
+	return userAccountPrefix + strconv.Itoa(userID)
+}
+
+// AccountUserID returns the user of a user account.
+func AccountUserID(account string) (int, bool) {
+	s, ok := strings.CutPrefix(account, userAccountPrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(s)
+	return id, err == nil
+}
+
+// LedgerLine debits or credits an account. A user's balance is the sum of
+// credits minus debits on their account.
+type LedgerLine struct {
+	Account string `json:"account"`
+	Debit   int    `json:"debit,omitempty"`
+	Credit  int    `json:"credit,omitempty"`
+}
+
+// Move returns the lines moving amount coins from one account to another.
+func Move(from, to string, amount int) []LedgerLine {
+	return []LedgerLine{{Account: from, Debit: amount}, {Account: to, Credit: amount}}
+}
+
+// LedgerTransaction is a balanced set of lines: its debits and credits
+// add up to the same amount. Reference names what it records, such as
+// "order:7".
+type LedgerTransaction struct {
+	ID        int          `json:"id"`
+	Kind      string       `json:"kind"`
+	Reference string       `json:"reference,omitempty"`
+	Memo      string       `json:"memo,omitempty"`
+	Lines     []LedgerLine `json:"lines"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+type LedgerFilter struct {
+	// Account keeps the transactions with a line on this account.
+	Account string
+	Limit   int
+}
+
+// AdjustmentRequest corrects a balance by Amount coins, which may be
+// negative.
+type AdjustmentRequest struct {
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+func (r AdjustmentRequest) Validate() error {
+	var v validation.Validator
+	if r.Amount == 0 {
+		v.Add("amount", validation.CodeRequired, "must not be zero")
+	}
+	if v.Required("reason", r.Reason) && utf8.RuneCountInString(r.Reason) > validation.MaxMemoLength {
+		v.Add("reason", validation.CodeTooLong, fmt.Sprintf("must be at most %d characters", validation.MaxMemoLength))
+	}
+	return v.Err()
+}
+
+// Balance is a user's balance, as answered by the adjustment endpoint.
+type Balance struct {
+	Username string `json:"username"`
+	Coins    int    `json:"coins"`
+}
+
+// BalanceMismatch is a user whose cached balance differs from the
+// balance of their ledger account.
+type BalanceMismatch struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	Coins    int    `json:"coins"`
+	Ledger   int    `json:"ledger"`
+	// Corrected is set once a reconciliation adjustment brought the ledger
+	// in line with Coins.
+	Corrected bool `json:"corrected,omitempty"`
+}
+
+// Difference is how many coins the ledger is missing.
+func (m BalanceMismatch) Difference() int {
+	return m.Coins - m.Ledger
+}
+
+// ReconciliationReport is the outcome of one reconciliation run.
+type ReconciliationReport struct {
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
+	Correct    bool              `json:"correct"`
+	Mismatches []BalanceMismatch `json:"mismatches"`
+	Error      string            `json:"error,omitempty"`
+}
+
 type ReceivedTransaction struct {
 	FromUser string `json:"fromUser"`
 	Amount   int    `json:"amount"`
@@ -44,3 +423,242 @@ type SendCoinRequest struct {
 	ToUser string `json:"toUser"`
 	Amount int    `json:"amount"`
 }
+
+// Validate checks the request alone; sending to oneself is rejected
+// by the storage, which knows the sender.
+func (r SendCoinRequest) Validate() error {
+	return validation.Transfer("", r.ToUser, r.Amount)
+}
+
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// CoinTransaction is a coin transfer seen from one of its participants.
+type CoinTransaction struct {
+	ID           int       `json:"id"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Amount       int       `json:"amount"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TransactionCursor points at the last transaction or purchase of a page.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// TransactionFilter selects a page of a user's transactions, newest first.
+// Zero values mean "no restriction".
+type TransactionFilter struct {
+	Direction    string
+	Counterparty string
+	// From is inclusive, To is exclusive.
+	From  time.Time
+	To    time.Time
+	After *TransactionCursor
+	Limit int
+}
+
+type TransactionsResponse struct {
+	Transactions []CoinTransaction `json:"transactions"`
+	NextCursor   string            `json:"nextCursor,omitempty"`
+}
+
+// IdempotencyRecord stores the outcome of a request made with an
+// Idempotency-Key header. StatusCode is 0 while the request is in flight.
+type IdempotencyRecord struct {
+	Username    string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Session is a login of a user. Every access and refresh token belongs to
+// a session, and revoking the session revokes all of them.
+type Session struct {
+	ID        string
+	UserID    int
+	Username  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// RefreshToken is a stored refresh token. Only the hash of the token is kept.
+type RefreshToken struct {
+	SessionID string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Scopes of login throttling.
+const (
+	ThrottleScopeUsername = "username"
+	ThrottleScopeIP       = "ip"
+)
+
+// LoginThrottle counts failed logins for a username or a client IP.
+type LoginThrottle struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+	// Locked is set when the block is an account lockout rather than a backoff delay.
+	Locked bool
+}
+
+// Roles of users. Every user has RoleUser; the others are granted.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+// GrantableRoles are the roles that can be granted and revoked.
+var GrantableRoles = []string{RoleAdmin, RoleAuditor}
+
+// Audit event types.
+const (
+	AuditAccountLocked       = "account_locked"
+	AuditAccountUnlocked     = "account_unlocked"
+	AuditPasswordChanged     = "password_changed"
+	AuditPasswordResetIssued = "password_reset_issued"
+	AuditPasswordReset       = "password_reset"
+	AuditTwoFactorEnabled    = "two_factor_enabled"
+	AuditTwoFactorDisabled   = "two_factor_disabled"
+	AuditRecoveryCodeUsed    = "recovery_code_used"
+	AuditRoleGranted         = "role_granted"
+	AuditRoleRevoked         = "role_revoked"
+	AuditItemCreated         = "item_created"
+	AuditItemUpdated         = "item_updated"
+	AuditItemArchived        = "item_archived"
+	AuditItemRestocked       = "item_restocked"
+	AuditItemLowStock        = "item_low_stock"
+	AuditOrderStatusChanged  = "order_status_changed"
+	AuditCoinsAdjusted       = "coins_adjusted"
+	AuditBalanceCorrected    = "balance_corrected"
+)
+
+// AuditEvent records a security-relevant action. Actor is who did it and
+// Subject is the username it was done to.
+type AuditEvent struct {
+	ID        int               `json:"id"`
+	Type      string            `json:"type"`
+	Actor     string            `json:"actor,omitempty"`
+	Subject   string            `json:"subject,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+type AuditFilter struct {
+	Type    string
+	Subject string
+	Limit   int
+}
+
+// UserRoles is the answer of the role admin endpoints.
+type UserRoles struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+}
+
+// PasswordResetIssued carries a reset token issued by an admin.
+type PasswordResetIssued struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (r ChangePasswordRequest) Validate() error {
+	var v validation.Validator
+	v.Required("currentPassword", r.CurrentPassword)
+	v.Password("newPassword", r.NewPassword)
+	return v.Err()
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (r ResetPasswordRequest) Validate() error {
+	var v validation.Validator
+	v.Required("token", r.Token)
+	v.Password("newPassword", r.NewPassword)
+	return v.Err()
+}
+
+// PasswordResetToken is a one-time token to set a new password. Only the
+// hash of the token is stored.
+type PasswordResetToken struct {
+	UserID    int
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// TOTP is a user's authenticator secret. It protects logins only once
+// ConfirmedAt is set.
+type TOTP struct {
+	UserID      int
+	Secret      string
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code; codes are
+	// only accepted for later steps, so none can be replayed.
+	LastUsedStep int64
+}
+
+// TwoFactorCodeRequest carries a TOTP code or a recovery code.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (r TwoFactorCodeRequest) Validate() error {
+	var v validation.Validator
+	v.Required("code", r.Code)
+	return v.Err()
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+func (r TwoFactorLoginRequest) Validate() error {
+	var v validation.Validator
+	v.Required("challengeToken", r.ChallengeToken)
+	v.Required("code", r.Code)
+	return v.Err()
+}
+
+// TwoFactorChallenge is the login response for users with 2FA enabled.
+// The challenge token is exchanged for tokens at /api/auth/2fa.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool      `json:"twoFactorRequired"`
+	ChallengeToken    string    `json:"challengeToken"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+func (s *PostgresStorage) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	return insertAuditEvent(ctx, s.db, event)
+}
+
+// insertAuditEvent records event with db, so that storage operations can
+// record events in their own transaction.
+func insertAuditEvent(ctx context.Context, db dbtx, event models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO audit_events (type, actor, subject, ip, details, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.Type, event.Actor, event.Subject, event.IP, details, event.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// ListAuditEvents returns the newest events first.
+func (s *PostgresStorage) ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	query := `SELECT id, type, actor, subject, ip, details, created_at FROM audit_events WHERE TRUE`
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Type != "" {
+		query += " AND type = " + arg(filter.Type)
+	}
+	if filter.Subject != "" {
+		query += " AND subject = " + arg(filter.Subject)
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.Actor, &event.Subject, &event.IP, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &event.Details); err != nil {
+			return nil, err
+		}
+		if len(event.Details) == 0 {
+			event.Details = nil
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+const itemColumns = "id, name, price, stock, low_stock_threshold, archived_at"
+
+func scanItem(row interface{ Scan(...interface{}) error }) (*models.Item, error) {
+	var item models.Item
+	var stock, threshold sql.NullInt64
+	var archivedAt sql.NullTime
+	if err := row.Scan(&item.ID, &item.Name, &item.Price, &stock, &threshold, &archivedAt); err != nil {
+		return nil, err
+	}
+	item.Stock = intOrNil(stock)
+	item.LowStockThreshold = intOrNil(threshold)
+	if archivedAt.Valid {
+		item.ArchivedAt = &archivedAt.Time
+	}
+	return &item, nil
+}
+
+func intOrNil(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// ListItems returns the catalog ordered by ID.
+func (s *PostgresStorage) ListItems(ctx context.Context, includeArchived bool) ([]models.Item, error) {
+	query := "SELECT " + itemColumns + " FROM merch_items"
+	if !includeArchived {
+		query += " WHERE archived_at IS NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+func (s *PostgresStorage) CreateItem(ctx context.Context, req models.CreateItemRequest) (*models.Item, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	item, err := scanItem(s.db.QueryRowContext(ctx,
+		`INSERT INTO merch_items (name, price, stock, low_stock_threshold)
+        VALUES ($1, $2, $3, $4)
+        RETURNING `+itemColumns,
+		req.Name, req.Price, req.Stock, req.LowStockThreshold,
+	))
+	return item, itemError(err)
+}
+
+// UpdateItem renames or reprices an item, archived or not.
+func (s *PostgresStorage) UpdateItem(ctx context.Context, id int, update models.ItemUpdate) (*models.Item, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+
+	item, err := scanItem(s.db.QueryRowContext(ctx,
+		`UPDATE merch_items
+        SET name = COALESCE($2, name),
+            price = COALESCE($3, price),
+            low_stock_threshold = COALESCE($4, low_stock_threshold)
+        WHERE id = $1
+        RETURNING `+itemColumns,
+		id, update.Name, update.Price, update.LowStockThreshold,
+	))
+	return item, itemError(err)
+}
+
+// RestockItem adds quantity to the stock of an item. An item with
+// unlimited supply becomes limited to quantity.
+func (s *PostgresStorage) RestockItem(ctx context.Context, id, quantity int) (*models.Item, error) {
+	if err := (models.RestockRequest{Quantity: quantity}).Validate(); err != nil {
+		return nil, err
+	}
+
+	item, err := scanItem(s.db.QueryRowContext(ctx,
+		`UPDATE merch_items SET stock = COALESCE(stock, 0) + $2
+        WHERE id = $1
+        RETURNING `+itemColumns,
+		id, quantity,
+	))
+	return item, itemError(err)
+}
+
+// ArchiveItem takes an item off sale. Archiving an archived item keeps its
+// original archive time.
+func (s *PostgresStorage) ArchiveItem(ctx context.Context, id int, now time.Time) (*models.Item, error) {
+	item, err := scanItem(s.db.QueryRowContext(ctx,
+		`UPDATE merch_items SET archived_at = COALESCE(archived_at, $2)
+        WHERE id = $1
+        RETURNING `+itemColumns,
+		id, now.UTC(),
+	))
+	return item, itemError(err)
+}
+
+// lowStockEvent returns the event to record after a purchase took the
+// stock of an item from before to after. It reports false unless the
+// stock has just reached the item's low-stock threshold.
+func lowStockEvent(item *models.Item, before, after int, now time.Time) (models.AuditEvent, bool) {
+	threshold := item.LowStockThreshold
+	if threshold == nil || before <= *threshold || after > *threshold {
+		return models.AuditEvent{}, false
+	}
+	return models.AuditEvent{
+		Type:    models.AuditItemLowStock,
+		Subject: item.Name,
+		Details: map[string]string{
+			"id":        strconv.Itoa(item.ID),
+			"stock":     strconv.Itoa(after),
+			"threshold": strconv.Itoa(*threshold),
+		},
+		CreatedAt: now,
+	}, true
+}
+
+func itemError(err error) error {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrItemNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		return ErrItemExists
+	}
+	return err
+}
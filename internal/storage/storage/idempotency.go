@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// CreateIdempotencyRecord reserves a key for an in-flight request. An expired
+// record with the same key is replaced, a live one yields ErrIdempotencyKeyExists.
+func (s *PostgresStorage) CreateIdempotencyRecord(ctx context.Context, record models.IdempotencyRecord) error {
+	var key string
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO idempotency_keys (username, key, request_hash, created_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (username, key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            status_code = 0,
+            content_type = '',
+            body = NULL,
+            created_at = EXCLUDED.created_at,
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+        RETURNING key`,
+		record.Username, record.Key, record.RequestHash, record.CreatedAt.UTC(), record.ExpiresAt.UTC(),
+	).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrIdempotencyKeyExists
+	}
+	return err
+}
+
+func (s *PostgresStorage) GetIdempotencyRecord(ctx context.Context, username, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	err := s.db.QueryRowContext(ctx,
+		`SELECT username, key, request_hash, status_code, content_type, body, created_at, expires_at
+        FROM idempotency_keys
+        WHERE username = $1 AND key = $2`,
+		username, key,
+	).Scan(&record.Username, &record.Key, &record.RequestHash, &record.StatusCode,
+		&record.ContentType, &record.Body, &record.CreatedAt, &record.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *PostgresStorage) CompleteIdempotencyRecord(ctx context.Context, username, key string, statusCode int, contentType string, body []byte) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys
+        SET status_code = $3, content_type = $4, body = $5
+        WHERE username = $1 AND key = $2`,
+		username, key, statusCode, contentType, body,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrIdempotencyKeyNotFound)
+}
+
+func (s *PostgresStorage) DeleteIdempotencyRecord(ctx context.Context, username, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE username = $1 AND key = $2",
+		username, key,
+	)
+	return err
+}
+
+func (s *PostgresStorage) DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE expires_at <= $1",
+		now.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// expectAffected returns notFound if res did not touch any row.
+func expectAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// startingCoins are granted to every new user.
+const startingCoins = 1000
+
+// checkBalanced returns ErrUnbalancedLedger unless lines debit and credit
+// the same positive amount, one side per line.
+func checkBalanced(lines []models.LedgerLine) error {
+	var debit, credit int
+	for _, line := range lines {
+		if line.Debit < 0 || line.Credit < 0 || (line.Debit == 0) == (line.Credit == 0) {
+			return fmt.Errorf("%w: line on %s must either debit or credit", ErrUnbalancedLedger, line.Account)
+		}
+		debit += line.Debit
+		credit += line.Credit
+	}
+	if debit == 0 || debit != credit {
+		return fmt.Errorf("%w: debits %d, credits %d", ErrUnbalancedLedger, debit, credit)
+	}
+	return nil
+}
+
+// postLedger records a ledger transaction and applies it to the balances
+// cached in users.coins, in the caller's transaction. The user rows must
+// already be locked. A cached balance that no longer matches the running
+// balance in ledger_balances fails the posting with ErrBalanceMismatch.
+func postLedger(ctx context.Context, tx *sql.Tx, txn models.LedgerTransaction) error {
+	balances, err := insertLedger(ctx, tx, txn)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range txn.Lines {
+		userID, ok := models.AccountUserID(line.Account)
+		if !ok {
+			continue
+		}
+		var cached int
+		err := tx.QueryRowContext(ctx,
+			"UPDATE users SET coins = coins + $2 WHERE id = $1 RETURNING coins",
+			userID, line.Credit-line.Debit,
+		).Scan(&cached)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if balance := balances[line.Account]; cached != balance {
+			return balanceMismatch(line.Account, cached, balance)
+		}
+	}
+	return nil
+}
+
+// balanceMismatch raises the alert for a drifted account and returns the
+// error that blocks the posting. No coins move on the account until an
+// operator reconciles it.
+func balanceMismatch(account string, cached, ledger int) error {
+	err := fmt.Errorf("%w: %s caches %d coins, the ledger has %d", ErrBalanceMismatch, account, cached, ledger)
+	log.Printf("ALERT: %v; run shop reconcile", err)
+	return err
+}
+
+// insertLedger records a ledger transaction without touching the cached
+// balances. It returns the running balances of the user accounts involved,
+// which it keeps in ledger_balances.
+func insertLedger(ctx context.Context, tx *sql.Tx, txn models.LedgerTransaction) (map[string]int, error) {
+	if err := checkBalanced(txn.Lines); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO ledger_transactions (kind, reference, memo, created_at)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id`,
+		txn.Kind, txn.Reference, txn.Memo, txn.CreatedAt.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]int)
+	for _, line := range txn.Lines {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO ledger_entries (transaction_id, account, debit, credit) VALUES ($1, $2, $3, $4)",
+			id, line.Account, line.Debit, line.Credit,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := models.AccountUserID(line.Account); !ok {
+			continue
+		}
+		var balance int
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO ledger_balances (account, balance) VALUES ($1, $2)
+        ON CONFLICT (account) DO UPDATE SET balance = ledger_balances.balance + EXCLUDED.balance
+        RETURNING balance`,
+			line.Account, line.Credit-line.Debit,
+		).Scan(&balance)
+		if err != nil {
+			return nil, err
+		}
+		balances[line.Account] = balance
+	}
+	return balances, nil
+}
+
+// ledgerBalance sums the account's entries, which ledger_balances only
+// keeps track of.
+func ledgerBalance(ctx context.Context, db dbtx, account string) (int, error) {
+	var balance int
+	err := db.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(credit - debit), 0) FROM ledger_entries WHERE account = $1",
+		account,
+	).Scan(&balance)
+	return balance, err
+}
+
+// GetLedgerBalance returns the balance of an account derived from the
+// ledger.
+func (s *PostgresStorage) GetLedgerBalance(ctx context.Context, account string) (int, error) {
+	return ledgerBalance(ctx, s.db, account)
+}
+
+// ListLedgerTransactions returns the newest transactions first.
+func (s *PostgresStorage) ListLedgerTransactions(ctx context.Context, filter models.LedgerFilter) ([]models.LedgerTransaction, error) {
+	query := "SELECT id, kind, reference, memo, created_at FROM ledger_transactions t WHERE TRUE"
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Account != "" {
+		query += " AND EXISTS (SELECT 1 FROM ledger_entries e WHERE e.transaction_id = t.id AND e.account = " +
+			arg(filter.Account) + ")"
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []models.LedgerTransaction
+	for rows.Next() {
+		var txn models.LedgerTransaction
+		if err := rows.Scan(&txn.ID, &txn.Kind, &txn.Reference, &txn.Memo, &txn.CreatedAt); err != nil {
+			return nil, err
+		}
+		txns = append(txns, txn)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(txns) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(txns))
+	byID := make(map[int]*models.LedgerTransaction, len(txns))
+	for i := range txns {
+		ids[i] = int64(txns[i].ID)
+		byID[txns[i].ID] = &txns[i]
+	}
+	lines, err := s.db.QueryContext(ctx,
+		`SELECT transaction_id, account, debit, credit FROM ledger_entries
+        WHERE transaction_id = ANY($1)
+        ORDER BY id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer lines.Close()
+
+	for lines.Next() {
+		var id int
+		var line models.LedgerLine
+		if err := lines.Scan(&id, &line.Account, &line.Debit, &line.Credit); err != nil {
+			return nil, err
+		}
+		txn := byID[id]
+		txn.Lines = append(txn.Lines, line)
+	}
+	return txns, lines.Err()
+}
+
+// AdjustCoins corrects a user's balance by amount coins against the
+// adjustments account and returns the new balance.
+func (s *PostgresStorage) AdjustCoins(ctx context.Context, userID, amount int, reason string, now time.Time) (int, error) {
+	if err := (models.AdjustmentRequest{Amount: amount, Reason: reason}).Validate(); err != nil {
+		return 0, err
+	}
+
+	var coins int
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT coins FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&coins)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if coins+amount < 0 {
+			return ErrInsufficientCoins
+		}
+		return postLedger(ctx, tx, adjustment(userID, amount, reason, now))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return coins + amount, nil
+}
+
+// ListBalanceMismatches compares every user's cached balance with their
+// ledger account in one snapshot.
+func (s *PostgresStorage) ListBalanceMismatches(ctx context.Context) ([]models.BalanceMismatch, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT u.id, u.username, u.coins, COALESCE(l.balance, 0)
+        FROM users u
+        LEFT JOIN (
+            SELECT account, SUM(credit - debit) AS balance
+            FROM ledger_entries
+            WHERE account LIKE 'user:%'
+            GROUP BY account
+        ) l ON l.account = 'user:' || u.id
+        WHERE u.coins <> COALESCE(l.balance, 0)
+        ORDER BY u.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mismatches []models.BalanceMismatch
+	for rows.Next() {
+		var m models.BalanceMismatch
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Coins, &m.Ledger); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches, rows.Err()
+}
+
+// CorrectBalance posts an adjustment that brings a user's ledger account in
+// line with their cached balance, the one they have been shown and spent
+// from. It returns nil if the two agree by the time the user is locked.
+func (s *PostgresStorage) CorrectBalance(ctx context.Context, userID int, now time.Time) (*models.BalanceMismatch, error) {
+	var corrected *models.BalanceMismatch
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		m := models.BalanceMismatch{UserID: userID}
+		err := tx.QueryRowContext(ctx, "SELECT username, coins FROM users WHERE id = $1 FOR UPDATE", userID).
+			Scan(&m.Username, &m.Coins)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if m.Ledger, err = ledgerBalance(ctx, tx, models.UserAccount(userID)); err != nil {
+			return err
+		}
+		if m.Difference() == 0 {
+			return nil
+		}
+
+		if _, err := insertLedger(ctx, tx, correction(m, now)); err != nil {
+			return err
+		}
+		m.Corrected = true
+		corrected = &m
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return corrected, nil
+}
+
+// correction is the reconciliation adjustment for m.
+func correction(m models.BalanceMismatch, now time.Time) models.LedgerTransaction {
+	txn := adjustment(m.UserID, m.Difference(),
+		fmt.Sprintf("reconciliation: cached balance %d, ledger %d", m.Coins, m.Ledger), now)
+	txn.Reference = "reconciliation"
+	return txn
+}
+
+func adjustment(userID, amount int, reason string, now time.Time) models.LedgerTransaction {
+	lines := models.Move(models.AccountAdjustments, models.UserAccount(userID), amount)
+	if amount < 0 {
+		lines = models.Move(models.UserAccount(userID), models.AccountAdjustments, -amount)
+	}
+	return models.LedgerTransaction{
+		Kind:      models.LedgerAdjustment,
+		Memo:      reason,
+		Lines:     lines,
+		CreatedAt: now,
+	}
+}
+
+func grant(userID int, now time.Time) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Kind:      models.LedgerGrant,
+		Lines:     models.Move(models.AccountIssuance, models.UserAccount(userID), startingCoins),
+		CreatedAt: now,
+	}
+}
+
+func transfer(id, senderID, receiverID, amount int, now time.Time) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Kind:      models.LedgerTransfer,
+		Reference: "transfer:" + strconv.Itoa(id),
+		Lines:     models.Move(models.UserAccount(senderID), models.UserAccount(receiverID), amount),
+		CreatedAt: now,
+	}
+}
+
+func purchase(orderID, userID, total int, now time.Time) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Kind:      models.LedgerPurchase,
+		Reference: "order:" + strconv.Itoa(orderID),
+		Lines:     models.Move(models.UserAccount(userID), models.AccountShop, total),
+		CreatedAt: now,
+	}
+}
+
+func refund(orderID, userID, total int, now time.Time) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Kind:      models.LedgerRefund,
+		Reference: "order:" + strconv.Itoa(orderID),
+		Lines:     models.Move(models.AccountShop, models.UserAccount(userID), total),
+		CreatedAt: now,
+	}
+}
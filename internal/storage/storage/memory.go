@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// defaultMerchItems mirrors the catalog seeded by the migrations.
+var defaultMerchItems = []struct {
+	Name  string
+	Price int
+}{
+	{"t-shirt", 80},
+	{"cup", 20},
+	{"book", 50},
+	{"pen", 10},
+	{"powerbank", 200},
+	{"hoody", 300},
+	{"umbrella", 200},
+	{"socks", 10},
+	{"wallet", 50},
+	{"pink-hoody", 500},
+}
+
+type memoryItem struct {
+	id                int
+	name              string
+	price             int
+	stock             *int
+	lowStockThreshold *int
+	archivedAt        *time.Time
+}
+
+type memoryTransaction struct {
+	id         int
+	senderID   int
+	receiverID int
+	amount     int
+	createdAt  time.Time
+}
+
+// MemoryStorage is an in-memory Storage implementation with the same
+// semantics as PostgresStorage. It is meant for tests and local demos.
+type MemoryStorage struct {
+	*memoryStore
+	// inUnit is set on the storage handed to a unit of work, whose
+	// operations already hold units.
+	inUnit bool
+}
+
+// memoryStore is shared by a MemoryStorage and the storages handed to its
+// units of work.
+type memoryStore struct {
+	// units is held for writing by a running unit of work and for reading
+	// by every operation outside one; see InTx.
+	units sync.RWMutex
+	mu    sync.RWMutex
+	memoryState
+}
+
+// memoryState is everything MemoryStorage stores, guarded by its mu.
+type memoryState struct {
+	users        map[string]*models.User
+	usersByID    map[int]*models.User
+	items        map[string]*memoryItem
+	inventory    map[int]map[int]int
+	transactions []memoryTransaction
+	idempotency  map[idempotencyKey]*models.IdempotencyRecord
+	invites      map[string]*models.InviteCode
+
+	sessions            map[string]*models.Session
+	refreshTokens       map[string]*memoryRefreshToken
+	revokedAccessTokens map[string]time.Time
+
+	throttles   map[throttleKey]*models.LoginThrottle
+	auditEvents []models.AuditEvent
+	resetTokens map[string]*memoryResetToken
+
+	totp map[int]*models.TOTP
+	// recoveryCodes maps users to code hashes and whether they were used.
+	recoveryCodes map[int]map[string]bool
+	// roles maps users to their granted roles.
+	roles  map[int]map[string]bool
+	orders []*memoryOrder
+	ledger []models.LedgerTransaction
+	// ledgerBalances maps accounts to the sum of their ledger lines.
+	ledgerBalances map[string]int
+
+	lastUserID        int
+	lastItemID        int
+	lastTransactionID int
+	lastAuditEventID  int
+	lastOrderID       int
+	lastPurchaseID    int
+	lastLedgerID      int
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	s := &MemoryStorage{memoryStore: &memoryStore{memoryState: memoryState{
+		users:       make(map[string]*models.User),
+		usersByID:   make(map[int]*models.User),
+		items:       make(map[string]*memoryItem),
+		inventory:   make(map[int]map[int]int),
+		idempotency: make(map[idempotencyKey]*models.IdempotencyRecord),
+		invites:     make(map[string]*models.InviteCode),
+
+		sessions:            make(map[string]*models.Session),
+		refreshTokens:       make(map[string]*memoryRefreshToken),
+		revokedAccessTokens: make(map[string]time.Time),
+
+		throttles:   make(map[throttleKey]*models.LoginThrottle),
+		resetTokens: make(map[string]*memoryResetToken),
+
+		totp:          make(map[int]*models.TOTP),
+		recoveryCodes: make(map[int]map[string]bool),
+		roles:         make(map[int]map[string]bool),
+
+		ledgerBalances: make(map[string]int),
+	}}}
+	for _, item := range defaultMerchItems {
+		s.lastItemID++
+		s.items[item.Name] = &memoryItem{id: s.lastItemID, name: item.Name, price: item.Price}
+	}
+	return s
+}
+
+// Migrate is a no-op: the in-memory schema is always up to date.
+func (s *MemoryStorage) Migrate(dsn string) error { return nil }
+
+func (s *MemoryStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.rlock()
+	defer s.runlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return nil, errNoUser
+	}
+	u := *user
+	return &u, nil
+}
+
+func (s *MemoryStorage) CreateUser(ctx context.Context, username, passwordHash string) (*models.User, error) {
+	var v validation.Validator
+	v.Username("username", username)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.users[username]; ok {
+		return nil, ErrUserExists
+	}
+
+	s.lastUserID++
+	user := &models.User{
+		ID:           s.lastUserID,
+		Username:     username,
+		PasswordHash: passwordHash,
+	}
+	s.users[username] = user
+	s.usersByID[user.ID] = user
+	if err := s.postLedger(grant(user.ID, time.Now())); err != nil {
+		return nil, err
+	}
+
+	return &models.User{ID: user.ID, Username: user.Username, Coins: user.Coins}, nil
+}
+
+func (s *MemoryStorage) GetUserInventory(ctx context.Context, userID int) ([]models.InventoryItem, error) {
+	s.rlock()
+	defer s.runlock()
+
+	items := make([]*memoryItem, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].id < items[j].id })
+
+	var inventory []models.InventoryItem
+	for _, item := range items {
+		if quantity := s.inventory[userID][item.id]; quantity > 0 {
+			inventory = append(inventory, models.InventoryItem{Type: item.name, Quantity: quantity})
+		}
+	}
+	return inventory, nil
+}
+
+func (s *MemoryStorage) GetCoinHistory(ctx context.Context, userID int) ([]models.ReceivedTransaction, []models.SentTransaction, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var received []models.ReceivedTransaction
+	var sent []models.SentTransaction
+	for _, t := range s.transactions {
+		if t.receiverID == userID {
+			received = append(received, models.ReceivedTransaction{
+				FromUser: s.usersByID[t.senderID].Username,
+				Amount:   t.amount,
+			})
+		}
+		if t.senderID == userID {
+			sent = append(sent, models.SentTransaction{
+				ToUser: s.usersByID[t.receiverID].Username,
+				Amount: t.amount,
+			})
+		}
+	}
+	return received, sent, nil
+}
+
+func (s *MemoryStorage) ListCoinTransactions(ctx context.Context, userID int, filter models.TransactionFilter) ([]models.CoinTransaction, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var transactions []models.CoinTransaction
+	for i := len(s.transactions) - 1; i >= 0; i-- {
+		t := s.transactions[i]
+
+		var ct models.CoinTransaction
+		switch userID {
+		case t.senderID:
+			ct.Direction = models.DirectionSent
+			ct.Counterparty = s.usersByID[t.receiverID].Username
+		case t.receiverID:
+			ct.Direction = models.DirectionReceived
+			ct.Counterparty = s.usersByID[t.senderID].Username
+		default:
+			continue
+		}
+
+		if filter.Direction != "" && filter.Direction != ct.Direction {
+			continue
+		}
+		if filter.Counterparty != "" && filter.Counterparty != ct.Counterparty {
+			continue
+		}
+		if !filter.From.IsZero() && t.createdAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !t.createdAt.Before(filter.To) {
+			continue
+		}
+		if a := filter.After; a != nil &&
+			(t.createdAt.After(a.CreatedAt) || t.createdAt.Equal(a.CreatedAt) && t.id >= a.ID) {
+			continue
+		}
+
+		ct.ID = t.id
+		ct.Amount = t.amount
+		ct.CreatedAt = t.createdAt
+		transactions = append(transactions, ct)
+		if filter.Limit > 0 && len(transactions) == filter.Limit {
+			break
+		}
+	}
+	return transactions, nil
+}
+
+func (s *MemoryStorage) SendCoins(ctx context.Context, senderUsername, receiverUsername string, amount int) error {
+	if err := validation.Transfer(senderUsername, receiverUsername, amount); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	sender, ok := s.users[senderUsername]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if sender.Coins < amount {
+		return ErrInsufficientCoins
+	}
+
+	receiver, ok := s.users[receiverUsername]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if err := s.postLedger(transfer(s.lastTransactionID+1, sender.ID, receiver.ID, amount, now)); err != nil {
+		return err
+	}
+
+	s.lastTransactionID++
+	s.transactions = append(s.transactions, memoryTransaction{
+		id:         s.lastTransactionID,
+		senderID:   sender.ID,
+		receiverID: receiver.ID,
+		amount:     amount,
+		createdAt:  now,
+	})
+	return nil
+}
+
+func (s *MemoryStorage) BuyItem(ctx context.Context, username, itemName string) error {
+	_, err := s.CreateOrder(ctx, username, []models.OrderLine{{Item: itemName, Quantity: 1}}, time.Now())
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"maps"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+func (s *MemoryStorage) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	s.lock()
+	defer s.unlock()
+
+	s.appendAuditEvent(event)
+	return nil
+}
+
+// appendAuditEvent must be called with s.mu held.
+func (s *MemoryStorage) appendAuditEvent(event models.AuditEvent) {
+	s.lastAuditEventID++
+	event.ID = s.lastAuditEventID
+	event.Details = maps.Clone(event.Details)
+	if len(event.Details) == 0 {
+		event.Details = nil
+	}
+	s.auditEvents = append(s.auditEvents, event)
+}
+
+func (s *MemoryStorage) ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var events []models.AuditEvent
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		event := s.auditEvents[i]
+		if filter.Type != "" && event.Type != filter.Type {
+			continue
+		}
+		if filter.Subject != "" && event.Subject != filter.Subject {
+			continue
+		}
+		event.Details = maps.Clone(event.Details)
+		events = append(events, event)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+func (i *memoryItem) model() *models.Item {
+	item := &models.Item{
+		ID:                i.id,
+		Name:              i.name,
+		Price:             i.price,
+		Stock:             copyInt(i.stock),
+		LowStockThreshold: copyInt(i.lowStockThreshold),
+	}
+	if i.archivedAt != nil {
+		t := *i.archivedAt
+		item.ArchivedAt = &t
+	}
+	return item
+}
+
+func (s *MemoryStorage) ListItems(ctx context.Context, includeArchived bool) ([]models.Item, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var items []models.Item
+	for _, item := range s.items {
+		if includeArchived || item.archivedAt == nil {
+			items = append(items, *item.model())
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (s *MemoryStorage) CreateItem(ctx context.Context, req models.CreateItemRequest) (*models.Item, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.items[req.Name]; ok {
+		return nil, ErrItemExists
+	}
+	s.lastItemID++
+	item := &memoryItem{
+		id:                s.lastItemID,
+		name:              req.Name,
+		price:             req.Price,
+		stock:             copyInt(req.Stock),
+		lowStockThreshold: copyInt(req.LowStockThreshold),
+	}
+	s.items[req.Name] = item
+	return item.model(), nil
+}
+
+func (s *MemoryStorage) UpdateItem(ctx context.Context, id int, update models.ItemUpdate) (*models.Item, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	item := s.itemByID(id)
+	if item == nil {
+		return nil, ErrItemNotFound
+	}
+	if update.Name != nil && *update.Name != item.name {
+		if _, ok := s.items[*update.Name]; ok {
+			return nil, ErrItemExists
+		}
+		delete(s.items, item.name)
+		item.name = *update.Name
+		s.items[item.name] = item
+	}
+	if update.Price != nil {
+		item.price = *update.Price
+	}
+	if update.LowStockThreshold != nil {
+		item.lowStockThreshold = copyInt(update.LowStockThreshold)
+	}
+	return item.model(), nil
+}
+
+func (s *MemoryStorage) RestockItem(ctx context.Context, id, quantity int) (*models.Item, error) {
+	if err := (models.RestockRequest{Quantity: quantity}).Validate(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	item := s.itemByID(id)
+	if item == nil {
+		return nil, ErrItemNotFound
+	}
+	stock := quantity
+	if item.stock != nil {
+		stock += *item.stock
+	}
+	item.stock = &stock
+	return item.model(), nil
+}
+
+func (s *MemoryStorage) ArchiveItem(ctx context.Context, id int, now time.Time) (*models.Item, error) {
+	s.lock()
+	defer s.unlock()
+
+	item := s.itemByID(id)
+	if item == nil {
+		return nil, ErrItemNotFound
+	}
+	if item.archivedAt == nil {
+		t := now.UTC()
+		item.archivedAt = &t
+	}
+	return item.model(), nil
+}
+
+func copyInt(n *int) *int {
+	if n == nil {
+		return nil
+	}
+	v := *n
+	return &v
+}
+
+// itemByID must be called with s.mu held.
+func (s *MemoryStorage) itemByID(id int) *memoryItem {
+	for _, item := range s.items {
+		if item.id == id {
+			return item
+		}
+	}
+	return nil
+}
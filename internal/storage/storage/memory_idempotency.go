@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+type idempotencyKey struct {
+	username string
+	key      string
+}
+
+func (s *MemoryStorage) CreateIdempotencyRecord(ctx context.Context, record models.IdempotencyRecord) error {
+	s.lock()
+	defer s.unlock()
+
+	id := idempotencyKey{record.Username, record.Key}
+	if existing, ok := s.idempotency[id]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+		return ErrIdempotencyKeyExists
+	}
+
+	record.StatusCode = 0
+	record.ContentType = ""
+	record.Body = nil
+	s.idempotency[id] = &record
+	return nil
+}
+
+func (s *MemoryStorage) GetIdempotencyRecord(ctx context.Context, username, key string) (*models.IdempotencyRecord, error) {
+	s.rlock()
+	defer s.runlock()
+
+	record, ok := s.idempotency[idempotencyKey{username, key}]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	r := *record
+	r.Body = append([]byte(nil), record.Body...)
+	return &r, nil
+}
+
+func (s *MemoryStorage) CompleteIdempotencyRecord(ctx context.Context, username, key string, statusCode int, contentType string, body []byte) error {
+	s.lock()
+	defer s.unlock()
+
+	record, ok := s.idempotency[idempotencyKey{username, key}]
+	if !ok {
+		return ErrIdempotencyKeyNotFound
+	}
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = append([]byte(nil), body...)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteIdempotencyRecord(ctx context.Context, username, key string) error {
+	s.lock()
+	defer s.unlock()
+
+	delete(s.idempotency, idempotencyKey{username, key})
+	return nil
+}
+
+func (s *MemoryStorage) DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int64, error) {
+	s.lock()
+	defer s.unlock()
+
+	var deleted int64
+	for id, record := range s.idempotency {
+		if !record.ExpiresAt.After(now) {
+			delete(s.idempotency, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// postLedger must be called with s.mu held. Unlike the PostgreSQL version
+// it checks the cached balances before changing anything, as there is no
+// transaction to roll back.
+func (s *MemoryStorage) postLedger(txn models.LedgerTransaction) error {
+	if err := checkBalanced(txn.Lines); err != nil {
+		return err
+	}
+
+	for _, line := range txn.Lines {
+		userID, ok := models.AccountUserID(line.Account)
+		if !ok {
+			continue
+		}
+		user, ok := s.usersByID[userID]
+		if !ok {
+			return ErrUserNotFound
+		}
+		if balance := s.ledgerBalance(line.Account); user.Coins != balance {
+			return balanceMismatch(line.Account, user.Coins, balance)
+		}
+	}
+
+	s.appendLedger(txn)
+	for _, line := range txn.Lines {
+		if userID, ok := models.AccountUserID(line.Account); ok {
+			s.usersByID[userID].Coins += line.Credit - line.Debit
+		}
+	}
+	return nil
+}
+
+// appendLedger records a balanced transaction without touching the cached
+// balances. It must be called with s.mu held.
+func (s *MemoryStorage) appendLedger(txn models.LedgerTransaction) {
+	s.lastLedgerID++
+	txn.ID = s.lastLedgerID
+	txn.CreatedAt = txn.CreatedAt.UTC().Truncate(time.Microsecond)
+	txn.Lines = slices.Clone(txn.Lines)
+	s.ledger = append(s.ledger, txn)
+	for _, line := range txn.Lines {
+		s.ledgerBalances[line.Account] += line.Credit - line.Debit
+	}
+}
+
+// ledgerBalance must be called with s.mu held.
+func (s *MemoryStorage) ledgerBalance(account string) int {
+	return s.ledgerBalances[account]
+}
+
+func (s *MemoryStorage) GetLedgerBalance(ctx context.Context, account string) (int, error) {
+	s.rlock()
+	defer s.runlock()
+
+	return s.ledgerBalance(account), nil
+}
+
+func (s *MemoryStorage) ListLedgerTransactions(ctx context.Context, filter models.LedgerFilter) ([]models.LedgerTransaction, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var txns []models.LedgerTransaction
+	for i := len(s.ledger) - 1; i >= 0; i-- {
+		txn := s.ledger[i]
+		if filter.Account != "" && !slices.ContainsFunc(txn.Lines, func(l models.LedgerLine) bool {
+			return l.Account == filter.Account
+		}) {
+			continue
+		}
+		txn.Lines = slices.Clone(txn.Lines)
+		txns = append(txns, txn)
+		if filter.Limit > 0 && len(txns) == filter.Limit {
+			break
+		}
+	}
+	return txns, nil
+}
+
+func (s *MemoryStorage) AdjustCoins(ctx context.Context, userID, amount int, reason string, now time.Time) (int, error) {
+	if err := (models.AdjustmentRequest{Amount: amount, Reason: reason}).Validate(); err != nil {
+		return 0, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.usersByID[userID]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	if user.Coins+amount < 0 {
+		return 0, ErrInsufficientCoins
+	}
+	if err := s.postLedger(adjustment(userID, amount, reason, now)); err != nil {
+		return 0, err
+	}
+	return user.Coins, nil
+}
+
+func (s *MemoryStorage) ListBalanceMismatches(ctx context.Context) ([]models.BalanceMismatch, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var mismatches []models.BalanceMismatch
+	for _, user := range s.usersByID {
+		m := models.BalanceMismatch{
+			UserID:   user.ID,
+			Username: user.Username,
+			Coins:    user.Coins,
+			Ledger:   s.ledgerBalance(models.UserAccount(user.ID)),
+		}
+		if m.Difference() != 0 {
+			mismatches = append(mismatches, m)
+		}
+	}
+	slices.SortFunc(mismatches, func(a, b models.BalanceMismatch) int { return a.UserID - b.UserID })
+	return mismatches, nil
+}
+
+func (s *MemoryStorage) CorrectBalance(ctx context.Context, userID int, now time.Time) (*models.BalanceMismatch, error) {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.usersByID[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	m := models.BalanceMismatch{
+		UserID:   userID,
+		Username: user.Username,
+		Coins:    user.Coins,
+		Ledger:   s.ledgerBalance(models.UserAccount(userID)),
+	}
+	if m.Difference() == 0 {
+		return nil, nil
+	}
+
+	s.appendLedger(correction(m, now))
+	m.Corrected = true
+	return &m, nil
+}
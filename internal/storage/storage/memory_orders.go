@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+type memoryOrder struct {
+	id          int
+	userID      int
+	status      string
+	lines       []memoryOrderLine
+	total       int
+	createdAt   time.Time
+	readyAt     *time.Time
+	deliveredAt *time.Time
+	cancelledAt *time.Time
+}
+
+type memoryOrderLine struct {
+	id       int
+	itemID   int
+	quantity int
+	price    int
+}
+
+func (s *MemoryStorage) CreateOrder(ctx context.Context, username string, lines []models.OrderLine, now time.Time) (*models.Order, error) {
+	if err := (models.CreateOrderRequest{Items: lines}).Validate(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	order, err := priceOrder(lines, func(name string) *models.Item {
+		if item, ok := s.items[name]; ok {
+			return item.model()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.Username = username
+	order.Status = models.OrderPlaced
+	order.CreatedAt = now.UTC().Truncate(time.Microsecond)
+
+	user, ok := s.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.Coins < order.Total {
+		return nil, ErrInsufficientCoins
+	}
+
+	if err := s.postLedger(purchase(s.lastOrderID+1, user.ID, order.Total, now)); err != nil {
+		return nil, err
+	}
+	s.lastOrderID++
+	order.ID = s.lastOrderID
+	stored := &memoryOrder{
+		id:        order.ID,
+		userID:    user.ID,
+		status:    order.Status,
+		total:     order.Total,
+		createdAt: order.CreatedAt,
+	}
+
+	if s.inventory[user.ID] == nil {
+		s.inventory[user.ID] = make(map[int]int)
+	}
+	for _, line := range order.Items {
+		item := s.items[line.Item]
+		s.inventory[user.ID][item.id] += line.Quantity
+		s.lastPurchaseID++
+		stored.lines = append(stored.lines, memoryOrderLine{
+			id:       s.lastPurchaseID,
+			itemID:   item.id,
+			quantity: line.Quantity,
+			price:    line.Price,
+		})
+
+		if item.stock == nil {
+			continue
+		}
+		before := *item.stock
+		*item.stock -= line.Quantity
+		if event, ok := lowStockEvent(item.model(), before, *item.stock, now); ok {
+			s.appendAuditEvent(event)
+		}
+	}
+
+	s.orders = append(s.orders, stored)
+	return order, nil
+}
+
+func (s *MemoryStorage) ListOrders(ctx context.Context, filter models.OrderFilter) ([]models.Order, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var orders []models.Order
+	for i := len(s.orders) - 1; i >= 0; i-- {
+		order := s.orderModel(s.orders[i])
+		if filter.Username != "" && order.Username != filter.Username {
+			continue
+		}
+		if filter.Status != "" && order.Status != filter.Status {
+			continue
+		}
+		orders = append(orders, order)
+		if filter.Limit > 0 && len(orders) == filter.Limit {
+			break
+		}
+	}
+	return orders, nil
+}
+
+func (s *MemoryStorage) ListPurchases(ctx context.Context, userID int, filter models.PurchaseFilter) ([]models.Purchase, error) {
+	s.rlock()
+	defer s.runlock()
+
+	var purchases []models.Purchase
+	for i := len(s.orders) - 1; i >= 0; i-- {
+		o := s.orders[i]
+		if o.userID != userID {
+			continue
+		}
+		for j := len(o.lines) - 1; j >= 0; j-- {
+			line := o.lines[j]
+			if a := filter.After; a != nil &&
+				(o.createdAt.After(a.CreatedAt) || o.createdAt.Equal(a.CreatedAt) && line.id >= a.ID) {
+				continue
+			}
+
+			purchases = append(purchases, models.Purchase{
+				ID:        line.id,
+				OrderID:   o.id,
+				Item:      s.itemByID(line.itemID).name,
+				Quantity:  line.quantity,
+				Price:     line.price,
+				Amount:    line.quantity * line.price,
+				Status:    o.status,
+				CreatedAt: o.createdAt,
+			})
+			if filter.Limit > 0 && len(purchases) == filter.Limit {
+				return purchases, nil
+			}
+		}
+	}
+	return purchases, nil
+}
+
+func (s *MemoryStorage) SetOrderStatus(ctx context.Context, id int, status string, now time.Time) (*models.Order, error) {
+	if err := (models.OrderStatusRequest{Status: status}).Validate(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	var order *memoryOrder
+	for _, o := range s.orders {
+		if o.id == id {
+			order = o
+			break
+		}
+	}
+	if order == nil {
+		return nil, ErrOrderNotFound
+	}
+	if !models.OrderTransitionAllowed(order.status, status) {
+		return nil, ErrOrderStatusTransition
+	}
+
+	if status == models.OrderCancelled {
+		if err := s.postLedger(refund(order.id, order.userID, order.total, now)); err != nil {
+			return nil, err
+		}
+	}
+
+	t := now.UTC().Truncate(time.Microsecond)
+	order.status = status
+	switch status {
+	case models.OrderReadyForPickup:
+		order.readyAt = &t
+	case models.OrderDelivered:
+		order.deliveredAt = &t
+	case models.OrderCancelled:
+		order.cancelledAt = &t
+		for _, line := range order.lines {
+			if item := s.itemByID(line.itemID); item.stock != nil {
+				*item.stock += line.quantity
+			}
+			s.inventory[order.userID][line.itemID] -= line.quantity
+			if s.inventory[order.userID][line.itemID] <= 0 {
+				delete(s.inventory[order.userID], line.itemID)
+			}
+		}
+	}
+
+	model := s.orderModel(order)
+	return &model, nil
+}
+
+// orderModel must be called with s.mu held.
+func (s *MemoryStorage) orderModel(o *memoryOrder) models.Order {
+	order := models.Order{
+		ID:          o.id,
+		Username:    s.usersByID[o.userID].Username,
+		Status:      o.status,
+		Total:       o.total,
+		CreatedAt:   o.createdAt,
+		ReadyAt:     copyTime(o.readyAt),
+		DeliveredAt: copyTime(o.deliveredAt),
+		CancelledAt: copyTime(o.cancelledAt),
+	}
+	lines := append([]memoryOrderLine(nil), o.lines...)
+	sort.Slice(lines, func(i, j int) bool { return lines[i].itemID < lines[j].itemID })
+	for _, line := range lines {
+		order.Items = append(order.Items, models.OrderItem{
+			Item:     s.itemByID(line.itemID).name,
+			Quantity: line.quantity,
+			Price:    line.price,
+			Amount:   line.quantity * line.price,
+		})
+	}
+	return order
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
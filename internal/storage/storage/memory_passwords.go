@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+type memoryResetToken struct {
+	models.PasswordResetToken
+	used bool
+}
+
+func (s *MemoryStorage) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.usersByID[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	return nil
+}
+
+func (s *MemoryStorage) ChangePassword(ctx context.Context, userID int, passwordHash string, now time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	return s.setPassword(userID, passwordHash, now)
+}
+
+func (s *MemoryStorage) CreatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.usersByID[token.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	for hash, t := range s.resetTokens {
+		if t.UserID == token.UserID && !t.used {
+			delete(s.resetTokens, hash)
+		}
+	}
+	s.resetTokens[token.TokenHash] = &memoryResetToken{PasswordResetToken: token}
+	return nil
+}
+
+func (s *MemoryStorage) ResetPassword(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*models.User, error) {
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.resetTokens[tokenHash]
+	if !ok || token.used || !token.ExpiresAt.After(now) {
+		return nil, ErrResetTokenInvalid
+	}
+	if err := s.setPassword(token.UserID, passwordHash, now); err != nil {
+		return nil, err
+	}
+	token.used = true
+
+	user := s.usersByID[token.UserID]
+	return &models.User{ID: user.ID, Username: user.Username, Email: user.Email, Coins: user.Coins}, nil
+}
+
+// setPassword must be called with s.mu held.
+func (s *MemoryStorage) setPassword(userID int, passwordHash string, now time.Time) error {
+	user, ok := s.usersByID[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
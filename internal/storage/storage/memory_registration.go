@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+func (s *MemoryStorage) RegisterUser(ctx context.Context, user models.NewUser, now time.Time) (*models.User, error) {
+	var v validation.Validator
+	v.Username("username", user.Username)
+	v.Email("email", user.Email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	var invite *models.InviteCode
+	if user.InviteCode != "" {
+		invite = s.invites[user.InviteCode]
+		if invite == nil || invite.Uses >= invite.MaxUses ||
+			(invite.ExpiresAt != nil && !invite.ExpiresAt.After(now)) {
+			return nil, ErrInviteCodeInvalid
+		}
+	}
+
+	if _, ok := s.users[user.Username]; ok {
+		return nil, ErrUserExists
+	}
+	if user.Email != "" {
+		for _, u := range s.users {
+			if strings.EqualFold(u.Email, user.Email) {
+				return nil, ErrEmailExists
+			}
+		}
+	}
+
+	if invite != nil {
+		invite.Uses++
+	}
+	s.lastUserID++
+	created := &models.User{
+		ID:           s.lastUserID,
+		Username:     user.Username,
+		Email:        user.Email,
+		PasswordHash: user.PasswordHash,
+	}
+	s.users[created.Username] = created
+	s.usersByID[created.ID] = created
+	if err := s.postLedger(grant(created.ID, now)); err != nil {
+		return nil, err
+	}
+
+	return &models.User{ID: created.ID, Username: created.Username, Email: created.Email, Coins: created.Coins}, nil
+}
+
+func (s *MemoryStorage) CreateInviteCode(ctx context.Context, invite models.InviteCode) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.invites[invite.Code]; ok {
+		return ErrInviteCodeExists
+	}
+	invite.Uses = 0
+	s.invites[invite.Code] = &invite
+	return nil
+}
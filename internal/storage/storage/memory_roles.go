@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+func (s *MemoryStorage) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	s.rlock()
+	defer s.runlock()
+
+	return rolesOf(s.roles[userID]), nil
+}
+
+func (s *MemoryStorage) GrantRole(ctx context.Context, userID int, role string, now time.Time) error {
+	if err := validateGrantableRole(role); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.usersByID[userID]; !ok {
+		return ErrUserNotFound
+	}
+	if s.roles[userID] == nil {
+		s.roles[userID] = make(map[string]bool)
+	}
+	s.roles[userID][role] = true
+	return nil
+}
+
+func (s *MemoryStorage) RevokeRole(ctx context.Context, userID int, role string) error {
+	if err := validateGrantableRole(role); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if !s.roles[userID][role] {
+		return ErrRoleNotGranted
+	}
+	delete(s.roles[userID], role)
+	return nil
+}
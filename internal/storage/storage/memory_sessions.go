@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+type memoryRefreshToken struct {
+	models.RefreshToken
+	used bool
+}
+
+func (s *MemoryStorage) CreateSession(ctx context.Context, session models.Session, refresh models.RefreshToken) error {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.usersByID[session.UserID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	session.Username = user.Username
+	session.RevokedAt = nil
+	s.sessions[session.ID] = &session
+
+	refresh.SessionID = session.ID
+	s.refreshTokens[refresh.TokenHash] = &memoryRefreshToken{RefreshToken: refresh}
+	return nil
+}
+
+func (s *MemoryStorage) RotateRefreshToken(ctx context.Context, tokenHash string, next models.RefreshToken) (*models.Session, error) {
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	session, ok := s.sessions[token.SessionID]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	now := next.CreatedAt
+	switch {
+	case session.RevokedAt != nil:
+		return nil, ErrSessionRevoked
+	case token.used:
+		session.RevokedAt = &now
+		return nil, ErrRefreshTokenReused
+	case !token.ExpiresAt.After(now):
+		return nil, ErrRefreshTokenExpired
+	}
+
+	token.used = true
+	next.SessionID = session.ID
+	s.refreshTokens[next.TokenHash] = &memoryRefreshToken{RefreshToken: next}
+	session.ExpiresAt = next.ExpiresAt
+
+	result := *session
+	return &result, nil
+}
+
+func (s *MemoryStorage) RevokeSession(ctx context.Context, sessionID string, now time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStorage) RevokeUserSessions(ctx context.Context, userID int, now time.Time) (int64, error) {
+	s.lock()
+	defer s.unlock()
+
+	var revoked int64
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.revokedAccessTokens[jti]; !ok {
+		s.revokedAccessTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	s.rlock()
+	defer s.runlock()
+
+	if _, ok := s.revokedAccessTokens[jti]; ok {
+		return true, nil
+	}
+	session, ok := s.sessions[sessionID]
+	return !ok || session.RevokedAt != nil, nil
+}
+
+func (s *MemoryStorage) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	s.lock()
+	defer s.unlock()
+
+	for jti, expiresAt := range s.revokedAccessTokens {
+		if !expiresAt.After(before) {
+			delete(s.revokedAccessTokens, jti)
+		}
+	}
+
+	var deleted int64
+	for id, session := range s.sessions {
+		if !session.ExpiresAt.After(before) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	for hash, token := range s.refreshTokens {
+		if _, ok := s.sessions[token.SessionID]; !ok || !token.ExpiresAt.After(before) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+type throttleKey struct {
+	scope string
+	key   string
+}
+
+func (s *MemoryStorage) GetLoginThrottle(ctx context.Context, scope, key string) (*models.LoginThrottle, error) {
+	s.rlock()
+	defer s.runlock()
+
+	throttle, ok := s.throttles[throttleKey{scope, key}]
+	if !ok {
+		return &models.LoginThrottle{Scope: scope, Key: key}, nil
+	}
+	t := *throttle
+	return &t, nil
+}
+
+func (s *MemoryStorage) RecordLoginFailure(ctx context.Context, scope, key string, now, resetBefore time.Time) (int, error) {
+	s.lock()
+	defer s.unlock()
+
+	id := throttleKey{scope, key}
+	throttle, ok := s.throttles[id]
+	if !ok {
+		throttle = &models.LoginThrottle{Scope: scope, Key: key}
+		s.throttles[id] = throttle
+	}
+	if throttle.LastFailureAt.Before(resetBefore) {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	return throttle.Failures, nil
+}
+
+func (s *MemoryStorage) BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error {
+	s.lock()
+	defer s.unlock()
+
+	if throttle, ok := s.throttles[throttleKey{scope, key}]; ok {
+		throttle.BlockedUntil = &until
+		throttle.Locked = locked
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ResetLoginFailures(ctx context.Context, scope, key string) error {
+	s.lock()
+	defer s.unlock()
+
+	delete(s.throttles, throttleKey{scope, key})
+	return nil
+}
+
+func (s *MemoryStorage) DeleteExpiredLoginThrottles(ctx context.Context, before time.Time) (int64, error) {
+	s.lock()
+	defer s.unlock()
+
+	var deleted int64
+	for id, throttle := range s.throttles {
+		if throttle.LastFailureAt.Before(before) &&
+			(throttle.BlockedUntil == nil || throttle.BlockedUntil.Before(before)) {
+			delete(s.throttles, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+func (s *MemoryStorage) GetTOTP(ctx context.Context, userID int) (*models.TOTP, error) {
+	s.rlock()
+	defer s.runlock()
+
+	totp, ok := s.totp[userID]
+	if !ok {
+		return nil, ErrTOTPNotFound
+	}
+	t := *totp
+	return &t, nil
+}
+
+func (s *MemoryStorage) SetPendingTOTP(ctx context.Context, userID int, secret string, now time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.usersByID[userID]; !ok {
+		return ErrUserNotFound
+	}
+	if totp, ok := s.totp[userID]; ok && totp.ConfirmedAt != nil {
+		return ErrTOTPEnabled
+	}
+	s.totp[userID] = &models.TOTP{UserID: userID, Secret: secret, CreatedAt: now}
+	return nil
+}
+
+func (s *MemoryStorage) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string, now time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.ConfirmedAt != nil {
+		return ErrTOTPNotFound
+	}
+	totp.ConfirmedAt = &now
+	totp.LastUsedStep = step
+
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (s *MemoryStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	s.lock()
+	defer s.unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.ConfirmedAt == nil || totp.LastUsedStep >= step {
+		return ErrTOTPCodeReused
+	}
+	totp.LastUsedStep = step
+	return nil
+}
+
+func (s *MemoryStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error {
+	s.lock()
+	defer s.unlock()
+
+	used, ok := s.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return ErrRecoveryCodeInvalid
+	}
+	s.recoveryCodes[userID][codeHash] = true
+	return nil
+}
+
+func (s *MemoryStorage) DeleteTOTP(ctx context.Context, userID int) error {
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.totp[userID]; !ok {
+		return ErrTOTPNotFound
+	}
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// InTx runs fn with the store to itself: operations outside the unit wait
+// until it ends, so they neither see its changes before it commits nor
+// make changes that rolling it back would undo. A failed unit restores the
+// state it began with. Opts are ignored, as units are serializable. Fn must
+// use the storage it is handed; operations on s would wait for the unit.
+func (s *MemoryStorage) InTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Storage) error) error {
+	if s.inUnit {
+		return fn(s)
+	}
+
+	s.units.Lock()
+	defer s.units.Unlock()
+
+	s.mu.RLock()
+	snapshot := s.memoryState.clone()
+	s.mu.RUnlock()
+
+	if err := fn(&MemoryStorage{memoryStore: s.memoryStore, inUnit: true}); err != nil {
+		s.mu.Lock()
+		s.memoryState = snapshot
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// lock locks the state for writing, waiting for a running unit of work
+// first unless s belongs to it.
+func (s *MemoryStorage) lock() {
+	if !s.inUnit {
+		s.units.RLock()
+	}
+	s.mu.Lock()
+}
+
+func (s *MemoryStorage) unlock() {
+	s.mu.Unlock()
+	if !s.inUnit {
+		s.units.RUnlock()
+	}
+}
+
+// rlock locks the state for reading like lock does for writing.
+func (s *MemoryStorage) rlock() {
+	if !s.inUnit {
+		s.units.RLock()
+	}
+	s.mu.RLock()
+}
+
+func (s *MemoryStorage) runlock() {
+	s.mu.RUnlock()
+	if !s.inUnit {
+		s.units.RUnlock()
+	}
+}
+
+// clone copies the state deep enough that changing one copy leaves the
+// other intact.
+func (st *memoryState) clone() memoryState {
+	c := *st
+
+	c.users = make(map[string]*models.User, len(st.users))
+	c.usersByID = make(map[int]*models.User, len(st.usersByID))
+	for name, user := range st.users {
+		u := *user
+		c.users[name] = &u
+		c.usersByID[u.ID] = &u
+	}
+
+	c.items = make(map[string]*memoryItem, len(st.items))
+	for name, item := range st.items {
+		it := *item
+		if item.stock != nil {
+			stock := *item.stock
+			it.stock = &stock
+		}
+		c.items[name] = &it
+	}
+
+	c.inventory = make(map[int]map[int]int, len(st.inventory))
+	for userID, items := range st.inventory {
+		c.inventory[userID] = maps.Clone(items)
+	}
+	c.recoveryCodes = make(map[int]map[string]bool, len(st.recoveryCodes))
+	for userID, codes := range st.recoveryCodes {
+		c.recoveryCodes[userID] = maps.Clone(codes)
+	}
+	c.roles = make(map[int]map[string]bool, len(st.roles))
+	for userID, roles := range st.roles {
+		c.roles[userID] = maps.Clone(roles)
+	}
+
+	c.idempotency = clonePointers(st.idempotency)
+	c.invites = clonePointers(st.invites)
+	c.sessions = clonePointers(st.sessions)
+	c.refreshTokens = clonePointers(st.refreshTokens)
+	c.revokedAccessTokens = maps.Clone(st.revokedAccessTokens)
+	c.throttles = clonePointers(st.throttles)
+	c.resetTokens = clonePointers(st.resetTokens)
+	c.totp = clonePointers(st.totp)
+
+	c.orders = make([]*memoryOrder, len(st.orders))
+	for i, order := range st.orders {
+		o := *order
+		c.orders[i] = &o
+	}
+	c.transactions = slices.Clone(st.transactions)
+	c.auditEvents = slices.Clone(st.auditEvents)
+	c.ledger = slices.Clone(st.ledger)
+	c.ledgerBalances = maps.Clone(st.ledgerBalances)
+	return c
+}
+
+// clonePointers copies m and the values it points to.
+func clonePointers[K comparable, V any](m map[K]*V) map[K]*V {
+	c := make(map[K]*V, len(m))
+	for k, v := range m {
+		copied := *v
+		c[k] = &copied
+	}
+	return c
+}
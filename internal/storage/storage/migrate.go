@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/mi4r/avito-shop/internal/storage/migrations"
+)
+
+// MigrationStatus describes the schema state of a database.
+type MigrationStatus struct {
+	// Version is the applied schema version, 0 if nothing is applied.
+	Version uint
+	// Dirty is set when a migration failed halfway and needs a force.
+	Dirty bool
+	// Latest is the newest version embedded into the binary.
+	Latest uint
+}
+
+// Migrator applies the embedded migrations to a PostgreSQL database.
+type Migrator struct {
+	migr   *migrate.Migrate
+	latest uint
+}
+
+func NewMigrator(dsn string) (*Migrator, error) {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := latestVersion(src)
+	if err != nil {
+		return nil, err
+	}
+
+	migr, err := migrate.NewWithSourceInstance("iofs", src, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{migr: migr, latest: latest}, nil
+}
+
+func latestVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return version, nil
+			}
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// Up applies the given number of migrations, or all pending ones if steps is 0.
+func (m *Migrator) Up(steps int) error {
+	if steps < 0 {
+		return fmt.Errorf("invalid number of steps: %d", steps)
+	}
+	if steps == 0 {
+		return ignoreNoChange(m.migr.Up())
+	}
+	return ignoreNoChange(m.migr.Steps(steps))
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("invalid number of steps: %d", steps)
+	}
+	return ignoreNoChange(m.migr.Steps(-steps))
+}
+
+// Goto migrates up or down to the given version.
+func (m *Migrator) Goto(version uint) error {
+	return ignoreNoChange(m.migr.Migrate(version))
+}
+
+// Force sets the schema version without running migrations and clears the
+// dirty flag. Version -1 means no migrations are applied.
+func (m *Migrator) Force(version int) error {
+	return m.migr.Force(version)
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := m.migr.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, err
+	}
+	return MigrationStatus{Version: version, Dirty: dirty, Latest: m.latest}, nil
+}
+
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.migr.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// CreateOrder charges the total of a cart and adds its items to the
+// user's inventory in a single transaction.
+func (s *PostgresStorage) CreateOrder(ctx context.Context, username string, lines []models.OrderLine, now time.Time) (*models.Order, error) {
+	if err := (models.CreateOrderRequest{Items: lines}).Validate(); err != nil {
+		return nil, err
+	}
+
+	var order *models.Order
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		order, err = createOrder(ctx, tx, username, lines, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func createOrder(ctx context.Context, tx *sql.Tx, username string, lines []models.OrderLine, now time.Time) (*models.Order, error) {
+	names := make([]string, len(lines))
+	for i, line := range lines {
+		names[i] = line.Item
+	}
+
+	// FOR UPDATE keeps the items from being repriced or archived until the
+	// order commits and serializes orders of their last units. Locking in
+	// ID order keeps concurrent orders from deadlocking.
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+itemColumns+" FROM merch_items WHERE name = ANY($1) ORDER BY id FOR UPDATE",
+		pq.Array(names),
+	)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]*models.Item, len(lines))
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items[item.Name] = item
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	order, err := priceOrder(lines, func(name string) *models.Item { return items[name] })
+	if err != nil {
+		return nil, err
+	}
+	order.Username = username
+	order.Status = models.OrderPlaced
+	order.CreatedAt = now.UTC().Truncate(time.Microsecond)
+
+	var userID, coins int
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, coins FROM users WHERE username = $1 FOR UPDATE",
+		username,
+	).Scan(&userID, &coins)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if coins < order.Total {
+		return nil, ErrInsufficientCoins
+	}
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO orders (user_id, total, created_at) VALUES ($1, $2, $3) RETURNING id",
+		userID, order.Total, order.CreatedAt,
+	).Scan(&order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := postLedger(ctx, tx, purchase(order.ID, userID, order.Total, order.CreatedAt)); err != nil {
+		return nil, err
+	}
+
+	for _, line := range order.Items {
+		item := items[line.Item]
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO order_items (order_id, item_id, quantity, price) VALUES ($1, $2, $3, $4)",
+			order.ID, item.ID, line.Quantity, line.Price,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO user_inventory (user_id, item_id, quantity)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (user_id, item_id)
+            DO UPDATE SET quantity = user_inventory.quantity + EXCLUDED.quantity`,
+			userID, item.ID, line.Quantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if item.Stock == nil {
+			continue
+		}
+		left := *item.Stock - line.Quantity
+		if _, err := tx.ExecContext(ctx, "UPDATE merch_items SET stock = $1 WHERE id = $2", left, item.ID); err != nil {
+			return nil, err
+		}
+		if event, ok := lowStockEvent(item, *item.Stock, left, now); ok {
+			if err := insertAuditEvent(ctx, tx, event); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return order, nil
+}
+
+const orderColumns = "o.id, u.username, o.status, o.total, o.created_at, o.ready_at, o.delivered_at, o.cancelled_at"
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (*models.Order, error) {
+	var order models.Order
+	var readyAt, deliveredAt, cancelledAt sql.NullTime
+	err := row.Scan(&order.ID, &order.Username, &order.Status, &order.Total, &order.CreatedAt,
+		&readyAt, &deliveredAt, &cancelledAt)
+	if err != nil {
+		return nil, err
+	}
+	order.ReadyAt = timeOrNil(readyAt)
+	order.DeliveredAt = timeOrNil(deliveredAt)
+	order.CancelledAt = timeOrNil(cancelledAt)
+	return &order, nil
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// ListOrders returns the newest orders first.
+func (s *PostgresStorage) ListOrders(ctx context.Context, filter models.OrderFilter) ([]models.Order, error) {
+	query := "SELECT " + orderColumns + " FROM orders o JOIN users u ON u.id = o.user_id WHERE TRUE"
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Username != "" {
+		query += " AND u.username = " + arg(filter.Username)
+	}
+	if filter.Status != "" {
+		query += " AND o.status = " + arg(filter.Status)
+	}
+	query += " ORDER BY o.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, loadOrderItems(ctx, s.db, orders)
+}
+
+// ListPurchases returns a user's order lines, newest first. Lines of
+// cancelled orders are kept with their order's status.
+func (s *PostgresStorage) ListPurchases(ctx context.Context, userID int, filter models.PurchaseFilter) ([]models.Purchase, error) {
+	query := `SELECT oi.id, o.id, m.name, oi.quantity, oi.price, o.status, o.created_at
+        FROM order_items oi
+        JOIN orders o ON o.id = oi.order_id
+        JOIN merch_items m ON m.id = oi.item_id
+        WHERE o.user_id = $1`
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.After != nil {
+		query += fmt.Sprintf(" AND (o.created_at, oi.id) < (%s, %s)",
+			arg(filter.After.CreatedAt.UTC()), arg(filter.After.ID))
+	}
+	query += " ORDER BY o.created_at DESC, oi.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purchases []models.Purchase
+	for rows.Next() {
+		var p models.Purchase
+		if err := rows.Scan(&p.ID, &p.OrderID, &p.Item, &p.Quantity, &p.Price, &p.Status, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.Amount = p.Quantity * p.Price
+		purchases = append(purchases, p)
+	}
+	return purchases, rows.Err()
+}
+
+// orderTimeColumns are the columns recording when an order reached a
+// status.
+var orderTimeColumns = map[string]string{
+	models.OrderReadyForPickup: "ready_at",
+	models.OrderDelivered:      "delivered_at",
+	models.OrderCancelled:      "cancelled_at",
+}
+
+// SetOrderStatus moves an order to status. Cancelling an order refunds
+// its total and takes its items back from the inventory into stock, in
+// the same transaction.
+func (s *PostgresStorage) SetOrderStatus(ctx context.Context, id int, status string, now time.Time) (*models.Order, error) {
+	if err := (models.OrderStatusRequest{Status: status}).Validate(); err != nil {
+		return nil, err
+	}
+
+	var order *models.Order
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		order, err = setOrderStatus(ctx, tx, id, status, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func setOrderStatus(ctx context.Context, tx *sql.Tx, id int, status string, now time.Time) (*models.Order, error) {
+	var userID, total int
+	var current string
+	err := tx.QueryRowContext(ctx,
+		"SELECT user_id, status, total FROM orders WHERE id = $1 FOR UPDATE",
+		id,
+	).Scan(&userID, &current, &total)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if !models.OrderTransitionAllowed(current, status) {
+		return nil, ErrOrderStatusTransition
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE orders SET status = $2, "+orderTimeColumns[status]+" = $3 WHERE id = $1",
+		id, status, now.UTC().Truncate(time.Microsecond),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == models.OrderCancelled {
+		if err := refundOrder(ctx, tx, id, userID, total, now); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err := scanOrder(tx.QueryRowContext(ctx,
+		"SELECT "+orderColumns+" FROM orders o JOIN users u ON u.id = o.user_id WHERE o.id = $1",
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+	orders := []models.Order{*order}
+	if err := loadOrderItems(ctx, tx, orders); err != nil {
+		return nil, err
+	}
+	return &orders[0], nil
+}
+
+// refundOrder returns the stock, inventory and coins taken by an order.
+// Items are locked before the user, in ID order, as in CreateOrder.
+func refundOrder(ctx context.Context, tx *sql.Tx, orderID, userID, total int, now time.Time) error {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT item_id, quantity FROM order_items WHERE order_id = $1 ORDER BY item_id",
+		orderID,
+	)
+	if err != nil {
+		return err
+	}
+	type line struct{ itemID, quantity int }
+	var lines []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.itemID, &l.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		_, err := tx.ExecContext(ctx,
+			"UPDATE merch_items SET stock = stock + $2 WHERE id = $1 AND stock IS NOT NULL",
+			l.itemID, l.quantity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return err
+	}
+	if err := postLedger(ctx, tx, refund(orderID, userID, total, now)); err != nil {
+		return err
+	}
+
+	for _, l := range lines {
+		_, err := tx.ExecContext(ctx,
+			"UPDATE user_inventory SET quantity = quantity - $3 WHERE user_id = $1 AND item_id = $2",
+			userID, l.itemID, l.quantity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_inventory WHERE user_id = $1 AND quantity <= 0", userID)
+	return err
+}
+
+// loadOrderItems fills in the items of orders, ordered by item ID.
+func loadOrderItems(ctx context.Context, db dbtx, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(orders))
+	byID := make(map[int]*models.Order, len(orders))
+	for i := range orders {
+		ids[i] = int64(orders[i].ID)
+		byID[orders[i].ID] = &orders[i]
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT oi.order_id, m.name, oi.quantity, oi.price
+        FROM order_items oi
+        JOIN merch_items m ON m.id = oi.item_id
+        WHERE oi.order_id = ANY($1)
+        ORDER BY oi.order_id, oi.item_id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var orderID int
+		var item models.OrderItem
+		if err := rows.Scan(&orderID, &item.Item, &item.Quantity, &item.Price); err != nil {
+			return err
+		}
+		item.Amount = item.Quantity * item.Price
+		order := byID[orderID]
+		order.Items = append(order.Items, item)
+	}
+	return rows.Err()
+}
+
+// priceOrder checks that every line of a cart can be bought and prices
+// it. lookup returns nil for unknown items.
+func priceOrder(lines []models.OrderLine, lookup func(name string) *models.Item) (*models.Order, error) {
+	order := &models.Order{}
+	for _, line := range lines {
+		item := lookup(line.Item)
+		switch {
+		case item == nil:
+			return nil, ErrItemNotFound
+		case item.ArchivedAt != nil:
+			return nil, ErrItemArchived
+		case item.Stock != nil && *item.Stock < line.Quantity:
+			return nil, ErrOutOfStock
+		}
+		amount := item.Price * line.Quantity
+		order.Items = append(order.Items, models.OrderItem{
+			Item:     item.Name,
+			Quantity: line.Quantity,
+			Price:    item.Price,
+			Amount:   amount,
+		})
+		order.Total += amount
+	}
+	return order, nil
+}
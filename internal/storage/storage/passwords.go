@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// UpdatePasswordHash replaces the hash without touching sessions, e.g. to
+// upgrade it to a stronger scheme.
+func (s *PostgresStorage) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET password_hash = $2 WHERE id = $1",
+		userID, passwordHash,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrUserNotFound)
+}
+
+// ChangePassword sets a new password and revokes all sessions of the user.
+func (s *PostgresStorage) ChangePassword(ctx context.Context, userID int, passwordHash string, now time.Time) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return setPassword(ctx, tx, userID, passwordHash, now)
+	})
+}
+
+// CreatePasswordResetToken stores a reset token, invalidating the user's
+// earlier unused ones.
+func (s *PostgresStorage) CreatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL",
+			token.UserID,
+		); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+			token.TokenHash, token.UserID, token.CreatedAt.UTC(), token.ExpiresAt.UTC(),
+		)
+		return err
+	})
+}
+
+// ResetPassword redeems the reset token, sets the new password and revokes
+// all sessions of its user.
+func (s *PostgresStorage) ResetPassword(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*models.User, error) {
+	var user *models.User
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		user, err = resetPassword(ctx, tx, tokenHash, passwordHash, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func resetPassword(ctx context.Context, tx *sql.Tx, tokenHash, passwordHash string, now time.Time) (*models.User, error) {
+	var userID int
+	err := tx.QueryRowContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = $2
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+        RETURNING user_id`,
+		tokenHash, now.UTC(),
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setPassword(ctx, tx, userID, passwordHash, now); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, username, COALESCE(email, ''), coins FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Coins)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func setPassword(ctx context.Context, tx *sql.Tx, userID int, passwordHash string, now time.Time) error {
+	res, err := tx.ExecContext(ctx,
+		"UPDATE users SET password_hash = $2 WHERE id = $1",
+		userID, passwordHash,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(res, ErrUserNotFound); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL",
+		userID, now.UTC(),
+	)
+	return err
+}
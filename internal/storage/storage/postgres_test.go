@@ -0,0 +1,155 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/storage/storagetest"
+)
+
+// openPostgres connects to the migrated database pointed to by
+// TEST_DATABASE_DSN, skipping the test if it is not set.
+func openPostgres(t *testing.T) (*sql.DB, *storage.PostgresStorage) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Ping())
+
+	store := storage.NewPostgresStorage(db)
+	require.NoError(t, store.Migrate(dsn))
+	return db, store
+}
+
+// TestPostgresStorage runs the conformance suite against a live database.
+// The database pointed to by TEST_DATABASE_DSN is wiped between tests.
+func TestPostgresStorage(t *testing.T) {
+	db, store := openPostgres(t)
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		require.NoError(t, storagetest.ResetPostgres(db))
+		return store
+	})
+}
+
+func TestPostgresLedgerIntegrity(t *testing.T) {
+	db, store := openPostgres(t)
+	require.NoError(t, storagetest.ResetPostgres(db))
+	ctx := context.Background()
+
+	alice, err := store.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	_, err = store.CreateUser(ctx, "bob", "hash")
+	require.NoError(t, err)
+
+	// The database rejects unbalanced transactions at commit.
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	var id int
+	require.NoError(t, tx.QueryRow(
+		"INSERT INTO ledger_transactions (kind, created_at) VALUES ('adjustment', $1) RETURNING id", time.Now(),
+	).Scan(&id))
+	_, err = tx.Exec("INSERT INTO ledger_entries (transaction_id, account, credit) VALUES ($1, 'user:1', 5)", id)
+	require.NoError(t, err)
+	assert.Error(t, tx.Commit())
+
+	// The running balance checked on every posting follows the entries.
+	require.NoError(t, store.SendCoins(ctx, "alice", "bob", 30))
+	var running int
+	require.NoError(t, db.QueryRow(
+		"SELECT balance FROM ledger_balances WHERE account = $1", models.UserAccount(alice.ID),
+	).Scan(&running))
+	summed, err := store.GetLedgerBalance(ctx, models.UserAccount(alice.ID))
+	require.NoError(t, err)
+	assert.Equal(t, 970, summed)
+	assert.Equal(t, summed, running)
+
+	// A cache edited behind the ledger's back blocks further movements.
+	_, err = db.Exec("UPDATE users SET coins = 5000 WHERE id = $1", alice.ID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.SendCoins(ctx, "alice", "bob", 10), storage.ErrBalanceMismatch)
+}
+
+func TestPostgresCorrectBalance(t *testing.T) {
+	db, store := openPostgres(t)
+	require.NoError(t, storagetest.ResetPostgres(db))
+	ctx := context.Background()
+
+	alice, err := store.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	_, err = store.CreateUser(ctx, "bob", "hash")
+	require.NoError(t, err)
+
+	_, err = db.Exec("UPDATE users SET coins = coins - 40 WHERE id = $1", alice.ID)
+	require.NoError(t, err)
+
+	mismatches, err := store.ListBalanceMismatches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.BalanceMismatch{{UserID: alice.ID, Username: "alice", Coins: 960, Ledger: 1000}}, mismatches)
+
+	corrected, err := store.CorrectBalance(ctx, alice.ID, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &models.BalanceMismatch{UserID: alice.ID, Username: "alice", Coins: 960, Ledger: 1000, Corrected: true}, corrected)
+
+	mismatches, err = store.ListBalanceMismatches(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+	balance, err := store.GetLedgerBalance(ctx, models.UserAccount(alice.ID))
+	require.NoError(t, err)
+	assert.Equal(t, 960, balance)
+
+	// With the ledger explaining the balance again, coins move as usual.
+	require.NoError(t, store.SendCoins(ctx, "alice", "bob", 10))
+}
+
+// TestPostgresTransactionTimesAreUTC transfers over a connection in another
+// time zone: the stored time must still fall within UTC filters.
+func TestPostgresTransactionTimesAreUTC(t *testing.T) {
+	db, store := openPostgres(t)
+	require.NoError(t, storagetest.ResetPostgres(db))
+	ctx := context.Background()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if strings.Contains(dsn, "://") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&timezone=Asia/Tokyo"
+		} else {
+			dsn += "?timezone=Asia/Tokyo"
+		}
+	} else {
+		dsn += " timezone=Asia/Tokyo"
+	}
+	tokyo, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { tokyo.Close() })
+	tokyoStore := storage.NewPostgresStorage(tokyo)
+
+	alice, err := store.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	_, err = store.CreateUser(ctx, "bob", "hash")
+	require.NoError(t, err)
+
+	before := time.Now().UTC().Add(-time.Second)
+	require.NoError(t, tokyoStore.SendCoins(ctx, "alice", "bob", 10))
+	after := time.Now().UTC().Add(time.Second)
+
+	txns, err := store.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{From: before, To: after, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, txns, 1)
+	assert.WithinRange(t, txns[0].CreatedAt, before, after)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// RegisterUser creates a user, redeeming the invite code in the same
+// transaction if one is given.
+func (s *PostgresStorage) RegisterUser(ctx context.Context, user models.NewUser, now time.Time) (*models.User, error) {
+	var v validation.Validator
+	v.Username("username", user.Username)
+	v.Email("email", user.Email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	var created *models.User
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		created, err = registerUser(ctx, tx, user, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func registerUser(ctx context.Context, tx *sql.Tx, user models.NewUser, now time.Time) (*models.User, error) {
+	if user.InviteCode != "" {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE invite_codes SET uses = uses + 1
+            WHERE code = $1 AND uses < max_uses AND (expires_at IS NULL OR expires_at > $2)`,
+			user.InviteCode, now.UTC(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := expectAffected(res, ErrInviteCodeInvalid); err != nil {
+			return nil, err
+		}
+	}
+
+	var created models.User
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO users (username, email, password_hash, coins)
+        VALUES ($1, NULLIF($2, ''), $3, 0)
+        RETURNING id, username, COALESCE(email, '')`,
+		user.Username, user.Email, user.PasswordHash,
+	).Scan(&created.ID, &created.Username, &created.Email)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		if pqErr.Constraint == "users_email_key" {
+			return nil, ErrEmailExists
+		}
+		return nil, ErrUserExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := postLedger(ctx, tx, grant(created.ID, now)); err != nil {
+		return nil, err
+	}
+	created.Coins = startingCoins
+	return &created, nil
+}
+
+func (s *PostgresStorage) CreateInviteCode(ctx context.Context, invite models.InviteCode) error {
+	var expiresAt *time.Time
+	if invite.ExpiresAt != nil {
+		t := invite.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO invite_codes (code, max_uses, created_at, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+		invite.Code, invite.MaxUses, invite.CreatedAt.UTC(), expiresAt,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrInviteCodeExists
+	}
+	return err
+}
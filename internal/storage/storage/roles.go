@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// validateGrantableRole rejects the implicit user role and unknown roles.
+func validateGrantableRole(role string) error {
+	var v validation.Validator
+	if v.Required("role", role) && !slices.Contains(models.GrantableRoles, role) {
+		v.Add("role", validation.CodeInvalidFormat, "must be admin or auditor")
+	}
+	return v.Err()
+}
+
+// GetUserRoles returns RoleUser followed by the granted roles in the order
+// of models.GrantableRoles.
+func (s *PostgresStorage) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT role FROM user_roles WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	granted := make(map[string]bool)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		granted[role] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rolesOf(granted), nil
+}
+
+// GrantRole grants role to the user. Granting a role twice is a no-op.
+func (s *PostgresStorage) GrantRole(ctx context.Context, userID int, role string, now time.Time) error {
+	if err := validateGrantableRole(role); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_roles (user_id, role, granted_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, role) DO NOTHING`,
+		userID, role, now.UTC(),
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *PostgresStorage) RevokeRole(ctx context.Context, userID int, role string) error {
+	if err := validateGrantableRole(role); err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role = $2", userID, role)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrRoleNotGranted)
+}
+
+func rolesOf(granted map[string]bool) []string {
+	roles := []string{models.RoleUser}
+	for _, role := range models.GrantableRoles {
+		if granted[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
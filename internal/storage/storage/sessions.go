@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// CreateSession stores a new session together with its first refresh token.
+func (s *PostgresStorage) CreateSession(ctx context.Context, session models.Session, refresh models.RefreshToken) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO sessions (id, user_id, created_at, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+			session.ID, session.UserID, session.CreatedAt.UTC(), session.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO refresh_tokens (session_id, token_hash, created_at, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+			session.ID, refresh.TokenHash, refresh.CreatedAt.UTC(), refresh.ExpiresAt.UTC(),
+		)
+		return err
+	})
+}
+
+// RotateRefreshToken exchanges the token with the given hash for next, which
+// joins the same session. Presenting a token that was already rotated revokes
+// the whole session and yields ErrRefreshTokenReused.
+func (s *PostgresStorage) RotateRefreshToken(ctx context.Context, tokenHash string, next models.RefreshToken) (*models.Session, error) {
+	var session *models.Session
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		session, err = rotateRefreshToken(ctx, tx, tokenHash, next)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		// The session was revoked, which has to be committed.
+		return nil, ErrRefreshTokenReused
+	}
+	return session, nil
+}
+
+// rotateRefreshToken returns a nil session if the token was reused.
+func rotateRefreshToken(ctx context.Context, tx *sql.Tx, tokenHash string, next models.RefreshToken) (*models.Session, error) {
+	var (
+		tokenID        int
+		tokenExpiresAt time.Time
+		usedAt         sql.NullTime
+		revokedAt      sql.NullTime
+		session        models.Session
+	)
+	err := tx.QueryRowContext(ctx,
+		`SELECT rt.id, rt.expires_at, rt.used_at,
+            s.id, s.user_id, u.username, s.created_at, s.expires_at, s.revoked_at
+        FROM refresh_tokens rt
+        JOIN sessions s ON s.id = rt.session_id
+        JOIN users u ON u.id = s.user_id
+        WHERE rt.token_hash = $1
+        FOR UPDATE OF rt, s`,
+		tokenHash,
+	).Scan(&tokenID, &tokenExpiresAt, &usedAt,
+		&session.ID, &session.UserID, &session.Username, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+
+	now := next.CreatedAt.UTC()
+	switch {
+	case revokedAt.Valid:
+		return nil, ErrSessionRevoked
+	case usedAt.Valid:
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = $2 WHERE id = $1",
+			session.ID, now,
+		)
+		return nil, err
+	case !tokenExpiresAt.After(now):
+		return nil, ErrRefreshTokenExpired
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = $2 WHERE id = $1",
+		tokenID, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (session_id, token_hash, created_at, expires_at)
+        VALUES ($1, $2, $3, $4)`,
+		session.ID, next.TokenHash, now, next.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE sessions SET expires_at = $2 WHERE id = $1",
+		session.ID, next.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = next.ExpiresAt
+	return &session, nil
+}
+
+func (s *PostgresStorage) RevokeSession(ctx context.Context, sessionID string, now time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1",
+		sessionID, now.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrSessionNotFound)
+}
+
+// RevokeUserSessions revokes every live session of the user and returns
+// how many were revoked.
+func (s *PostgresStorage) RevokeUserSessions(ctx context.Context, userID int, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL",
+		userID, now.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAccessToken denies the access token with the given jti until it expires.
+func (s *PostgresStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO revoked_access_tokens (jti, expires_at)
+        VALUES ($1, $2)
+        ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt.UTC(),
+	)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token was revoked on its
+// own or through its session. A session that no longer exists counts as revoked.
+func (s *PostgresStorage) IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1)
+            OR NOT EXISTS (SELECT 1 FROM sessions WHERE id = $2 AND revoked_at IS NULL)`,
+		jti, sessionID,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpiredSessions removes sessions, refresh tokens and revoked access
+// tokens that expired before the given time.
+func (s *PostgresStorage) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	err := s.inTx(ctx, func(tx *sql.Tx) (err error) {
+		deleted, err = deleteExpiredSessions(ctx, tx, before)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func deleteExpiredSessions(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM revoked_access_tokens WHERE expires_at <= $1",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM refresh_tokens WHERE expires_at <= $1",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM sessions WHERE expires_at <= $1",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
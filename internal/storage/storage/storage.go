@@ -5,89 +5,214 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
-	"path/filepath"
+	"time"
 
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/lib/pq"
 	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
 )
 
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrInsufficientCoins = errors.New("insufficient coins")
 	ErrItemNotFound      = errors.New("item not found")
+	ErrUserExists        = errors.New("username already exists")
+	ErrItemExists        = errors.New("item already exists")
+	ErrItemArchived      = errors.New("item is archived")
+	ErrOutOfStock        = errors.New("item is out of stock")
+
+	// errNoUser is returned by GetUserByUsername. It matches both
+	// ErrUserNotFound and sql.ErrNoRows for callers checking either.
+	errNoUser = fmt.Errorf("%w: %w", ErrUserNotFound, sql.ErrNoRows)
+
+	ErrIdempotencyKeyExists   = errors.New("idempotency key already exists")
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+	ErrEmailExists       = errors.New("email already registered")
+	ErrInviteCodeInvalid = errors.New("invite code is invalid, expired or used up")
+	ErrInviteCodeExists  = errors.New("invite code already exists")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrSessionRevoked       = errors.New("session revoked")
+
+	ErrResetTokenInvalid = errors.New("password reset token is invalid, expired or used")
+
+	ErrTOTPNotFound        = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeReused      = errors.New("TOTP code was already used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or used")
+
+	ErrRoleNotGranted = errors.New("role is not granted")
+
+	ErrOrderNotFound         = errors.New("order not found")
+	ErrOrderStatusTransition = errors.New("order cannot move to this status")
+
+	// ErrUnbalancedLedger is returned for ledger transactions whose debits
+	// and credits differ, a programming error.
+	ErrUnbalancedLedger = errors.New("ledger transaction is not balanced")
+	// ErrBalanceMismatch is returned when a balance cached in users.coins
+	// no longer matches the ledger.
+	ErrBalanceMismatch = errors.New("cached balance does not match the ledger")
 )
 
 type Storage interface {
-	Migrate(dsn string)
+	Migrate(dsn string) error
+	// InTx runs fn as a unit of work: every operation on the Storage it
+	// is handed joins one transaction, which commits if fn returns nil and
+	// rolls back otherwise. Opts select the isolation level, nil meaning
+	// read committed. The unit is retried on deadlocks and serialization
+	// failures, so fn may run more than once. An operation failing inside
+	// the unit aborts its transaction, so fn should return its error.
+	// Inside a unit, InTx joins the enclosing transaction.
+	InTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Storage) error) error
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
 	CreateUser(ctx context.Context, username, passwordHash string) (*models.User, error)
 	GetUserInventory(ctx context.Context, userID int) ([]models.InventoryItem, error)
 	GetCoinHistory(ctx context.Context, userID int) ([]models.ReceivedTransaction, []models.SentTransaction, error)
+	ListCoinTransactions(ctx context.Context, userID int, filter models.TransactionFilter) ([]models.CoinTransaction, error)
 	SendCoins(ctx context.Context, senderUsername, receiverUsername string, amount int) error
 	BuyItem(ctx context.Context, username, itemName string) error
+
+	CreateIdempotencyRecord(ctx context.Context, record models.IdempotencyRecord) error
+	GetIdempotencyRecord(ctx context.Context, username, key string) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyRecord(ctx context.Context, username, key string, statusCode int, contentType string, body []byte) error
+	DeleteIdempotencyRecord(ctx context.Context, username, key string) error
+	DeleteExpiredIdempotencyRecords(ctx context.Context, now time.Time) (int64, error)
+
+	RegisterUser(ctx context.Context, user models.NewUser, now time.Time) (*models.User, error)
+	CreateInviteCode(ctx context.Context, invite models.InviteCode) error
+
+	CreateSession(ctx context.Context, session models.Session, refresh models.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, tokenHash string, next models.RefreshToken) (*models.Session, error)
+	RevokeSession(ctx context.Context, sessionID string, now time.Time) error
+	RevokeUserSessions(ctx context.Context, userID int, now time.Time) (int64, error)
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error)
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+
+	GetLoginThrottle(ctx context.Context, scope, key string) (*models.LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, scope, key string, now, resetBefore time.Time) (int, error)
+	BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error
+	ResetLoginFailures(ctx context.Context, scope, key string) error
+	DeleteExpiredLoginThrottles(ctx context.Context, before time.Time) (int64, error)
+
+	CreateAuditEvent(ctx context.Context, event models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+
+	UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error
+	ChangePassword(ctx context.Context, userID int, passwordHash string, now time.Time) error
+	CreatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*models.User, error)
+
+	GetTOTP(ctx context.Context, userID int) (*models.TOTP, error)
+	SetPendingTOTP(ctx context.Context, userID int, secret string, now time.Time) error
+	ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string, now time.Time) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error
+	DeleteTOTP(ctx context.Context, userID int) error
+
+	ListItems(ctx context.Context, includeArchived bool) ([]models.Item, error)
+	CreateItem(ctx context.Context, item models.CreateItemRequest) (*models.Item, error)
+	UpdateItem(ctx context.Context, id int, update models.ItemUpdate) (*models.Item, error)
+	RestockItem(ctx context.Context, id, quantity int) (*models.Item, error)
+	ArchiveItem(ctx context.Context, id int, now time.Time) (*models.Item, error)
+
+	CreateOrder(ctx context.Context, username string, lines []models.OrderLine, now time.Time) (*models.Order, error)
+	ListOrders(ctx context.Context, filter models.OrderFilter) ([]models.Order, error)
+	ListPurchases(ctx context.Context, userID int, filter models.PurchaseFilter) ([]models.Purchase, error)
+	SetOrderStatus(ctx context.Context, id int, status string, now time.Time) (*models.Order, error)
+
+	GetLedgerBalance(ctx context.Context, account string) (int, error)
+	ListLedgerTransactions(ctx context.Context, filter models.LedgerFilter) ([]models.LedgerTransaction, error)
+	AdjustCoins(ctx context.Context, userID, amount int, reason string, now time.Time) (int, error)
+	ListBalanceMismatches(ctx context.Context) ([]models.BalanceMismatch, error)
+	CorrectBalance(ctx context.Context, userID int, now time.Time) (*models.BalanceMismatch, error)
+
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+	GrantRole(ctx context.Context, userID int, role string, now time.Time) error
+	RevokeRole(ctx context.Context, userID int, role string) error
 }
 
+var (
+	_ Storage = (*PostgresStorage)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+)
+
 type PostgresStorage struct {
-	db *sql.DB
+	pool *sql.DB
+	// db runs the queries: the pool, or the transaction of the unit of
+	// work the storage was handed to.
+	db dbtx
+	tx *sql.Tx
 }
 
-func NewPostgresStorage(db *sql.DB) *PostgresStorage {
-	return &PostgresStorage{db: db}
+// dbtx is implemented by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func (d *PostgresStorage) Migrate(dsn string) {
-	// Try auto-migration
-	if err := d.autoDefaultMigrate(dsn); err != nil {
-		log.Fatal(err)
-	}
+func NewPostgresStorage(db *sql.DB) *PostgresStorage {
+	return &PostgresStorage{pool: db, db: db}
 }
 
-func (d *PostgresStorage) autoDefaultMigrate(dsn string) error {
-	mpath, err := filepath.Abs(
-		filepath.Join("internal", "storage", "migrations"))
+// Migrate applies all pending migrations.
+func (d *PostgresStorage) Migrate(dsn string) error {
+	migr, err := NewMigrator(dsn)
 	if err != nil {
 		return err
 	}
+	defer migr.Close()
 
-	migr, err := migrate.New(
-		fmt.Sprintf("file://%s", mpath),
-		dsn,
-	)
-	if err != nil {
-		return err
-	}
-	return migr.Up()
+	return migr.Up(0)
 }
 
 func (s *PostgresStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	err := s.db.QueryRowContext(ctx, "SELECT id, username, password_hash, coins FROM users WHERE username = $1", username).
-		Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Coins)
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, COALESCE(email, ''), password_hash, coins FROM users WHERE username = $1", username).
+		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Coins)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errNoUser
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
 func (s *PostgresStorage) CreateUser(ctx context.Context, username, passwordHash string) (*models.User, error) {
-	var user models.User
-	err := s.db.QueryRowContext(ctx,
-		`INSERT INTO users (username, password_hash) 
-        VALUES ($1, $2) 
-        RETURNING id, username, coins`,
-		username, passwordHash,
-	).Scan(&user.ID, &user.Username, &user.Coins)
-
-	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-		return nil, fmt.Errorf("username already exists")
+	var v validation.Validator
+	v.Username("username", username)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
+
+	// The starting coins are granted through the ledger.
+	var user models.User
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO users (username, password_hash, coins) 
+        VALUES ($1, $2, 0) 
+        RETURNING id, username`,
+			username, passwordHash,
+		).Scan(&user.ID, &user.Username)
+
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrUserExists
+		}
+		if err != nil {
+			return err
+		}
+		return postLedger(ctx, tx, grant(user.ID, time.Now()))
+	})
 	if err != nil {
 		return nil, err
 	}
+	user.Coins = startingCoins
 	return &user, nil
 }
 
@@ -95,7 +220,8 @@ func (s *PostgresStorage) GetUserInventory(ctx context.Context, userID int) ([]m
 	rows, err := s.db.QueryContext(ctx,
 		`SELECT m.name, COALESCE(ui.quantity, 0) 
         FROM merch_items m
-        LEFT JOIN user_inventory ui ON m.id = ui.item_id AND ui.user_id = $1`,
+        LEFT JOIN user_inventory ui ON m.id = ui.item_id AND ui.user_id = $1
+        ORDER BY m.id`,
 		userID,
 	)
 	if err != nil {
@@ -122,7 +248,8 @@ func (s *PostgresStorage) GetCoinHistory(ctx context.Context, userID int) ([]mod
 		`SELECT u.username, ct.amount 
         FROM coin_transactions ct
         JOIN users u ON ct.sender_id = u.id
-        WHERE ct.receiver_id = $1`,
+        WHERE ct.receiver_id = $1
+        ORDER BY ct.id`,
 		userID,
 	)
 	if err != nil {
@@ -144,7 +271,8 @@ func (s *PostgresStorage) GetCoinHistory(ctx context.Context, userID int) ([]mod
 		`SELECT u.username, ct.amount 
         FROM coin_transactions ct
         JOIN users u ON ct.receiver_id = u.id
-        WHERE ct.sender_id = $1`,
+        WHERE ct.sender_id = $1
+        ORDER BY ct.id`,
 		userID,
 	)
 	if err != nil {
@@ -164,130 +292,124 @@ func (s *PostgresStorage) GetCoinHistory(ctx context.Context, userID int) ([]mod
 	return received, sent, nil
 }
 
-func (s *PostgresStorage) SendCoins(ctx context.Context, senderUsername, receiverUsername string, amount int) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	// Получаем ID отправителя и проверяем баланс
-	var senderID, senderCoins int
-	err = tx.QueryRowContext(ctx,
-		"SELECT id, coins FROM users WHERE username = $1 FOR UPDATE",
-		senderUsername,
-	).Scan(&senderID, &senderCoins)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrUserNotFound
-		}
-		return err
+func (s *PostgresStorage) ListCoinTransactions(ctx context.Context, userID int, filter models.TransactionFilter) ([]models.CoinTransaction, error) {
+	query := `SELECT ct.id, ct.amount, ct.created_at,
+            CASE WHEN ct.sender_id = $1 THEN 'sent' ELSE 'received' END,
+            u.username
+        FROM coin_transactions ct
+        JOIN users u ON u.id = CASE WHEN ct.sender_id = $1 THEN ct.receiver_id ELSE ct.sender_id END
+        WHERE (ct.sender_id = $1 OR ct.receiver_id = $1)`
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
 	}
 
-	if senderCoins < amount {
-		return ErrInsufficientCoins
+	switch filter.Direction {
+	case models.DirectionSent:
+		query += " AND ct.sender_id = $1"
+	case models.DirectionReceived:
+		query += " AND ct.receiver_id = $1 AND ct.sender_id <> $1"
 	}
-
-	// Получаем ID получателя
-	var receiverID int
-	err = tx.QueryRowContext(ctx,
-		"SELECT id FROM users WHERE username = $1 FOR UPDATE",
-		receiverUsername,
-	).Scan(&receiverID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrUserNotFound
-		}
-		return err
+	if filter.Counterparty != "" {
+		query += " AND u.username = " + arg(filter.Counterparty)
 	}
-
-	// Обновляем балансы
-	_, err = tx.ExecContext(ctx,
-		"UPDATE users SET coins = coins - $1 WHERE id = $2",
-		amount, senderID,
-	)
-	if err != nil {
-		return err
+	if !filter.From.IsZero() {
+		query += " AND ct.created_at >= " + arg(filter.From.UTC())
 	}
-
-	_, err = tx.ExecContext(ctx,
-		"UPDATE users SET coins = coins + $1 WHERE id = $2",
-		amount, receiverID,
-	)
-	if err != nil {
-		return err
+	if !filter.To.IsZero() {
+		query += " AND ct.created_at < " + arg(filter.To.UTC())
+	}
+	if filter.After != nil {
+		query += fmt.Sprintf(" AND (ct.created_at, ct.id) < (%s, %s)",
+			arg(filter.After.CreatedAt.UTC()), arg(filter.After.ID))
+	}
+	query += " ORDER BY ct.created_at DESC, ct.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
 	}
 
-	// Записываем транзакцию
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO coin_transactions (sender_id, receiver_id, amount) 
-        VALUES ($1, $2, $3)`,
-		senderID, receiverID, amount,
-	)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return tx.Commit()
+	var transactions []models.CoinTransaction
+	for rows.Next() {
+		var t models.CoinTransaction
+		if err := rows.Scan(&t.ID, &t.Amount, &t.CreatedAt, &t.Direction, &t.Counterparty); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
 }
 
-func (s *PostgresStorage) BuyItem(ctx context.Context, username, itemName string) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
+// SendCoins locks both users in ID order, so that opposite transfers
+// between the same users cannot deadlock, and retries the transfer if
+// PostgreSQL aborts it anyway.
+func (s *PostgresStorage) SendCoins(ctx context.Context, senderUsername, receiverUsername string, amount int) error {
+	if err := validation.Transfer(senderUsername, receiverUsername, amount); err != nil {
 		return err
 	}
-	defer tx.Rollback()
-
-	// Получаем информацию о товаре
-	var itemID, price int
-	err = tx.QueryRowContext(ctx,
-		"SELECT id, price FROM merch_items WHERE name = $1",
-		itemName,
-	).Scan(&itemID, &price)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrItemNotFound
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			"SELECT id, username, coins FROM users WHERE username IN ($1, $2) ORDER BY id FOR UPDATE",
+			senderUsername, receiverUsername,
+		)
+		if err != nil {
+			return err
+		}
+		var sender, receiver *models.User
+		for rows.Next() {
+			var user models.User
+			if err := rows.Scan(&user.ID, &user.Username, &user.Coins); err != nil {
+				rows.Close()
+				return err
+			}
+			if user.Username == senderUsername {
+				sender = &user
+			} else {
+				receiver = &user
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
 		}
-		return err
-	}
 
-	// Получаем данные пользователя
-	var userID, userCoins int
-	err = tx.QueryRowContext(ctx,
-		"SELECT id, coins FROM users WHERE username = $1 FOR UPDATE",
-		username,
-	).Scan(&userID, &userCoins)
-	if err != nil {
-		if err == sql.ErrNoRows {
+		if sender == nil {
+			return ErrUserNotFound
+		}
+		if sender.Coins < amount {
+			return ErrInsufficientCoins
+		}
+		if receiver == nil {
 			return ErrUserNotFound
 		}
-		return err
-	}
-
-	if userCoins < price {
-		return ErrInsufficientCoins
-	}
 
-	// Обновляем баланс
-	_, err = tx.ExecContext(ctx,
-		"UPDATE users SET coins = coins - $1 WHERE id = $2",
-		price, userID,
-	)
-	if err != nil {
-		return err
-	}
+		// The time is set here rather than by the column default, which
+		// follows the session time zone, as filters compare it in UTC.
+		var id int
+		createdAt := time.Now().UTC().Truncate(time.Microsecond)
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO coin_transactions (sender_id, receiver_id, amount, created_at)
+            VALUES ($1, $2, $3, $4)
+            RETURNING id`,
+			sender.ID, receiver.ID, amount, createdAt,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
 
-	// Обновляем инвентарь
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO user_inventory (user_id, item_id, quantity) 
-        VALUES ($1, $2, 1)
-        ON CONFLICT (user_id, item_id) 
-        DO UPDATE SET quantity = user_inventory.quantity + 1`,
-		userID, itemID,
-	)
-	if err != nil {
-		return err
-	}
+		return postLedger(ctx, tx, transfer(id, sender.ID, receiver.ID, amount, createdAt))
+	})
+}
 
-	return tx.Commit()
+// BuyItem buys one unit of an item, as an order of a single line.
+func (s *PostgresStorage) BuyItem(ctx context.Context, username, itemName string) error {
+	_, err := s.CreateOrder(ctx, username, []models.OrderLine{{Item: itemName, Quantity: 1}}, time.Now())
+	return err
 }
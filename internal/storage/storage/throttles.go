@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+// GetLoginThrottle returns the failure counter for the scope and key, or an
+// empty one if there were no failures.
+func (s *PostgresStorage) GetLoginThrottle(ctx context.Context, scope, key string) (*models.LoginThrottle, error) {
+	throttle := models.LoginThrottle{Scope: scope, Key: key}
+	var blockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT failures, last_failure_at, blocked_until, locked
+        FROM login_throttles
+        WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&throttle.Failures, &throttle.LastFailureAt, &blockedUntil, &throttle.Locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &throttle, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if blockedUntil.Valid {
+		throttle.BlockedUntil = &blockedUntil.Time
+	}
+	return &throttle, nil
+}
+
+// RecordLoginFailure increments the failure counter and returns its new
+// value. Counters whose last failure is before resetBefore start over.
+func (s *PostgresStorage) RecordLoginFailure(ctx context.Context, scope, key string, now, resetBefore time.Time) (int, error) {
+	var failures int
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO login_throttles (scope, key, failures, last_failure_at)
+        VALUES ($1, $2, 1, $3)
+        ON CONFLICT (scope, key) DO UPDATE
+        SET failures = CASE WHEN login_throttles.last_failure_at < $4 THEN 1
+                ELSE login_throttles.failures + 1 END,
+            last_failure_at = EXCLUDED.last_failure_at
+        RETURNING failures`,
+		scope, key, now.UTC(), resetBefore.UTC(),
+	).Scan(&failures)
+	return failures, err
+}
+
+func (s *PostgresStorage) BlockLogin(ctx context.Context, scope, key string, until time.Time, locked bool) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE login_throttles SET blocked_until = $3, locked = $4
+        WHERE scope = $1 AND key = $2`,
+		scope, key, until.UTC(), locked,
+	)
+	return err
+}
+
+// ResetLoginFailures clears the counter and any block, e.g. after a
+// successful login or when an admin unlocks an account.
+func (s *PostgresStorage) ResetLoginFailures(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM login_throttles WHERE scope = $1 AND key = $2",
+		scope, key,
+	)
+	return err
+}
+
+// DeleteExpiredLoginThrottles removes counters that are no longer blocked
+// and whose last failure is before the given time.
+func (s *PostgresStorage) DeleteExpiredLoginThrottles(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM login_throttles
+        WHERE last_failure_at < $1 AND (blocked_until IS NULL OR blocked_until < $1)`,
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+)
+
+func (s *PostgresStorage) GetTOTP(ctx context.Context, userID int) (*models.TOTP, error) {
+	totp := models.TOTP{UserID: userID}
+	var confirmedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT secret, created_at, confirmed_at, last_used_step FROM user_totp WHERE user_id = $1",
+		userID,
+	).Scan(&totp.Secret, &totp.CreatedAt, &confirmedAt, &totp.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTOTPNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+	return &totp, nil
+}
+
+// SetPendingTOTP stores a new unconfirmed secret, replacing an earlier
+// unconfirmed one. It fails with ErrTOTPEnabled if 2FA is already on.
+func (s *PostgresStorage) SetPendingTOTP(ctx context.Context, userID int, secret string, now time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_totp (user_id, secret, created_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = 0
+        WHERE user_totp.confirmed_at IS NULL`,
+		userID, secret, now.UTC(),
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrTOTPEnabled)
+}
+
+// ConfirmTOTP enables 2FA with the pending secret, marking step as used,
+// and replaces the user's recovery codes.
+func (s *PostgresStorage) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryCodeHashes []string, now time.Time) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE user_totp SET confirmed_at = $2, last_used_step = $3
+        WHERE user_id = $1 AND confirmed_at IS NULL`,
+			userID, now.UTC(), step,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectAffected(res, ErrTOTPNotFound); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+				userID, hash,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseTOTPStep records that a code for step was accepted. It fails with
+// ErrTOTPCodeReused unless step is later than the last accepted one.
+func (s *PostgresStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE user_totp SET last_used_step = $2
+        WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrTOTPCodeReused)
+}
+
+func (s *PostgresStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = $3
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash, now.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res, ErrRecoveryCodeInvalid)
+}
+
+// DeleteTOTP turns 2FA off and drops the recovery codes.
+func (s *PostgresStorage) DeleteTOTP(ctx context.Context, userID int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+		if err := expectAffected(res, ErrTOTPNotFound); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+		return err
+	})
+}
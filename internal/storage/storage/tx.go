@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A transaction that failed with a deadlock or a serialization failure was
+// rolled back as a whole and can simply run again.
+const (
+	maxTxAttempts   = 5
+	txRetryDelay    = 10 * time.Millisecond
+	maxTxRetryDelay = 200 * time.Millisecond
+)
+
+// InTx hands fn a storage whose operations run in one transaction.
+func (s *PostgresStorage) InTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return inTx(ctx, s.pool, opts, func(tx *sql.Tx) error {
+		return fn(&PostgresStorage{pool: s.pool, db: tx, tx: tx})
+	})
+}
+
+// inTx runs an operation that needs a transaction: in the unit of work s
+// belongs to, or else in a transaction of its own.
+func (s *PostgresStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return inTx(ctx, s.pool, nil, fn)
+}
+
+// inTx runs fn in a transaction and commits it, retrying deadlocks and
+// serialization failures with exponential backoff until maxTxAttempts or
+// until ctx is done. As fn may run more than once, it must not change
+// anything outside tx besides its results.
+func inTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	delay := txRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		// Full jitter keeps the transactions that collided from colliding again.
+		timer := time.NewTimer(rand.N(delay) + 1)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		delay = min(2*delay, maxTxRetryDelay)
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isRetryable reports whether err is a deadlock (40P01) or a serialization
+// failure (40001).
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == "40P01" || pqErr.Code == "40001")
+}
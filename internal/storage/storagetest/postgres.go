@@ -0,0 +1,29 @@
+package storagetest
+
+import "database/sql"
+
+// ResetPostgres empties a migrated database, leaving only the merch
+// catalog seeded by the migrations.
+func ResetPostgres(db *sql.DB) error {
+	_, err := db.Exec(`
+TRUNCATE users, user_inventory, coin_transactions, idempotency_keys, sessions, refresh_tokens,
+    revoked_access_tokens, invite_codes, login_throttles, audit_events, password_reset_tokens,
+    user_totp, recovery_codes, user_roles, order_items, orders, merch_items,
+    ledger_entries, ledger_transactions, ledger_balances
+    RESTART IDENTITY CASCADE;
+
+INSERT INTO merch_items (name, price)
+VALUES
+    ('t-shirt', 80),
+    ('cup', 20),
+    ('book', 50),
+    ('pen', 10),
+    ('powerbank', 200),
+    ('hoody', 300),
+    ('umbrella', 200),
+    ('socks', 10),
+    ('wallet', 50),
+    ('pink-hoody', 500);
+`)
+	return err
+}
@@ -0,0 +1,1627 @@
+// Package storagetest provides a conformance suite that every
+// storage.Storage implementation is expected to pass.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/storage/storage"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+// Factory returns an empty storage with the default merch catalog.
+// It is called once per test; cleanup should be registered on t.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs the whole conformance suite against storages produced by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"CreateUser", testCreateUser},
+		{"GetUserByUsername", testGetUserByUsername},
+		{"SendCoins", testSendCoins},
+		{"SendCoinsErrors", testSendCoinsErrors},
+		{"SendCoinsValidation", testSendCoinsValidation},
+		{"CoinHistory", testCoinHistory},
+		{"ListCoinTransactions", testListCoinTransactions},
+		{"ListCoinTransactionsPagination", testListCoinTransactionsPagination},
+		{"BuyItem", testBuyItem},
+		{"BuyItemErrors", testBuyItemErrors},
+		{"Inventory", testInventory},
+		{"ConcurrentSendCoins", testConcurrentSendCoins},
+		{"ConcurrentOpposingTransfers", testConcurrentOpposingTransfers},
+		{"ConcurrentBuyItem", testConcurrentBuyItem},
+		{"IdempotencyRecords", testIdempotencyRecords},
+		{"IdempotencyRecordsExpiry", testIdempotencyRecordsExpiry},
+		{"RegisterUser", testRegisterUser},
+		{"RegisterUserWithInvite", testRegisterUserWithInvite},
+		{"SessionRefresh", testSessionRefresh},
+		{"SessionRefreshReuse", testSessionRefreshReuse},
+		{"SessionRevocation", testSessionRevocation},
+		{"SessionExpiry", testSessionExpiry},
+		{"LoginThrottles", testLoginThrottles},
+		{"AuditEvents", testAuditEvents},
+		{"ChangePassword", testChangePassword},
+		{"ResetPassword", testResetPassword},
+		{"TwoFactor", testTwoFactor},
+		{"Roles", testRoles},
+		{"Catalog", testCatalog},
+		{"BuyArchivedItem", testBuyArchivedItem},
+		{"ItemStock", testItemStock},
+		{"ConcurrentBuyLimitedItem", testConcurrentBuyLimitedItem},
+		{"CreateOrder", testCreateOrder},
+		{"CreateOrderErrors", testCreateOrderErrors},
+		{"ListOrders", testListOrders},
+		{"ListPurchases", testListPurchases},
+		{"OrderLifecycle", testOrderLifecycle},
+		{"CancelOrder", testCancelOrder},
+		{"Ledger", testLedger},
+		{"AdjustCoins", testAdjustCoins},
+		{"BalanceMismatches", testBalanceMismatches},
+		{"UnitOfWork", testUnitOfWork},
+		{"UnitOfWorkOutsideWrites", testUnitOfWorkOutsideWrites},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s storage.Storage, username string) *models.User {
+	t.Helper()
+	user, err := s.CreateUser(context.Background(), username, "hash-"+username)
+	require.NoError(t, err)
+	return user
+}
+
+func balance(t *testing.T, s storage.Storage, username string) int {
+	t.Helper()
+	user, err := s.GetUserByUsername(context.Background(), username)
+	require.NoError(t, err)
+	return user.Coins
+}
+
+func testCreateUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	user, err := s.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	assert.NotZero(t, user.ID)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, 1000, user.Coins)
+
+	other, err := s.CreateUser(ctx, "bob", "hash")
+	require.NoError(t, err)
+	assert.NotEqual(t, user.ID, other.ID)
+
+	_, err = s.CreateUser(ctx, "alice", "another-hash")
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	for _, username := range []string{"", "with space", strings.Repeat("u", 256)} {
+		_, err = s.CreateUser(ctx, username, "hash")
+		assert.ErrorIs(t, err, validation.ErrInvalid, "username %q", username)
+	}
+}
+
+func testGetUserByUsername(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	created := createUser(t, s, "alice")
+
+	user, err := s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, user.ID)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "hash-alice", user.PasswordHash)
+	assert.Equal(t, 1000, user.Coins)
+
+	_, err = s.GetUserByUsername(ctx, "nobody")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testSendCoins(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	createUser(t, s, "alice")
+	createUser(t, s, "bob")
+
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 300))
+	assert.Equal(t, 700, balance(t, s, "alice"))
+	assert.Equal(t, 1300, balance(t, s, "bob"))
+
+	// Sending the whole balance is allowed.
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 700))
+	assert.Equal(t, 0, balance(t, s, "alice"))
+	assert.Equal(t, 2000, balance(t, s, "bob"))
+}
+
+func testSendCoinsErrors(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+
+	assert.ErrorIs(t, s.SendCoins(ctx, "alice", "bob", 1001), storage.ErrInsufficientCoins)
+	assert.ErrorIs(t, s.SendCoins(ctx, "nobody", "bob", 10), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.SendCoins(ctx, "alice", "nobody", 10), storage.ErrUserNotFound)
+
+	// Failed transfers must not move coins or leave history behind.
+	assert.Equal(t, 1000, balance(t, s, "alice"))
+	assert.Equal(t, 1000, balance(t, s, "bob"))
+
+	received, sent, err := s.GetCoinHistory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, received)
+	assert.Empty(t, sent)
+}
+
+func testSendCoinsValidation(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+
+	assert.ErrorIs(t, s.SendCoins(ctx, "alice", "bob", 0), validation.ErrInvalid)
+	assert.ErrorIs(t, s.SendCoins(ctx, "alice", "bob", -100), validation.ErrInvalid)
+	assert.ErrorIs(t, s.SendCoins(ctx, "alice", "alice", 100), validation.ErrInvalid)
+
+	assert.Equal(t, 1000, balance(t, s, "alice"))
+	assert.Equal(t, 1000, balance(t, s, "bob"))
+
+	received, sent, err := s.GetCoinHistory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, received)
+	assert.Empty(t, sent)
+}
+
+func testCoinHistory(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 10))
+	require.NoError(t, s.SendCoins(ctx, "bob", "alice", 20))
+	require.NoError(t, s.SendCoins(ctx, "alice", "carol", 30))
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 40))
+
+	received, sent, err := s.GetCoinHistory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ReceivedTransaction{
+		{FromUser: "bob", Amount: 20},
+	}, received)
+	assert.Equal(t, []models.SentTransaction{
+		{ToUser: "bob", Amount: 10},
+		{ToUser: "carol", Amount: 30},
+		{ToUser: "bob", Amount: 40},
+	}, sent)
+
+	received, sent, err = s.GetCoinHistory(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ReceivedTransaction{
+		{FromUser: "alice", Amount: 10},
+		{FromUser: "alice", Amount: 40},
+	}, received)
+	assert.Equal(t, []models.SentTransaction{
+		{ToUser: "alice", Amount: 20},
+	}, sent)
+
+	received, sent, err = s.GetCoinHistory(ctx, carol.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ReceivedTransaction{
+		{FromUser: "alice", Amount: 30},
+	}, received)
+	assert.Empty(t, sent)
+}
+
+func testListCoinTransactions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+	createUser(t, s, "carol")
+
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 10))
+	require.NoError(t, s.SendCoins(ctx, "bob", "alice", 20))
+	require.NoError(t, s.SendCoins(ctx, "alice", "carol", 30))
+	require.NoError(t, s.SendCoins(ctx, "bob", "carol", 99))
+
+	summary := func(txs []models.CoinTransaction) []string {
+		var out []string
+		for _, tx := range txs {
+			out = append(out, fmt.Sprintf("%s %s %d", tx.Direction, tx.Counterparty, tx.Amount))
+		}
+		return out
+	}
+
+	all, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sent carol 30", "received bob 20", "sent bob 10"}, summary(all))
+	for i, tx := range all {
+		assert.NotZero(t, tx.ID)
+		assert.False(t, tx.CreatedAt.IsZero())
+		if i > 0 {
+			assert.False(t, tx.CreatedAt.After(all[i-1].CreatedAt), "transactions must be newest first")
+		}
+	}
+
+	sent, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{Direction: models.DirectionSent})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sent carol 30", "sent bob 10"}, summary(sent))
+
+	received, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{Direction: models.DirectionReceived})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"received bob 20"}, summary(received))
+
+	withBob, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{Counterparty: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"received bob 20", "sent bob 10"}, summary(withBob))
+
+	limited, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sent carol 30", "received bob 20"}, summary(limited))
+
+	future, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{From: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, future)
+
+	past, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{To: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, past)
+
+	oldest := all[len(all)-1]
+	ranged, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{
+		From: oldest.CreatedAt,
+		To:   oldest.CreatedAt.Add(time.Microsecond),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, ranged)
+	assert.Equal(t, oldest.ID, ranged[len(ranged)-1].ID)
+}
+
+func testListCoinTransactionsPagination(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+
+	for i := 1; i <= 7; i++ {
+		require.NoError(t, s.SendCoins(ctx, "alice", "bob", i))
+	}
+
+	var (
+		amounts []int
+		cursor  *models.TransactionCursor
+	)
+	for page := 0; page < 10; page++ {
+		txs, err := s.ListCoinTransactions(ctx, alice.ID, models.TransactionFilter{After: cursor, Limit: 3})
+		require.NoError(t, err)
+		if len(txs) == 0 {
+			break
+		}
+		for _, tx := range txs {
+			amounts = append(amounts, tx.Amount)
+		}
+		last := txs[len(txs)-1]
+		cursor = &models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	assert.Equal(t, []int{7, 6, 5, 4, 3, 2, 1}, amounts)
+}
+
+func testBuyItem(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	createUser(t, s, "alice")
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "t-shirt"))
+	assert.Equal(t, 920, balance(t, s, "alice"))
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "pink-hoody"))
+	assert.Equal(t, 420, balance(t, s, "alice"))
+}
+
+func testBuyItemErrors(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+
+	assert.ErrorIs(t, s.BuyItem(ctx, "alice", "yacht"), storage.ErrItemNotFound)
+	assert.ErrorIs(t, s.BuyItem(ctx, "nobody", "cup"), storage.ErrUserNotFound)
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "pink-hoody"))
+	require.NoError(t, s.BuyItem(ctx, "alice", "pink-hoody"))
+	assert.ErrorIs(t, s.BuyItem(ctx, "alice", "pen"), storage.ErrInsufficientCoins)
+	assert.Equal(t, 0, balance(t, s, "alice"))
+
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "pink-hoody", Quantity: 2}}, inventory)
+}
+
+func testInventory(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, inventory)
+
+	for _, item := range []string{"cup", "pen", "cup", "t-shirt", "cup"} {
+		require.NoError(t, s.BuyItem(ctx, "alice", item))
+	}
+	require.NoError(t, s.BuyItem(ctx, "bob", "socks"))
+
+	// Items are aggregated per type and listed in catalog order.
+	inventory, err = s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{
+		{Type: "t-shirt", Quantity: 1},
+		{Type: "cup", Quantity: 3},
+		{Type: "pen", Quantity: 1},
+	}, inventory)
+
+	inventory, err = s.GetUserInventory(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "socks", Quantity: 1}}, inventory)
+}
+
+func testConcurrentSendCoins(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	createUser(t, s, "alice")
+	createUser(t, s, "bob")
+
+	const workers = 30
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.SendCoins(ctx, "alice", "bob", 100)
+			if err != nil {
+				assert.ErrorIs(t, err, storage.ErrInsufficientCoins)
+				return
+			}
+			mu.Lock()
+			succeeded++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, succeeded)
+	assert.Equal(t, 0, balance(t, s, "alice"))
+	assert.Equal(t, 2000, balance(t, s, "bob"))
+}
+
+// testConcurrentOpposingTransfers sends coins back and forth between every
+// pair of users at once, which deadlocks unless users are locked in a
+// consistent order. No coins may appear or vanish.
+func testConcurrentOpposingTransfers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	users := []*models.User{
+		createUser(t, s, "alice"),
+		createUser(t, s, "bob"),
+		createUser(t, s, "carol"),
+		createUser(t, s, "dave"),
+	}
+
+	const (
+		workers   = 24
+		transfers = 20
+	)
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		net = make(map[string]int)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < transfers; j++ {
+				from := users[(i+j)%len(users)].Username
+				to := users[(i+j+1+i%(len(users)-1))%len(users)].Username
+				if i%2 == 1 {
+					from, to = to, from
+				}
+				amount := 1 + (i*transfers+j)%50
+
+				if err := s.SendCoins(ctx, from, to, amount); err != nil {
+					assert.ErrorIs(t, err, storage.ErrInsufficientCoins)
+					continue
+				}
+				mu.Lock()
+				net[from] -= amount
+				net[to] += amount
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, user := range users {
+		coins := balance(t, s, user.Username)
+		assert.Equal(t, 1000+net[user.Username], coins, "%s's balance", user.Username)
+		total += coins
+	}
+	assert.Equal(t, 1000*len(users), total)
+	ledgerBalances(t, s, users...)
+}
+
+func testConcurrentBuyItem(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+
+	const workers = 15
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.BuyItem(ctx, "alice", "powerbank"); err != nil {
+				assert.ErrorIs(t, err, storage.ErrInsufficientCoins)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, balance(t, s, "alice"))
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "powerbank", Quantity: 5}}, inventory)
+}
+
+func testIdempotencyRecords(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	record := models.IdempotencyRecord{
+		Username:    "alice",
+		Key:         "key-1",
+		RequestHash: "hash",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	_, err := s.GetIdempotencyRecord(ctx, "alice", "key-1")
+	assert.ErrorIs(t, err, storage.ErrIdempotencyKeyNotFound)
+	assert.ErrorIs(t, s.CompleteIdempotencyRecord(ctx, "alice", "key-1", 200, "", nil), storage.ErrIdempotencyKeyNotFound)
+
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, record))
+	assert.ErrorIs(t, s.CreateIdempotencyRecord(ctx, record), storage.ErrIdempotencyKeyExists)
+
+	// Keys are scoped per user.
+	other := record
+	other.Username = "bob"
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, other))
+
+	stored, err := s.GetIdempotencyRecord(ctx, "alice", "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", stored.RequestHash)
+	assert.Zero(t, stored.StatusCode)
+	assert.Empty(t, stored.Body)
+	assert.True(t, stored.ExpiresAt.Equal(record.ExpiresAt))
+
+	require.NoError(t, s.CompleteIdempotencyRecord(ctx, "alice", "key-1", 400, "application/json", []byte(`{"error":"x"}`)))
+	stored, err = s.GetIdempotencyRecord(ctx, "alice", "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, 400, stored.StatusCode)
+	assert.Equal(t, "application/json", stored.ContentType)
+	assert.Equal(t, []byte(`{"error":"x"}`), stored.Body)
+
+	require.NoError(t, s.DeleteIdempotencyRecord(ctx, "alice", "key-1"))
+	_, err = s.GetIdempotencyRecord(ctx, "alice", "key-1")
+	assert.ErrorIs(t, err, storage.ErrIdempotencyKeyNotFound)
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, record))
+
+	_, err = s.GetIdempotencyRecord(ctx, "bob", "key-1")
+	assert.NoError(t, err)
+}
+
+func testIdempotencyRecordsExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	expired := models.IdempotencyRecord{
+		Username:    "alice",
+		Key:         "old",
+		RequestHash: "old-hash",
+		CreatedAt:   now.Add(-2 * time.Hour),
+		ExpiresAt:   now.Add(-time.Hour),
+	}
+	live := models.IdempotencyRecord{
+		Username:    "alice",
+		Key:         "new",
+		RequestHash: "new-hash",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, expired))
+	require.NoError(t, s.CompleteIdempotencyRecord(ctx, "alice", "old", 200, "", []byte("done")))
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, live))
+
+	// An expired key can be reused and starts from scratch.
+	reused := expired
+	reused.RequestHash = "reused-hash"
+	reused.CreatedAt = now
+	reused.ExpiresAt = now.Add(time.Hour)
+	require.NoError(t, s.CreateIdempotencyRecord(ctx, reused))
+
+	stored, err := s.GetIdempotencyRecord(ctx, "alice", "old")
+	require.NoError(t, err)
+	assert.Equal(t, "reused-hash", stored.RequestHash)
+	assert.Zero(t, stored.StatusCode)
+	assert.Empty(t, stored.Body)
+
+	deleted, err := s.DeleteExpiredIdempotencyRecords(ctx, now.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	deleted, err = s.DeleteExpiredIdempotencyRecords(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	_, err = s.GetIdempotencyRecord(ctx, "alice", "new")
+	assert.ErrorIs(t, err, storage.ErrIdempotencyKeyNotFound)
+}
+
+func newSession(t *testing.T, s storage.Storage, user *models.User, id string, now time.Time) models.RefreshToken {
+	t.Helper()
+	session := models.Session{
+		ID:        id,
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	refresh := models.RefreshToken{
+		SessionID: id,
+		TokenHash: id + "-refresh-0",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, s.CreateSession(context.Background(), session, refresh))
+	return refresh
+}
+
+func testSessionRefresh(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	first := newSession(t, s, alice, "s1", now)
+
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti-1", "s1")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	next := models.RefreshToken{TokenHash: "s1-refresh-1", CreatedAt: now.Add(time.Minute), ExpiresAt: now.Add(2 * time.Hour)}
+	session, err := s.RotateRefreshToken(ctx, first.TokenHash, next)
+	require.NoError(t, err)
+	assert.Equal(t, "s1", session.ID)
+	assert.Equal(t, alice.ID, session.UserID)
+	assert.Equal(t, "alice", session.Username)
+	assert.True(t, session.ExpiresAt.Equal(next.ExpiresAt))
+
+	_, err = s.RotateRefreshToken(ctx, "unknown", next)
+	assert.ErrorIs(t, err, storage.ErrRefreshTokenNotFound)
+
+	expired := models.RefreshToken{TokenHash: "s1-refresh-2", CreatedAt: now.Add(3 * time.Hour), ExpiresAt: now.Add(4 * time.Hour)}
+	_, err = s.RotateRefreshToken(ctx, next.TokenHash, expired)
+	assert.ErrorIs(t, err, storage.ErrRefreshTokenExpired)
+}
+
+func testSessionRefreshReuse(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	first := newSession(t, s, alice, "s1", now)
+	other := newSession(t, s, alice, "s2", now)
+
+	second := models.RefreshToken{TokenHash: "s1-refresh-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	_, err := s.RotateRefreshToken(ctx, first.TokenHash, second)
+	require.NoError(t, err)
+
+	// Replaying a rotated token revokes the whole session, including the
+	// token it was rotated into, but leaves other sessions alone.
+	replay := models.RefreshToken{TokenHash: "s1-refresh-2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	_, err = s.RotateRefreshToken(ctx, first.TokenHash, replay)
+	assert.ErrorIs(t, err, storage.ErrRefreshTokenReused)
+
+	_, err = s.RotateRefreshToken(ctx, second.TokenHash, replay)
+	assert.ErrorIs(t, err, storage.ErrSessionRevoked)
+
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti-1", "s1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = s.RotateRefreshToken(ctx, other.TokenHash, models.RefreshToken{TokenHash: "s2-refresh-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+}
+
+func testSessionRevocation(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	newSession(t, s, alice, "a1", now)
+	newSession(t, s, alice, "a2", now)
+	newSession(t, s, alice, "a3", now)
+	newSession(t, s, bob, "b1", now)
+
+	isRevoked := func(jti, sessionID string) bool {
+		t.Helper()
+		revoked, err := s.IsAccessTokenRevoked(ctx, jti, sessionID)
+		require.NoError(t, err)
+		return revoked
+	}
+
+	require.NoError(t, s.RevokeAccessToken(ctx, "jti-1", now.Add(time.Hour)))
+	require.NoError(t, s.RevokeAccessToken(ctx, "jti-1", now.Add(time.Hour)))
+	assert.True(t, isRevoked("jti-1", "a1"))
+	assert.False(t, isRevoked("jti-2", "a1"))
+	assert.True(t, isRevoked("jti-2", "unknown"))
+
+	require.NoError(t, s.RevokeSession(ctx, "a1", now))
+	require.NoError(t, s.RevokeSession(ctx, "a1", now))
+	assert.ErrorIs(t, s.RevokeSession(ctx, "unknown", now), storage.ErrSessionNotFound)
+	assert.True(t, isRevoked("jti-2", "a1"))
+	assert.False(t, isRevoked("jti-2", "a2"))
+
+	revoked, err := s.RevokeUserSessions(ctx, alice.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revoked)
+	assert.True(t, isRevoked("jti-2", "a2"))
+	assert.True(t, isRevoked("jti-2", "a3"))
+	assert.False(t, isRevoked("jti-2", "b1"))
+}
+
+func testSessionExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	old := newSession(t, s, alice, "old", now.Add(-2*time.Hour))
+	newSession(t, s, alice, "new", now)
+	require.NoError(t, s.RevokeAccessToken(ctx, "jti-old", now.Add(-time.Hour)))
+	require.NoError(t, s.RevokeAccessToken(ctx, "jti-new", now.Add(time.Hour)))
+
+	deleted, err := s.DeleteExpiredSessions(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = s.RotateRefreshToken(ctx, old.TokenHash, models.RefreshToken{TokenHash: "x", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	assert.ErrorIs(t, err, storage.ErrRefreshTokenNotFound)
+
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti-new", "new")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = s.IsAccessTokenRevoked(ctx, "jti-old", "new")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func testRegisterUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+
+	user, err := s.RegisterUser(ctx, models.NewUser{Username: "alice", Email: "Alice@Example.com", PasswordHash: "hash"}, now)
+	require.NoError(t, err)
+	assert.NotZero(t, user.ID)
+	assert.Equal(t, "Alice@Example.com", user.Email)
+	assert.Equal(t, 1000, user.Coins)
+
+	stored, err := s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice@Example.com", stored.Email)
+	assert.Equal(t, "hash", stored.PasswordHash)
+
+	_, err = s.RegisterUser(ctx, models.NewUser{Username: "alice", PasswordHash: "hash"}, now)
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+	_, err = s.RegisterUser(ctx, models.NewUser{Username: "alice2", Email: "alice@example.com", PasswordHash: "hash"}, now)
+	assert.ErrorIs(t, err, storage.ErrEmailExists)
+	_, err = s.RegisterUser(ctx, models.NewUser{Username: "bad name", PasswordHash: "hash"}, now)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+
+	// Email is optional and several users may leave it empty.
+	_, err = s.RegisterUser(ctx, models.NewUser{Username: "bob", PasswordHash: "hash"}, now)
+	require.NoError(t, err)
+	_, err = s.RegisterUser(ctx, models.NewUser{Username: "carol", PasswordHash: "hash"}, now)
+	require.NoError(t, err)
+}
+
+func testRegisterUserWithInvite(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	expired := now.Add(-time.Minute)
+
+	require.NoError(t, s.CreateInviteCode(ctx, models.InviteCode{Code: "twice", MaxUses: 2, CreatedAt: now}))
+	require.NoError(t, s.CreateInviteCode(ctx, models.InviteCode{Code: "expired", MaxUses: 1, CreatedAt: now, ExpiresAt: &expired}))
+	assert.ErrorIs(t, s.CreateInviteCode(ctx, models.InviteCode{Code: "twice", MaxUses: 1, CreatedAt: now}), storage.ErrInviteCodeExists)
+
+	register := func(username, code string) error {
+		_, err := s.RegisterUser(ctx, models.NewUser{Username: username, PasswordHash: "hash", InviteCode: code}, now)
+		return err
+	}
+
+	assert.ErrorIs(t, register("alice", "unknown"), storage.ErrInviteCodeInvalid)
+	assert.ErrorIs(t, register("alice", "expired"), storage.ErrInviteCodeInvalid)
+	_, err := s.GetUserByUsername(ctx, "alice")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound, "no user is created for an invalid code")
+
+	require.NoError(t, register("alice", "twice"))
+	// A failed registration does not use up the code.
+	assert.ErrorIs(t, register("alice", "twice"), storage.ErrUserExists)
+	require.NoError(t, register("bob", "twice"))
+	assert.ErrorIs(t, register("carol", "twice"), storage.ErrInviteCodeInvalid)
+}
+
+func testLoginThrottles(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	resetBefore := now.Add(-time.Hour)
+
+	throttle, err := s.GetLoginThrottle(ctx, models.ThrottleScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.Zero(t, throttle.Failures)
+	assert.Nil(t, throttle.BlockedUntil)
+
+	for want := 1; want <= 3; want++ {
+		failures, err := s.RecordLoginFailure(ctx, models.ThrottleScopeUsername, "alice", now, resetBefore)
+		require.NoError(t, err)
+		assert.Equal(t, want, failures)
+	}
+	// Scopes are counted separately.
+	failures, err := s.RecordLoginFailure(ctx, models.ThrottleScopeIP, "alice", now, resetBefore)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	until := now.Add(time.Minute)
+	require.NoError(t, s.BlockLogin(ctx, models.ThrottleScopeUsername, "alice", until, true))
+	throttle, err = s.GetLoginThrottle(ctx, models.ThrottleScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 3, throttle.Failures)
+	require.NotNil(t, throttle.BlockedUntil)
+	assert.True(t, until.Equal(*throttle.BlockedUntil))
+	assert.True(t, throttle.Locked)
+
+	// Failures older than resetBefore no longer count.
+	later := now.Add(2 * time.Hour)
+	failures, err = s.RecordLoginFailure(ctx, models.ThrottleScopeUsername, "alice", later, later.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	require.NoError(t, s.ResetLoginFailures(ctx, models.ThrottleScopeUsername, "alice"))
+	throttle, err = s.GetLoginThrottle(ctx, models.ThrottleScopeUsername, "alice")
+	require.NoError(t, err)
+	assert.Zero(t, throttle.Failures)
+	assert.False(t, throttle.Locked)
+
+	deleted, err := s.DeleteExpiredLoginThrottles(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	throttle, err = s.GetLoginThrottle(ctx, models.ThrottleScopeIP, "alice")
+	require.NoError(t, err)
+	assert.Zero(t, throttle.Failures)
+}
+
+func testAuditEvents(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	events := []models.AuditEvent{
+		{Type: models.AuditAccountLocked, Subject: "alice", IP: "10.0.0.1", Details: map[string]string{"failures": "10"}, CreatedAt: now},
+		{Type: models.AuditAccountLocked, Subject: "bob", CreatedAt: now},
+		{Type: models.AuditAccountUnlocked, Actor: "admin", Subject: "alice", CreatedAt: now},
+	}
+	for _, event := range events {
+		require.NoError(t, s.CreateAuditEvent(ctx, event))
+	}
+
+	all, err := s.ListAuditEvents(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, models.AuditAccountUnlocked, all[0].Type, "newest first")
+	assert.Equal(t, "admin", all[0].Actor)
+	assert.NotZero(t, all[0].ID)
+	assert.Equal(t, map[string]string{"failures": "10"}, all[2].Details)
+	assert.Equal(t, "10.0.0.1", all[2].IP)
+	assert.True(t, now.Equal(all[2].CreatedAt))
+
+	alice, err := s.ListAuditEvents(ctx, models.AuditFilter{Subject: "alice"})
+	require.NoError(t, err)
+	assert.Len(t, alice, 2)
+
+	locked, err := s.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditAccountLocked, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, locked, 1)
+	assert.Equal(t, "bob", locked[0].Subject)
+}
+
+func testChangePassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	newSession(t, s, alice, "alice-session", now)
+	newSession(t, s, bob, "bob-session", now)
+
+	require.NoError(t, s.UpdatePasswordHash(ctx, alice.ID, "rehashed"))
+	stored, err := s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "rehashed", stored.PasswordHash)
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti", "alice-session")
+	require.NoError(t, err)
+	assert.False(t, revoked, "rehashing keeps sessions")
+
+	require.NoError(t, s.ChangePassword(ctx, alice.ID, "changed", now))
+	stored, err = s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", stored.PasswordHash)
+	revoked, err = s.IsAccessTokenRevoked(ctx, "jti", "alice-session")
+	require.NoError(t, err)
+	assert.True(t, revoked, "changing the password revokes sessions")
+	revoked, err = s.IsAccessTokenRevoked(ctx, "jti", "bob-session")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.ErrorIs(t, s.UpdatePasswordHash(ctx, 999999, "hash"), storage.ErrUserNotFound)
+	assert.ErrorIs(t, s.ChangePassword(ctx, 999999, "hash", now), storage.ErrUserNotFound)
+}
+
+func testResetPassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+	newSession(t, s, alice, "alice-session", now)
+
+	token := func(hash string, expiresAt time.Time) models.PasswordResetToken {
+		return models.PasswordResetToken{UserID: alice.ID, TokenHash: hash, CreatedAt: now, ExpiresAt: expiresAt}
+	}
+	require.NoError(t, s.CreatePasswordResetToken(ctx, token("expired", now.Add(-time.Minute))))
+	_, err := s.ResetPassword(ctx, "expired", "new-hash", now)
+	assert.ErrorIs(t, err, storage.ErrResetTokenInvalid)
+
+	require.NoError(t, s.CreatePasswordResetToken(ctx, token("first", now.Add(time.Hour))))
+	require.NoError(t, s.CreatePasswordResetToken(ctx, token("second", now.Add(time.Hour))))
+	_, err = s.ResetPassword(ctx, "first", "new-hash", now)
+	assert.ErrorIs(t, err, storage.ErrResetTokenInvalid, "a new token replaces the earlier one")
+	_, err = s.ResetPassword(ctx, "unknown", "new-hash", now)
+	assert.ErrorIs(t, err, storage.ErrResetTokenInvalid)
+
+	user, err := s.ResetPassword(ctx, "second", "new-hash", now)
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	assert.Equal(t, "alice", user.Username)
+
+	stored, err := s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "new-hash", stored.PasswordHash)
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti", "alice-session")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = s.ResetPassword(ctx, "second", "other-hash", now)
+	assert.ErrorIs(t, err, storage.ErrResetTokenInvalid, "tokens are single use")
+}
+
+func testTwoFactor(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+
+	_, err := s.GetTOTP(ctx, alice.ID)
+	assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+	assert.ErrorIs(t, s.ConfirmTOTP(ctx, alice.ID, 1, nil, now), storage.ErrTOTPNotFound)
+	assert.ErrorIs(t, s.SetPendingTOTP(ctx, 999999, "SECRET", now), storage.ErrUserNotFound)
+
+	// Enrolling again before confirming replaces the secret.
+	require.NoError(t, s.SetPendingTOTP(ctx, alice.ID, "FIRST", now))
+	require.NoError(t, s.SetPendingTOTP(ctx, alice.ID, "SECOND", now))
+	pending, err := s.GetTOTP(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SECOND", pending.Secret)
+	assert.Nil(t, pending.ConfirmedAt)
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, alice.ID, 100), storage.ErrTOTPCodeReused, "pending secrets do not log in")
+
+	require.NoError(t, s.ConfirmTOTP(ctx, alice.ID, 100, []string{"code-1", "code-2"}, now))
+	confirmed, err := s.GetTOTP(ctx, alice.ID)
+	require.NoError(t, err)
+	require.NotNil(t, confirmed.ConfirmedAt)
+	assert.Equal(t, int64(100), confirmed.LastUsedStep)
+	assert.ErrorIs(t, s.SetPendingTOTP(ctx, alice.ID, "THIRD", now), storage.ErrTOTPEnabled)
+
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, alice.ID, 100), storage.ErrTOTPCodeReused)
+	require.NoError(t, s.UseTOTPStep(ctx, alice.ID, 101))
+	assert.ErrorIs(t, s.UseTOTPStep(ctx, alice.ID, 101), storage.ErrTOTPCodeReused)
+
+	require.NoError(t, s.UseRecoveryCode(ctx, alice.ID, "code-1", now))
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, alice.ID, "code-1", now), storage.ErrRecoveryCodeInvalid)
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, alice.ID, "unknown", now), storage.ErrRecoveryCodeInvalid)
+
+	require.NoError(t, s.DeleteTOTP(ctx, alice.ID))
+	_, err = s.GetTOTP(ctx, alice.ID)
+	assert.ErrorIs(t, err, storage.ErrTOTPNotFound)
+	assert.ErrorIs(t, s.UseRecoveryCode(ctx, alice.ID, "code-2", now), storage.ErrRecoveryCodeInvalid)
+	assert.ErrorIs(t, s.DeleteTOTP(ctx, alice.ID), storage.ErrTOTPNotFound)
+}
+
+func testRoles(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	alice := createUser(t, s, "alice")
+
+	roles, err := s.GetUserRoles(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleUser}, roles)
+
+	require.NoError(t, s.GrantRole(ctx, alice.ID, models.RoleAuditor, now))
+	require.NoError(t, s.GrantRole(ctx, alice.ID, models.RoleAdmin, now))
+	require.NoError(t, s.GrantRole(ctx, alice.ID, models.RoleAdmin, now), "granting twice is a no-op")
+	roles, err = s.GetUserRoles(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleUser, models.RoleAdmin, models.RoleAuditor}, roles)
+
+	assert.ErrorIs(t, s.GrantRole(ctx, alice.ID, models.RoleUser, now), validation.ErrInvalid)
+	assert.ErrorIs(t, s.GrantRole(ctx, alice.ID, "root", now), validation.ErrInvalid)
+	assert.ErrorIs(t, s.GrantRole(ctx, 999999, models.RoleAdmin, now), storage.ErrUserNotFound)
+
+	require.NoError(t, s.RevokeRole(ctx, alice.ID, models.RoleAdmin))
+	assert.ErrorIs(t, s.RevokeRole(ctx, alice.ID, models.RoleAdmin), storage.ErrRoleNotGranted)
+	assert.ErrorIs(t, s.RevokeRole(ctx, alice.ID, models.RoleUser), validation.ErrInvalid)
+	roles, err = s.GetUserRoles(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleUser, models.RoleAuditor}, roles)
+}
+
+func testCatalog(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	items, err := s.ListItems(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, items, 10)
+	assert.Equal(t, models.Item{ID: 1, Name: "t-shirt", Price: 80}, items[0])
+
+	sticker, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "sticker", Price: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "sticker", sticker.Name)
+	assert.Equal(t, 5, sticker.Price)
+	assert.Nil(t, sticker.ArchivedAt)
+	_, err = s.CreateItem(ctx, models.CreateItemRequest{Name: "cup", Price: 5})
+	assert.ErrorIs(t, err, storage.ErrItemExists)
+	_, err = s.CreateItem(ctx, models.CreateItemRequest{Name: "Big Cup", Price: 0})
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+
+	name, price := "sticker-pack", 15
+	updated, err := s.UpdateItem(ctx, sticker.ID, models.ItemUpdate{Name: &name})
+	require.NoError(t, err)
+	assert.Equal(t, models.Item{ID: sticker.ID, Name: "sticker-pack", Price: 5}, *updated)
+	updated, err = s.UpdateItem(ctx, sticker.ID, models.ItemUpdate{Price: &price})
+	require.NoError(t, err)
+	assert.Equal(t, models.Item{ID: sticker.ID, Name: "sticker-pack", Price: 15}, *updated)
+
+	taken := "cup"
+	_, err = s.UpdateItem(ctx, sticker.ID, models.ItemUpdate{Name: &taken})
+	assert.ErrorIs(t, err, storage.ErrItemExists)
+	_, err = s.UpdateItem(ctx, 999999, models.ItemUpdate{Price: &price})
+	assert.ErrorIs(t, err, storage.ErrItemNotFound)
+	zero := 0
+	_, err = s.UpdateItem(ctx, sticker.ID, models.ItemUpdate{Price: &zero})
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+
+	archived, err := s.ArchiveItem(ctx, sticker.ID, now)
+	require.NoError(t, err)
+	require.NotNil(t, archived.ArchivedAt)
+	assert.True(t, now.Equal(*archived.ArchivedAt))
+	again, err := s.ArchiveItem(ctx, sticker.ID, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(*again.ArchivedAt), "archiving again keeps the archive time")
+	_, err = s.ArchiveItem(ctx, 999999, now)
+	assert.ErrorIs(t, err, storage.ErrItemNotFound)
+
+	items, err = s.ListItems(ctx, false)
+	require.NoError(t, err)
+	assert.Len(t, items, 10)
+	items, err = s.ListItems(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, items, 11)
+	assert.Equal(t, "sticker-pack", items[10].Name)
+}
+
+func testBuyArchivedItem(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	require.NoError(t, s.BuyItem(ctx, "alice", "cup"))
+
+	_, err := s.ArchiveItem(ctx, 2, time.Now())
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.BuyItem(ctx, "alice", "cup"), storage.ErrItemArchived)
+
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "cup", Quantity: 1}}, inventory, "archived items stay in inventories")
+	user, err := s.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 980, user.Coins)
+}
+
+func testItemStock(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	stock, threshold := 2, 1
+
+	hoodie, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "limited-hoody", Price: 100, Stock: &stock, LowStockThreshold: &threshold})
+	require.NoError(t, err)
+	require.NotNil(t, hoodie.Stock)
+	assert.Equal(t, 2, *hoodie.Stock)
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "limited-hoody"))
+	events, err := s.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditItemLowStock})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "limited-hoody", events[0].Subject)
+	assert.Equal(t, map[string]string{"id": strconv.Itoa(hoodie.ID), "stock": "1", "threshold": "1"}, events[0].Details)
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "limited-hoody"))
+	assert.ErrorIs(t, s.BuyItem(ctx, "alice", "limited-hoody"), storage.ErrOutOfStock)
+	assert.Equal(t, 800, balance(t, s, "alice"))
+	events, err = s.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditItemLowStock})
+	require.NoError(t, err)
+	assert.Len(t, events, 1, "the event is recorded once per crossing")
+
+	restocked, err := s.RestockItem(ctx, hoodie.ID, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 5, *restocked.Stock)
+	require.NoError(t, s.BuyItem(ctx, "alice", "limited-hoody"))
+
+	items, err := s.ListItems(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, *items[len(items)-1].Stock)
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "limited-hoody", Quantity: 3}}, inventory)
+
+	// Restocking an item with unlimited supply limits it.
+	cup, err := s.RestockItem(ctx, 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, *cup.Stock)
+	assert.Nil(t, cup.LowStockThreshold)
+
+	_, err = s.RestockItem(ctx, hoodie.ID, 0)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+	_, err = s.RestockItem(ctx, 999999, 1)
+	assert.ErrorIs(t, err, storage.ErrItemNotFound)
+	negative := -1
+	_, err = s.CreateItem(ctx, models.CreateItemRequest{Name: "sticker", Price: 5, Stock: &negative})
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+}
+
+func testConcurrentBuyLimitedItem(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const buyers, stock = 10, 4
+	for i := 0; i < buyers; i++ {
+		createUser(t, s, fmt.Sprintf("buyer%d", i))
+	}
+	limit := stock
+	_, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "limited-cup", Price: 20, Stock: &limit})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold := 0
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.BuyItem(ctx, fmt.Sprintf("buyer%d", i), "limited-cup")
+			if err != nil {
+				assert.ErrorIs(t, err, storage.ErrOutOfStock)
+				return
+			}
+			mu.Lock()
+			sold++
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, stock, sold)
+	items, err := s.ListItems(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, *items[len(items)-1].Stock)
+}
+
+func testCreateOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	now := time.Now().UTC().Truncate(time.Second)
+	stock, threshold := 5, 2
+	_, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "sticker", Price: 5, Stock: &stock, LowStockThreshold: &threshold})
+	require.NoError(t, err)
+
+	order, err := s.CreateOrder(ctx, "alice", []models.OrderLine{
+		{Item: "cup", Quantity: 2},
+		{Item: "sticker", Quantity: 4},
+		{Item: "t-shirt", Quantity: 1},
+	}, now)
+	require.NoError(t, err)
+	assert.NotZero(t, order.ID)
+	assert.Equal(t, []models.OrderItem{
+		{Item: "cup", Quantity: 2, Price: 20, Amount: 40},
+		{Item: "sticker", Quantity: 4, Price: 5, Amount: 20},
+		{Item: "t-shirt", Quantity: 1, Price: 80, Amount: 80},
+	}, order.Items)
+	assert.Equal(t, 140, order.Total)
+	assert.True(t, now.Equal(order.CreatedAt))
+	assert.Equal(t, 860, balance(t, s, "alice"))
+
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []models.InventoryItem{
+		{Type: "cup", Quantity: 2},
+		{Type: "sticker", Quantity: 4},
+		{Type: "t-shirt", Quantity: 1},
+	}, inventory)
+
+	// A purchase of several units crosses the threshold at once.
+	events, err := s.ListAuditEvents(ctx, models.AuditFilter{Type: models.AuditItemLowStock})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "1", events[0].Details["stock"])
+
+	next, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "cup", Quantity: 1}}, now)
+	require.NoError(t, err)
+	assert.Greater(t, next.ID, order.ID)
+}
+
+func testCreateOrderErrors(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	stock := 1
+	_, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "sticker", Price: 5, Stock: &stock})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		user  string
+		lines []models.OrderLine
+		err   error
+	}{
+		{"empty cart", "alice", nil, validation.ErrInvalid},
+		{"zero quantity", "alice", []models.OrderLine{{Item: "cup"}}, validation.ErrInvalid},
+		{"duplicate line", "alice", []models.OrderLine{{Item: "cup", Quantity: 1}, {Item: "cup", Quantity: 1}}, validation.ErrInvalid},
+		{"unknown item", "alice", []models.OrderLine{{Item: "cup", Quantity: 1}, {Item: "yacht", Quantity: 1}}, storage.ErrItemNotFound},
+		{"out of stock", "alice", []models.OrderLine{{Item: "cup", Quantity: 1}, {Item: "sticker", Quantity: 2}}, storage.ErrOutOfStock},
+		{"insufficient coins", "alice", []models.OrderLine{{Item: "pink-hoody", Quantity: 2}, {Item: "cup", Quantity: 1}}, storage.ErrInsufficientCoins},
+		{"unknown user", "nobody", []models.OrderLine{{Item: "cup", Quantity: 1}}, storage.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CreateOrder(ctx, tt.user, tt.lines, time.Now())
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	// Failed orders change nothing.
+	assert.Equal(t, 1000, balance(t, s, "alice"))
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, inventory)
+	items, err := s.ListItems(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *items[len(items)-1].Stock)
+}
+
+func testListOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	createUser(t, s, "alice")
+	createUser(t, s, "bob")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	first, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "t-shirt", Quantity: 1}, {Item: "cup", Quantity: 2}}, now)
+	require.NoError(t, err)
+	_, err = s.CreateOrder(ctx, "bob", []models.OrderLine{{Item: "pen", Quantity: 1}}, now)
+	require.NoError(t, err)
+	second, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "book", Quantity: 1}}, now)
+	require.NoError(t, err)
+
+	orders, err := s.ListOrders(ctx, models.OrderFilter{Username: "alice"})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, second.ID, orders[0].ID, "newest first")
+	assert.Equal(t, models.Order{
+		ID:       first.ID,
+		Username: "alice",
+		Status:   models.OrderPlaced,
+		Items: []models.OrderItem{
+			{Item: "t-shirt", Quantity: 1, Price: 80, Amount: 80},
+			{Item: "cup", Quantity: 2, Price: 20, Amount: 40},
+		},
+		Total:     120,
+		CreatedAt: now,
+	}, orders[1])
+
+	// Receipts keep the price paid.
+	price := 25
+	_, err = s.UpdateItem(ctx, 2, models.ItemUpdate{Price: &price})
+	require.NoError(t, err)
+	orders, err = s.ListOrders(ctx, models.OrderFilter{Username: "alice", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, second.ID, orders[0].ID)
+
+	orders, err = s.ListOrders(ctx, models.OrderFilter{})
+	require.NoError(t, err)
+	assert.Len(t, orders, 3)
+	assert.Equal(t, 20, orders[2].Items[1].Price)
+
+	orders, err = s.ListOrders(ctx, models.OrderFilter{Status: models.OrderDelivered})
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+}
+
+func testListPurchases(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	first, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "t-shirt", Quantity: 1}, {Item: "cup", Quantity: 2}}, now)
+	require.NoError(t, err)
+	require.NoError(t, s.BuyItem(ctx, "bob", "pen"))
+	second, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "book", Quantity: 1}}, now.Add(time.Second))
+	require.NoError(t, err)
+	_, err = s.SetOrderStatus(ctx, first.ID, models.OrderCancelled, now)
+	require.NoError(t, err)
+
+	purchases, err := s.ListPurchases(ctx, alice.ID, models.PurchaseFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, purchases, 2)
+	assert.Equal(t, models.Purchase{
+		ID:        purchases[0].ID,
+		OrderID:   second.ID,
+		Item:      "book",
+		Quantity:  1,
+		Price:     50,
+		Amount:    50,
+		Status:    models.OrderPlaced,
+		CreatedAt: now.Add(time.Second),
+	}, purchases[0])
+	assert.Equal(t, models.Purchase{
+		ID:        purchases[1].ID,
+		OrderID:   first.ID,
+		Item:      "cup",
+		Quantity:  2,
+		Price:     20,
+		Amount:    40,
+		Status:    models.OrderCancelled,
+		CreatedAt: now,
+	}, purchases[1], "cancelled purchases stay in the history")
+
+	last := purchases[1]
+	purchases, err = s.ListPurchases(ctx, alice.ID, models.PurchaseFilter{
+		After: &models.TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID},
+	})
+	require.NoError(t, err)
+	require.Len(t, purchases, 1)
+	assert.Equal(t, "t-shirt", purchases[0].Item)
+	assert.Less(t, purchases[0].ID, last.ID)
+
+	purchases, err = s.ListPurchases(ctx, 999999, models.PurchaseFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, purchases)
+}
+
+func testOrderLifecycle(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	createUser(t, s, "alice")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	order, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "cup", Quantity: 1}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderPlaced, order.Status)
+
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderDelivered, now)
+	assert.ErrorIs(t, err, storage.ErrOrderStatusTransition, "orders are ready before they are delivered")
+
+	ready, err := s.SetOrderStatus(ctx, order.ID, models.OrderReadyForPickup, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderReadyForPickup, ready.Status)
+	require.NotNil(t, ready.ReadyAt)
+	assert.True(t, now.Add(time.Hour).Equal(*ready.ReadyAt))
+	assert.Equal(t, []models.OrderItem{{Item: "cup", Quantity: 1, Price: 20, Amount: 20}}, ready.Items)
+
+	delivered, err := s.SetOrderStatus(ctx, order.ID, models.OrderDelivered, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderDelivered, delivered.Status)
+	require.NotNil(t, delivered.DeliveredAt)
+	assert.NotNil(t, delivered.ReadyAt)
+	assert.Nil(t, delivered.CancelledAt)
+
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderCancelled, now)
+	assert.ErrorIs(t, err, storage.ErrOrderStatusTransition, "delivered orders cannot be cancelled")
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderPlaced, now)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+	_, err = s.SetOrderStatus(ctx, 999999, models.OrderCancelled, now)
+	assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	assert.Equal(t, 980, balance(t, s, "alice"))
+}
+
+func testCancelOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	now := time.Now().UTC().Truncate(time.Second)
+	stock := 3
+	_, err := s.CreateItem(ctx, models.CreateItemRequest{Name: "sticker", Price: 5, Stock: &stock})
+	require.NoError(t, err)
+
+	require.NoError(t, s.BuyItem(ctx, "alice", "cup"))
+	order, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "cup", Quantity: 2}, {Item: "sticker", Quantity: 3}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 925, balance(t, s, "alice"))
+
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderReadyForPickup, now)
+	require.NoError(t, err)
+	cancelled, err := s.SetOrderStatus(ctx, order.ID, models.OrderCancelled, now)
+	require.NoError(t, err)
+	assert.Equal(t, models.OrderCancelled, cancelled.Status)
+	assert.NotNil(t, cancelled.CancelledAt)
+
+	// The refund returns coins, inventory and stock.
+	assert.Equal(t, 980, balance(t, s, "alice"))
+	inventory, err := s.GetUserInventory(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.InventoryItem{{Type: "cup", Quantity: 1}}, inventory)
+	items, err := s.ListItems(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, *items[len(items)-1].Stock)
+
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderCancelled, now)
+	assert.ErrorIs(t, err, storage.ErrOrderStatusTransition, "orders are refunded once")
+	assert.Equal(t, 980, balance(t, s, "alice"))
+}
+
+// ledgerBalances checks that every user's cached balance matches the
+// ledger and that the ledger as a whole sums to zero.
+func ledgerBalances(t *testing.T, s storage.Storage, users ...*models.User) {
+	t.Helper()
+	ctx := context.Background()
+
+	txns, err := s.ListLedgerTransactions(ctx, models.LedgerFilter{})
+	require.NoError(t, err)
+	for _, txn := range txns {
+		var debit, credit int
+		for _, line := range txn.Lines {
+			debit += line.Debit
+			credit += line.Credit
+		}
+		assert.Equal(t, debit, credit, "transaction %d is balanced", txn.ID)
+	}
+
+	total := 0
+	for _, account := range []string{models.AccountIssuance, models.AccountShop, models.AccountAdjustments} {
+		balance, err := s.GetLedgerBalance(ctx, account)
+		require.NoError(t, err)
+		total += balance
+	}
+	for _, user := range users {
+		ledger, err := s.GetLedgerBalance(ctx, models.UserAccount(user.ID))
+		require.NoError(t, err)
+		assert.Equal(t, ledger, balance(t, s, user.Username), "%s caches the ledger balance", user.Username)
+		total += ledger
+	}
+	assert.Zero(t, total)
+}
+
+func testLedger(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	now := time.Now()
+	ledgerBalances(t, s, alice, bob)
+
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 100))
+	order, err := s.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "cup", Quantity: 2}}, now)
+	require.NoError(t, err)
+	require.NoError(t, s.BuyItem(ctx, "bob", "pen"))
+	_, err = s.SetOrderStatus(ctx, order.ID, models.OrderCancelled, now)
+	require.NoError(t, err)
+	ledgerBalances(t, s, alice, bob)
+
+	txns, err := s.ListLedgerTransactions(ctx, models.LedgerFilter{Account: models.UserAccount(alice.ID)})
+	require.NoError(t, err)
+	var kinds []string
+	for _, txn := range txns {
+		kinds = append(kinds, txn.Kind)
+	}
+	assert.Equal(t, []string{models.LedgerRefund, models.LedgerPurchase, models.LedgerTransfer, models.LedgerGrant}, kinds)
+
+	reference := "order:" + strconv.Itoa(order.ID)
+	assert.Equal(t, reference, txns[0].Reference)
+	assert.Equal(t, []models.LedgerLine{
+		{Account: models.AccountShop, Debit: 40},
+		{Account: models.UserAccount(alice.ID), Credit: 40},
+	}, txns[0].Lines)
+	assert.Equal(t, []models.LedgerLine{
+		{Account: models.UserAccount(alice.ID), Debit: 40},
+		{Account: models.AccountShop, Credit: 40},
+	}, txns[1].Lines)
+	assert.Equal(t, []models.LedgerLine{
+		{Account: models.UserAccount(alice.ID), Debit: 100},
+		{Account: models.UserAccount(bob.ID), Credit: 100},
+	}, txns[2].Lines)
+
+	shop, err := s.GetLedgerBalance(ctx, models.AccountShop)
+	require.NoError(t, err)
+	assert.Equal(t, 10, shop)
+	assert.Equal(t, 900, balance(t, s, "alice"))
+	assert.Equal(t, 1090, balance(t, s, "bob"))
+
+	txns, err = s.ListLedgerTransactions(ctx, models.LedgerFilter{Limit: 2})
+	require.NoError(t, err)
+	assert.Len(t, txns, 2)
+}
+
+func testAdjustCoins(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	now := time.Now()
+
+	coins, err := s.AdjustCoins(ctx, alice.ID, 50, "conference prize", now)
+	require.NoError(t, err)
+	assert.Equal(t, 1050, coins)
+	coins, err = s.AdjustCoins(ctx, alice.ID, -30, "double grant", now)
+	require.NoError(t, err)
+	assert.Equal(t, 1020, coins)
+	assert.Equal(t, 1020, balance(t, s, "alice"))
+
+	_, err = s.AdjustCoins(ctx, alice.ID, -2000, "too much", now)
+	assert.ErrorIs(t, err, storage.ErrInsufficientCoins)
+	_, err = s.AdjustCoins(ctx, alice.ID, 0, "nothing", now)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+	_, err = s.AdjustCoins(ctx, alice.ID, 10, "", now)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+	_, err = s.AdjustCoins(ctx, 999999, 10, "ghost", now)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	txns, err := s.ListLedgerTransactions(ctx, models.LedgerFilter{Account: models.UserAccount(alice.ID), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, txns, 1)
+	assert.Equal(t, models.LedgerAdjustment, txns[0].Kind)
+	assert.Equal(t, "double grant", txns[0].Memo)
+	assert.Equal(t, []models.LedgerLine{
+		{Account: models.UserAccount(alice.ID), Debit: 30},
+		{Account: models.AccountAdjustments, Credit: 30},
+	}, txns[0].Lines)
+	ledgerBalances(t, s, alice)
+}
+
+// testBalanceMismatches only sees balances kept through the storage API,
+// which never drift; PostgreSQL tests cover drift caused behind its back.
+func testBalanceMismatches(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	createUser(t, s, "bob")
+	require.NoError(t, s.SendCoins(ctx, "alice", "bob", 100))
+	require.NoError(t, s.BuyItem(ctx, "bob", "pen"))
+
+	mismatches, err := s.ListBalanceMismatches(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	corrected, err := s.CorrectBalance(ctx, alice.ID, time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, corrected, "nothing to correct")
+	_, err = s.CorrectBalance(ctx, 999999, time.Now())
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUnitOfWork(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	// A failed unit leaves no trace.
+	errAbort := errors.New("abort")
+	err := s.InTx(ctx, nil, func(tx storage.Storage) error {
+		if err := tx.SendCoins(ctx, "alice", "bob", 100); err != nil {
+			return err
+		}
+		if _, err := tx.CreateUser(ctx, "carol", "hash"); err != nil {
+			return err
+		}
+		if _, err := tx.CreateOrder(ctx, "alice", []models.OrderLine{{Item: "pen", Quantity: 2}}, time.Now()); err != nil {
+			return err
+		}
+		assert.Equal(t, 880, balance(t, tx, "alice"), "the unit sees its own changes")
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+	assert.Equal(t, 1000, balance(t, s, "alice"))
+	assert.Equal(t, 1000, balance(t, s, "bob"))
+	_, err = s.GetUserByUsername(ctx, "carol")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	purchases, err := s.ListPurchases(ctx, alice.ID, models.PurchaseFilter{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, purchases)
+
+	// A successful one commits everything. Nested units join it.
+	err = s.InTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(tx storage.Storage) error {
+		if err := tx.SendCoins(ctx, "alice", "bob", 100); err != nil {
+			return err
+		}
+		return tx.InTx(ctx, nil, func(tx storage.Storage) error {
+			_, err := tx.AdjustCoins(ctx, alice.ID, 50, "conference prize", time.Now())
+			return err
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 950, balance(t, s, "alice"))
+	assert.Equal(t, 1100, balance(t, s, "bob"))
+
+	// A failed operation fails the unit, undoing the ones before it.
+	err = s.InTx(ctx, nil, func(tx storage.Storage) error {
+		if _, err := tx.AdjustCoins(ctx, bob.ID, 10, "bonus", time.Now()); err != nil {
+			return err
+		}
+		return tx.SendCoins(ctx, "alice", "bob", 5000)
+	})
+	assert.ErrorIs(t, err, storage.ErrInsufficientCoins)
+	assert.Equal(t, 1100, balance(t, s, "bob"))
+	ledgerBalances(t, s, alice, bob)
+}
+
+// testUnitOfWorkOutsideWrites checks that rolling a unit back keeps what
+// happened outside it meanwhile, and that the unit's changes stay hidden.
+func testUnitOfWorkOutsideWrites(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+	carol := createUser(t, s, "carol")
+
+	errAbort := errors.New("abort")
+	var (
+		done       = make(chan struct{})
+		aliceCoins int
+		sendErr    error
+	)
+	err := s.InTx(ctx, nil, func(tx storage.Storage) error {
+		if _, err := tx.AdjustCoins(ctx, alice.ID, 50, "conference prize", time.Now()); err != nil {
+			return err
+		}
+		go func() {
+			defer close(done)
+			sendErr = s.SendCoins(ctx, "bob", "carol", 100)
+			if user, err := s.GetUserByUsername(ctx, "alice"); err == nil {
+				aliceCoins = user.Coins
+			}
+		}()
+		// Give the outside writes the chance to run before the rollback;
+		// a storage may as well make them wait for the unit to end.
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+		}
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+	<-done
+
+	require.NoError(t, sendErr)
+	assert.Equal(t, 1000, aliceCoins, "the unit's changes are hidden until it commits")
+	assert.Equal(t, 1000, balance(t, s, "alice"))
+	assert.Equal(t, 900, balance(t, s, "bob"))
+	assert.Equal(t, 1100, balance(t, s, "carol"))
+	ledgerBalances(t, s, alice, bob, carol)
+}
@@ -0,0 +1,57 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at a time.
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easy to confuse.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCodes returns RecoveryCodeCount single-use codes such as
+// "k7m2p-x9qrt" and their hashes for storage.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b, err := randomString(10)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := b[:5] + "-" + b[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// randomString draws n characters from recoveryAlphabet, skipping bytes
+// that would bias the choice.
+func randomString(n int) (string, error) {
+	limit := byte(256 / len(recoveryAlphabet) * len(recoveryAlphabet))
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, c := range buf {
+			if c < limit && len(out) < n {
+				out = append(out, recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// HashRecoveryCode returns the form in which recovery codes are stored.
+// Case, spaces and dashes are ignored.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+// Package validation checks request models and reports field-level errors
+// with stable codes that clients can rely on.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Error codes reported in FieldError.Code.
+const (
+	CodeRequired      = "required"
+	CodeTooLong       = "too_long"
+	CodeInvalidFormat = "invalid_format"
+	CodeNotPositive   = "not_positive"
+	CodeNegative      = "negative"
+	CodeTooLarge      = "too_large"
+	CodeDuplicate     = "duplicate"
+	CodeSelfTransfer  = "self_transfer"
+)
+
+const (
+	// MaxUsernameLength matches users.username VARCHAR(255).
+	MaxUsernameLength = 255
+	// MaxPasswordBytes is the longest password bcrypt can hash.
+	MaxPasswordBytes = 72
+	// MaxEmailLength matches users.email VARCHAR(255).
+	MaxEmailLength = 255
+	// MaxItemNameLength matches merch_items.name VARCHAR(255).
+	MaxItemNameLength = 255
+	// MaxOrderLines and MaxOrderQuantity bound a cart, keeping order totals
+	// far from integer overflow.
+	MaxOrderLines    = 50
+	MaxOrderQuantity = 1000
+	// MaxMemoLength matches ledger_transactions.memo VARCHAR(255).
+	MaxMemoLength = 255
+)
+
+// ErrInvalid matches any Errors value with errors.Is.
+var ErrInvalid = errors.New("validation failed")
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors is the error returned when validation fails.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return ErrInvalid.Error() + ": " + strings.Join(parts, "; ")
+}
+
+func (e Errors) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Validatable is implemented by request models.
+type Validatable interface {
+	Validate() error
+}
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+func (v *Validator) Add(field, code, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns the collected errors as Errors, or nil if there are none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (v *Validator) Required(field, value string) bool {
+	if value == "" {
+		v.Add(field, CodeRequired, "must not be empty")
+		return false
+	}
+	return true
+}
+
+// Username accepts up to MaxUsernameLength printable characters without spaces.
+func (v *Validator) Username(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if utf8.RuneCountInString(value) > MaxUsernameLength {
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be at most %d characters", MaxUsernameLength))
+		return
+	}
+	for _, r := range value {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			v.Add(field, CodeInvalidFormat, "must not contain spaces or control characters")
+			return
+		}
+	}
+}
+
+func (v *Validator) Password(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if len(value) > MaxPasswordBytes {
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be at most %d bytes", MaxPasswordBytes))
+	}
+}
+
+// Email accepts an empty value or a bare address such as user@example.com.
+func (v *Validator) Email(field, value string) {
+	if value == "" {
+		return
+	}
+	if utf8.RuneCountInString(value) > MaxEmailLength {
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be at most %d characters", MaxEmailLength))
+		return
+	}
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value || addr.Name != "" {
+		v.Add(field, CodeInvalidFormat, "must be an email address")
+	}
+}
+
+// ItemName accepts lowercase words of letters and digits joined by
+// hyphens, such as pink-hoody, so that names are safe in URLs.
+func (v *Validator) ItemName(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+	if len(value) > MaxItemNameLength {
+		v.Add(field, CodeTooLong, fmt.Sprintf("must be at most %d characters", MaxItemNameLength))
+		return
+	}
+	for i, r := range value {
+		hyphen := r == '-' && i > 0 && i < len(value)-1 && value[i-1] != '-'
+		if !hyphen && (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			v.Add(field, CodeInvalidFormat, "must be lowercase letters and digits separated by single hyphens")
+			return
+		}
+	}
+}
+
+func (v *Validator) Positive(field string, value int) {
+	if value <= 0 {
+		v.Add(field, CodeNotPositive, "must be greater than zero")
+	}
+}
+
+func (v *Validator) NotNegative(field string, value int) {
+	if value < 0 {
+		v.Add(field, CodeNegative, "must not be negative")
+	}
+}
+
+// Transfer checks a coin transfer. It is shared by the handlers and the
+// storage implementations so that both enforce the same rules.
+func Transfer(sender, receiver string, amount int) error {
+	var v Validator
+	v.Required("toUser", receiver)
+	v.Positive("amount", amount)
+	if sender != "" && sender == receiver {
+		v.Add("toUser", CodeSelfTransfer, "cannot send coins to yourself")
+	}
+	return v.Err()
+}
@@ -0,0 +1,195 @@
+package validation_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mi4r/avito-shop/internal/storage/models"
+	"github.com/mi4r/avito-shop/internal/validation"
+)
+
+func codes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var fields validation.Errors
+	if !errors.As(err, &fields) {
+		t.Fatalf("expected validation.Errors, got %T", err)
+	}
+	var out []string
+	for _, f := range fields {
+		out = append(out, f.Field+":"+f.Code)
+	}
+	return out
+}
+
+func TestAuthRequestValidate(t *testing.T) {
+	// Logins of accounts created before the format rules still get through.
+	tests := []struct {
+		name     string
+		request  models.AuthRequest
+		expected []string
+	}{
+		{"valid", models.AuthRequest{Username: "user1", Password: "pass123"}, nil},
+		{"empty", models.AuthRequest{}, []string{"username:required", "password:required"}},
+		{"username with spaces", models.AuthRequest{Username: "user 1", Password: "p"}, nil},
+		{"password too long", models.AuthRequest{Username: "user1", Password: strings.Repeat("p", 73)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.Validate()))
+		})
+	}
+}
+
+func TestAuthRequestValidateRegistration(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  models.AuthRequest
+		expected []string
+	}{
+		{"valid", models.AuthRequest{Username: "user1", Password: "pass123"}, nil},
+		{"empty", models.AuthRequest{}, []string{"username:required", "password:required"}},
+		{"username with spaces", models.AuthRequest{Username: "user 1", Password: "p"}, []string{"username:invalid_format"}},
+		{"username with control chars", models.AuthRequest{Username: "user\x00", Password: "p"}, []string{"username:invalid_format"}},
+		{"username too long", models.AuthRequest{Username: strings.Repeat("u", 256), Password: "p"}, []string{"username:too_long"}},
+		{"password too long", models.AuthRequest{Username: "user1", Password: strings.Repeat("p", 73)}, []string{"password:too_long"}},
+		{"with email", models.AuthRequest{Username: "user1", Password: "p", Email: "user1@example.com"}, nil},
+		{"invalid email", models.AuthRequest{Username: "user1", Password: "p", Email: "not-an-email"}, []string{"email:invalid_format"}},
+		{"email with display name", models.AuthRequest{Username: "user1", Password: "p", Email: "User <user1@example.com>"}, []string{"email:invalid_format"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.ValidateRegistration()))
+		})
+	}
+}
+
+func TestSendCoinRequestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  models.SendCoinRequest
+		expected []string
+	}{
+		{"valid", models.SendCoinRequest{ToUser: "bob", Amount: 1}, nil},
+		{"zero amount", models.SendCoinRequest{ToUser: "bob"}, []string{"amount:not_positive"}},
+		{"negative amount", models.SendCoinRequest{ToUser: "bob", Amount: -10}, []string{"amount:not_positive"}},
+		{"no receiver", models.SendCoinRequest{Amount: 10}, []string{"toUser:required"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.Validate()))
+		})
+	}
+}
+
+func TestCreateItemRequestValidate(t *testing.T) {
+	zero, five, minusOne := 0, 5, -1
+
+	tests := []struct {
+		name     string
+		request  models.CreateItemRequest
+		expected []string
+	}{
+		{"valid", models.CreateItemRequest{Name: "pink-hoody", Price: 500}, nil},
+		{"with digits", models.CreateItemRequest{Name: "mug2", Price: 1}, nil},
+		{"empty", models.CreateItemRequest{}, []string{"name:required", "price:not_positive"}},
+		{"uppercase", models.CreateItemRequest{Name: "Cup", Price: 10}, []string{"name:invalid_format"}},
+		{"spaces", models.CreateItemRequest{Name: "pink hoody", Price: 10}, []string{"name:invalid_format"}},
+		{"leading hyphen", models.CreateItemRequest{Name: "-cup", Price: 10}, []string{"name:invalid_format"}},
+		{"trailing hyphen", models.CreateItemRequest{Name: "cup-", Price: 10}, []string{"name:invalid_format"}},
+		{"double hyphen", models.CreateItemRequest{Name: "pink--hoody", Price: 10}, []string{"name:invalid_format"}},
+		{"too long", models.CreateItemRequest{Name: strings.Repeat("a", 256), Price: 10}, []string{"name:too_long"}},
+		{"negative price", models.CreateItemRequest{Name: "cup", Price: -1}, []string{"price:not_positive"}},
+		{"limited", models.CreateItemRequest{Name: "cup", Price: 10, Stock: &zero, LowStockThreshold: &five}, nil},
+		{"negative stock", models.CreateItemRequest{Name: "cup", Price: 10, Stock: &minusOne, LowStockThreshold: &minusOne}, []string{"stock:negative", "lowStockThreshold:negative"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.Validate()))
+		})
+	}
+}
+
+func TestItemUpdateValidate(t *testing.T) {
+	name, badName := "mug", "Mug"
+	price, zero := 30, 0
+
+	tests := []struct {
+		name     string
+		update   models.ItemUpdate
+		expected []string
+	}{
+		{"rename", models.ItemUpdate{Name: &name}, nil},
+		{"reprice", models.ItemUpdate{Price: &price}, nil},
+		{"both", models.ItemUpdate{Name: &name, Price: &price}, nil},
+		{"nothing", models.ItemUpdate{}, []string{"name:required"}},
+		{"invalid name", models.ItemUpdate{Name: &badName}, []string{"name:invalid_format"}},
+		{"zero price", models.ItemUpdate{Price: &zero}, []string{"price:not_positive"}},
+		{"threshold", models.ItemUpdate{LowStockThreshold: &zero}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.update.Validate()))
+		})
+	}
+}
+
+func TestCreateOrderRequestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  models.CreateOrderRequest
+		expected []string
+	}{
+		{"valid", models.CreateOrderRequest{Items: []models.OrderLine{{Item: "cup", Quantity: 2}, {Item: "pen", Quantity: 1}}}, nil},
+		{"empty", models.CreateOrderRequest{}, []string{"items:required"}},
+		{"invalid line", models.CreateOrderRequest{Items: []models.OrderLine{{Quantity: 0}}}, []string{"items[0].item:required", "items[0].quantity:not_positive"}},
+		{"duplicate", models.CreateOrderRequest{Items: []models.OrderLine{{Item: "cup", Quantity: 1}, {Item: "cup", Quantity: 1}}}, []string{"items[1].item:duplicate"}},
+		{"too many units", models.CreateOrderRequest{Items: []models.OrderLine{{Item: "cup", Quantity: 1001}}}, []string{"items[0].quantity:too_large"}},
+		{"too many lines", models.CreateOrderRequest{Items: make([]models.OrderLine, 51)}, []string{"items:too_long"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.Validate()))
+		})
+	}
+}
+
+func TestAdjustmentRequestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  models.AdjustmentRequest
+		expected []string
+	}{
+		{"credit", models.AdjustmentRequest{Amount: 10, Reason: "bonus"}, nil},
+		{"debit", models.AdjustmentRequest{Amount: -10, Reason: "duplicate grant"}, nil},
+		{"zero", models.AdjustmentRequest{Reason: "noop"}, []string{"amount:required"}},
+		{"no reason", models.AdjustmentRequest{Amount: 10}, []string{"reason:required"}},
+		{"long reason", models.AdjustmentRequest{Amount: 10, Reason: strings.Repeat("x", 256)}, []string{"reason:too_long"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, codes(t, tt.request.Validate()))
+		})
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	assert.NoError(t, validation.Transfer("alice", "bob", 10))
+	assert.Equal(t, []string{"toUser:self_transfer"}, codes(t, validation.Transfer("alice", "alice", 10)))
+
+	err := validation.Transfer("alice", "alice", -1)
+	assert.ErrorIs(t, err, validation.ErrInvalid)
+	assert.Equal(t, "validation failed: amount: must be greater than zero; toUser: cannot send coins to yourself", err.Error())
+}